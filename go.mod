@@ -1,8 +1,9 @@
 module github.com/obase/mongodb
 
-go 1.12
+go 1.13
 
 require (
 	github.com/obase/conf v1.10.7
 	go.mongodb.org/mongo-driver v1.4.0
+	gopkg.in/yaml.v2 v2.3.0
 )
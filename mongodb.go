@@ -3,6 +3,9 @@ package mongodb
 import (
 	"fmt"
 	"github.com/obase/conf"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
 	"time"
 )
 
@@ -82,14 +85,41 @@ type Config struct {
 	// 重试机制
 	RetryReads  bool `json:"retryReads" yaml:"retryReads"`   // 重试读(3.6)
 	RetryWrites bool `json:"retryWrites" yaml:"retryWrites"` // 重试写(3.6)
+
+	// helper方法重试, 熔断与并发限制
+	RetryPolicy    *RetryPolicy    `json:"retryPolicy" yaml:"retryPolicy"`       // 指数退避重试, 默认不重试
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker" yaml:"circuitBreaker"` // 熔断, 默认不启用
+	Limiter        *Limiter        `json:"limiter" yaml:"limiter"`               // 并发限制, 默认不启用
+
+	// 分页
+	MaxPageSize     int64  `json:"maxPageSize" yaml:"maxPageSize"`         // FindPage/FindKeyset每页最大条数, 默认1000
+	PageTokenSecret string `json:"pageTokenSecret" yaml:"pageTokenSecret"` // FindKeyset的token签名密钥, 默认为进程内随机密钥
+
+	// 本地写日志
+	Journal *Journal `json:"journal" yaml:"journal"` // 集群不可达时写操作记入本地文件, 恢复后重放, 默认不启用
+
+	// 命令监控, 仅支持代码设置, 如mongodbtest.Recorder
+	Monitor *event.CommandMonitor `json:"-" yaml:"-"`
 }
 
 var (
-	clients map[string]*Client = make(map[string]*Client)
+	clientsMux sync.RWMutex
+	clients    map[string]*Client = make(map[string]*Client)
 )
 
+// 创建客户端, 不注册到全局, 由调用方负责Close
+func NewClient(opt *Config) (ret *Client, err error) {
+	if opt == nil {
+		opt = new(Config)
+	}
+	return newClient(opt)
+}
+
 func Setup(key string, cnf *Config) (err error) {
 
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
 	keys := conf.ToStringSlice(key)
 	for _, k := range keys {
 		if _, ok := clients[k]; ok {
@@ -105,20 +135,38 @@ func Setup(key string, cnf *Config) (err error) {
 	if err != nil {
 		return
 	}
+	// 每个别名各自的句柄, 错误信息等按实际主键区分, 共用连接
 	for _, k := range keys {
-		clients[k] = client
+		c := client.clone()
+		c.key = k
+		clients[k] = c
 	}
 	return
 }
 
 func Get(key string) *Client {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
 	return clients[key]
 }
 
 func Must(key string) *Client {
+	clientsMux.RLock()
 	ret, ok := clients[key]
+	clientsMux.RUnlock()
 	if !ok {
 		panic("invalid mongodb client: " + key)
 	}
 	return ret
 }
+
+// 注销共用该连接的全部主键, 在Close时调用
+func unregister(client *mongo.Client) {
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+	for k, c := range clients {
+		if c.Client == client {
+			delete(clients, k)
+		}
+	}
+}
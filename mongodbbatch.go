@@ -0,0 +1,283 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"time"
+)
+
+// 批处理任务状态
+const (
+	JobStatus_running = "running"
+	JobStatus_paused  = "paused"
+	JobStatus_stopped = "stopped" // 被Stop或ctx取消, 可从检查点继续
+	JobStatus_failed  = "failed"  // 处理函数或读写出错, 可从检查点继续
+	JobStatus_done    = "done"
+
+	defaultJobBatchSize   = 1000
+	defaultJobCheckpoints = "_checkpoints"
+)
+
+// 批处理任务, 按_id升序分批读取集合并调用Handle, 每批完成后记录检查点.
+// 重启后从检查点继续, 检查点写入前中断的批次会被重新处理(至少一次), Handle应保证幂等
+type BatchJob struct {
+	Name        string                      // 任务名, 作为检查点的_id, 必须唯一
+	Database    string                      // 源数据库, 默认为Client的默认数据库
+	Collection  string                      // 源集合
+	Filter      interface{}                 // 过滤条件
+	Projection  interface{}                 // 投影, 必须保留_id
+	BatchSize   int64                       // 每批条数, 默认1000
+	Checkpoints string                      // 检查点集合, 与源集合同库, 默认_checkpoints
+	Handle      func(docs []bson.Raw) error // 批处理函数
+	OnProgress  func(progress *JobProgress) // 每批完成后回调
+}
+
+// 检查点
+type Checkpoint struct {
+	Name       string      `bson:"_id"`
+	Collection string      `bson:"collection"`
+	LastId     interface{} `bson:"lastId"`    // 已处理的最后_id, 为空表示从头开始
+	Processed  int64       `bson:"processed"` // 已处理条数
+	Batches    int64       `bson:"batches"`   // 已处理批数
+	Total      int64       `bson:"total"`     // 首次启动时统计的总条数, 用于估算进度
+	Status     string      `bson:"status"`    // 见JobStatus_XXX常量
+	Error      string      `bson:"error,omitempty"`
+	StartedAt  time.Time   `bson:"startedAt"`
+	UpdatedAt  time.Time   `bson:"updatedAt"`
+}
+
+// 任务进度
+type JobProgress struct {
+	Checkpoint
+	Rate float64       // 本次运行的处理速度, 条/秒
+	ETA  time.Duration // 按Rate估算的剩余时间, 无法估算时为-1
+}
+
+// 批处理器, Run在当前goroutine执行, Pause/Resume/Stop可在其他goroutine调用
+type BatchProcessor struct {
+	cc  *Client
+	job BatchJob
+	db  string
+
+	mux      sync.Mutex
+	paused   bool
+	stopped  bool
+	signal   chan struct{}
+	cp       *Checkpoint
+	runStart time.Time
+	runBase  int64 // 本次运行开始时的已处理条数
+}
+
+func (cc *Client) BatchProcessor(job *BatchJob) *BatchProcessor {
+	p := &BatchProcessor{cc: cc, job: *job, db: job.Database, signal: make(chan struct{}, 1)}
+	if p.db == "" {
+		p.db = cc.DB
+	}
+	if p.job.Filter == nil {
+		p.job.Filter = ALL
+	}
+	if p.job.BatchSize <= 0 {
+		p.job.BatchSize = defaultJobBatchSize
+	}
+	if p.job.Checkpoints == "" {
+		p.job.Checkpoints = defaultJobCheckpoints
+	}
+	return p
+}
+
+// 执行任务直至完成, 出错, Stop或ctx取消. 已完成的任务直接返回, 需重跑时先调用Reset
+func (p *BatchProcessor) Run(ctx context.Context) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p.mux.Lock()
+	p.stopped = false
+	p.mux.Unlock()
+
+	cp, err := p.Checkpoint()
+	if err != nil {
+		return
+	}
+	if cp == nil {
+		cp = &Checkpoint{Name: p.job.Name, Collection: p.job.Collection, StartedAt: time.Now()}
+		if cp.Total, err = p.cc.DBCount(p.db, p.job.Collection, p.job.Filter); err != nil {
+			return
+		}
+	} else if cp.Status == JobStatus_done {
+		p.setCheckpoint(cp)
+		return nil
+	}
+	cp.Error = ""
+	p.mux.Lock()
+	p.cp, p.runStart, p.runBase = cp, time.Now(), cp.Processed
+	p.mux.Unlock()
+
+	for {
+		if err = p.waitIfPaused(ctx, cp); err != nil {
+			return
+		}
+		if p.isStopped() {
+			return p.save(cp, JobStatus_stopped, nil)
+		}
+		if err = ctx.Err(); err != nil {
+			p.save(cp, JobStatus_stopped, nil)
+			return
+		}
+		if cp.Status != JobStatus_running {
+			if err = p.save(cp, JobStatus_running, nil); err != nil {
+				return
+			}
+		}
+
+		filter := p.job.Filter
+		if cp.LastId != nil {
+			filter = bson.D{{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: cp.LastId}}}}}}}
+		}
+		opt := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(p.job.BatchSize)
+		if p.job.Projection != nil {
+			opt.SetProjection(p.job.Projection)
+		}
+		var docs []bson.Raw
+		if err = p.cc.DBFind(p.db, p.job.Collection, filter, &docs, opt); err != nil {
+			p.save(cp, JobStatus_failed, err)
+			return
+		}
+		if len(docs) == 0 {
+			return p.save(cp, JobStatus_done, nil)
+		}
+		if err = p.job.Handle(docs); err != nil {
+			p.save(cp, JobStatus_failed, err)
+			return
+		}
+
+		p.mux.Lock()
+		cp.LastId = docs[len(docs)-1].Lookup("_id")
+		cp.Processed += int64(len(docs))
+		cp.Batches++
+		p.mux.Unlock()
+		if int64(len(docs)) < p.job.BatchSize {
+			err = p.save(cp, JobStatus_done, nil)
+		} else {
+			err = p.save(cp, JobStatus_running, nil)
+		}
+		if p.job.OnProgress != nil {
+			p.job.OnProgress(p.Progress())
+		}
+		if err != nil || cp.Status == JobStatus_done {
+			return
+		}
+	}
+}
+
+// 暂停, 当前批次完成后等待Resume或Stop
+func (p *BatchProcessor) Pause() {
+	p.mux.Lock()
+	p.paused = true
+	p.mux.Unlock()
+	p.notify()
+}
+
+func (p *BatchProcessor) Resume() {
+	p.mux.Lock()
+	p.paused = false
+	p.mux.Unlock()
+	p.notify()
+}
+
+// 停止, 当前批次完成后Run返回nil, 检查点状态为stopped
+func (p *BatchProcessor) Stop() {
+	p.mux.Lock()
+	p.stopped = true
+	p.mux.Unlock()
+	p.notify()
+}
+
+func (p *BatchProcessor) notify() {
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (p *BatchProcessor) isStopped() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.stopped
+}
+
+func (p *BatchProcessor) waitIfPaused(ctx context.Context, cp *Checkpoint) error {
+	for {
+		p.mux.Lock()
+		paused, stopped := p.paused, p.stopped
+		p.mux.Unlock()
+		if !paused || stopped {
+			return nil
+		}
+		if cp.Status != JobStatus_paused {
+			if err := p.save(cp, JobStatus_paused, nil); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-p.signal:
+		case <-ctx.Done():
+			return nil // 由Run记录stopped
+		}
+	}
+}
+
+// 读取检查点, 不存在时返回nil
+func (p *BatchProcessor) Checkpoint() (*Checkpoint, error) {
+	cp := new(Checkpoint)
+	not, err := p.cc.DBFindId(p.db, p.job.Checkpoints, p.job.Name, cp)
+	if err != nil || not {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// 删除检查点, 下次Run从头开始
+func (p *BatchProcessor) Reset() error {
+	_, err := p.cc.DBDeleteId(p.db, p.job.Checkpoints, p.job.Name)
+	return err
+}
+
+func (p *BatchProcessor) setCheckpoint(cp *Checkpoint) {
+	p.mux.Lock()
+	p.cp, p.runStart, p.runBase = cp, time.Now(), cp.Processed
+	p.mux.Unlock()
+}
+
+func (p *BatchProcessor) save(cp *Checkpoint, status string, cause error) error {
+	p.mux.Lock()
+	cp.Status = status
+	if cause != nil {
+		cp.Error = cause.Error()
+	}
+	cp.UpdatedAt = time.Now()
+	snapshot := *cp
+	p.mux.Unlock()
+	_, err := p.cc.DBReplaceId(p.db, p.job.Checkpoints, p.job.Name, &snapshot, options.Replace().SetUpsert(true))
+	return err
+}
+
+// 当前进度, Run之前返回nil
+func (p *BatchProcessor) Progress() *JobProgress {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.cp == nil {
+		return nil
+	}
+	ret := &JobProgress{Checkpoint: *p.cp, ETA: -1}
+	if elapsed := time.Since(p.runStart).Seconds(); elapsed > 0 {
+		ret.Rate = float64(p.cp.Processed-p.runBase) / elapsed
+	}
+	if p.cp.Status == JobStatus_done {
+		ret.ETA = 0
+	} else if ret.Rate > 0 && p.cp.Total >= p.cp.Processed {
+		ret.ETA = time.Duration(float64(p.cp.Total-p.cp.Processed) / ret.Rate * float64(time.Second))
+	}
+	return ret
+}
@@ -0,0 +1,119 @@
+package mongodb_test
+
+import (
+	"context"
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestClient_BatchProcessor(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	docs := make([]interface{}, 0, 95)
+	for i := 0; i < 95; i++ {
+		docs = append(docs, bson.M{"_id": i, "odd": i%2 == 1})
+	}
+	if _, err := cc.InsertMany("item", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[int32]int)
+	fail := true
+	var p *mongodb.BatchProcessor
+	job := &mongodb.BatchJob{
+		Name:       "migrate",
+		Collection: "item",
+		BatchSize:  10,
+		Handle: func(docs []bson.Raw) error {
+			if id := docs[0].Lookup("_id").Int32(); id == 50 && fail {
+				fail = false
+				return errors.New("boom")
+			}
+			for _, doc := range docs {
+				seen[doc.Lookup("_id").Int32()]++
+			}
+			return nil
+		},
+		OnProgress: func(progress *mongodb.JobProgress) {
+			if progress.Batches == 3 {
+				p.Stop()
+			}
+		},
+	}
+
+	// 第3批后停止
+	p = cc.BatchProcessor(job)
+	if err := p.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if cp, err := p.Checkpoint(); err != nil || cp.Status != mongodb.JobStatus_stopped || cp.Processed != 30 || cp.Total != 95 {
+		t.Fatalf("unexpected: %+v %v", cp, err)
+	}
+
+	// 重启后继续, 第6批失败
+	p = cc.BatchProcessor(job)
+	if err := p.Run(nil); err == nil {
+		t.Fatal("expect handler error")
+	}
+	if cp, _ := p.Checkpoint(); cp.Status != mongodb.JobStatus_failed || cp.Processed != 50 || cp.Error == "" {
+		t.Fatalf("unexpected: %+v", cp)
+	}
+
+	// 暂停后恢复直至完成
+	paused := make(chan bool)
+	job.OnProgress = func(progress *mongodb.JobProgress) {
+		if progress.Batches == 7 {
+			p.Pause()
+			go func() {
+				for {
+					if cp, _ := p.Checkpoint(); cp.Status == mongodb.JobStatus_paused {
+						p.Resume()
+						paused <- true
+						return
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+		}
+	}
+	p = cc.BatchProcessor(job)
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-paused
+	progress := p.Progress()
+	if progress.Status != mongodb.JobStatus_done || progress.Processed != 95 || progress.Batches != 10 || progress.ETA != 0 {
+		t.Fatalf("unexpected: %+v", progress)
+	}
+	if len(seen) != 95 {
+		t.Fatalf("unexpected: %v", len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Fatalf("doc %v handled %v times", id, n)
+		}
+	}
+
+	// 已完成的任务不再执行, Reset后从头开始
+	if err := p.Run(nil); err != nil || len(seen) != 95 || seen[0] != 1 {
+		t.Fatalf("unexpected: %v", err)
+	}
+	job.Filter = bson.M{"odd": true}
+	job.OnProgress = nil
+	p = cc.BatchProcessor(job)
+	if err := p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(nil); err != nil || seen[1] != 2 || seen[0] != 1 || p.Progress().Total != 47 {
+		t.Fatalf("unexpected: %v %+v", err, p.Progress())
+	}
+}
@@ -0,0 +1,250 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/obase/conf"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerState_closed   = "closed"   // 正常放行
+	BreakerState_open     = "open"     // 熔断, 直接返回ErrCircuitOpen
+	BreakerState_halfOpen = "halfOpen" // 半开, 放行少量探测请求
+
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerFailureRate = 0.5
+	defaultBreakerOpenTimeout = 5 * time.Second
+	defaultBreakerProbes      = 1
+)
+
+// 熔断状态下helper方法直接返回该错误(包装为*Error)
+var ErrCircuitOpen = errors.New("mongodb circuit breaker is open")
+
+// 熔断配置. 统计窗口内请求数达到MinRequests且失败率达到FailureRate时熔断, OpenTimeout后进入半开状态探测
+type CircuitBreaker struct {
+	PerCollection  bool          `json:"PerCollection" yaml:"PerCollection"`   // 是否按集合独立熔断, 默认整个客户端共用
+	Window         time.Duration `json:"Window" yaml:"Window"`                 // 统计窗口, 默认10秒
+	MinRequests    int           `json:"MinRequests" yaml:"MinRequests"`       // 窗口内最少请求数, 默认20
+	FailureRate    float64       `json:"FailureRate" yaml:"FailureRate"`       // 失败率阈值(0,1], 默认0.5
+	OpenTimeout    time.Duration `json:"OpenTimeout" yaml:"OpenTimeout"`       // 熔断持续时间, 默认5秒
+	HalfOpenProbes int           `json:"HalfOpenProbes" yaml:"HalfOpenProbes"` // 半开状态探测请求数, 全部成功后恢复, 默认1
+
+	IsFailure     func(err error) bool                      `json:"-" yaml:"-"` // 失败判定, 默认网络/超时/选主类错误, 业务错误(如重复键)不计入
+	OnStateChange func(name string, from string, to string) `json:"-" yaml:"-"` // 状态变化回调, name为客户端主键或"主键/db.collection"
+}
+
+func (cc *Client) CircuitBreaker(cb *CircuitBreaker) *Client {
+	cc.circuitBreaker = cb
+	cc.breakers = new(sync.Map)
+	return cc
+}
+
+// 返回熔断状态, 未配置熔断时恒为closed
+func (cc *Client) BreakerState(cl string) string {
+	return cc.DBBreakerState(cc.DB, cl)
+}
+
+func (cc *Client) DBBreakerState(db string, cl string) string {
+	if cc.circuitBreaker == nil {
+		return BreakerState_closed
+	}
+	return cc.breaker(&Operation{Key: cc.key, Database: db, Collection: cl}).state(time.Now())
+}
+
+func (cc *Client) breaker(op *Operation) *breaker {
+	name := cc.key
+	if cc.circuitBreaker.PerCollection && op.Collection != "" {
+		name = cc.key + "/" + op.Database + "." + op.Collection
+	}
+	if b, ok := cc.breakers.Load(name); ok {
+		return b.(*breaker)
+	}
+	b, _ := cc.breakers.LoadOrStore(name, &breaker{name: name, cnf: cc.circuitBreaker})
+	return b.(*breaker)
+}
+
+// 经熔断器执行, 未配置时直接执行
+func (cc *Client) circuit(op *Operation, fn func() error) error {
+	if cc.circuitBreaker == nil {
+		return fn()
+	}
+	b := cc.breaker(op)
+	if !b.allow(time.Now()) {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.done(time.Now(), err)
+	return err
+}
+
+type breaker struct {
+	sync.Mutex
+	name     string
+	cnf      *CircuitBreaker
+	current  string
+	start    time.Time // 统计窗口开始时间
+	requests int
+	failures int
+	openAt   time.Time // 熔断时间
+	probes   int       // 半开状态已放行的探测数
+	passed   int       // 半开状态已成功的探测数
+}
+
+func (b *breaker) state(now time.Time) string {
+	b.Lock()
+	defer b.Unlock()
+	return b.refresh(now)
+}
+
+// 需持锁调用
+func (b *breaker) refresh(now time.Time) string {
+	if b.current == "" {
+		b.current, b.start = BreakerState_closed, now
+	}
+	switch b.current {
+	case BreakerState_closed:
+		window := b.cnf.Window
+		if window <= 0 {
+			window = defaultBreakerWindow
+		}
+		if now.Sub(b.start) >= window {
+			b.start, b.requests, b.failures = now, 0, 0
+		}
+	case BreakerState_open:
+		timeout := b.cnf.OpenTimeout
+		if timeout <= 0 {
+			timeout = defaultBreakerOpenTimeout
+		}
+		if now.Sub(b.openAt) >= timeout {
+			b.transit(BreakerState_halfOpen, now)
+		}
+	}
+	return b.current
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.refresh(now) {
+	case BreakerState_open:
+		return false
+	case BreakerState_halfOpen:
+		if b.probes >= b.maxProbes() {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+func (b *breaker) done(now time.Time, err error) {
+	failed := b.failure(err)
+	b.Lock()
+	defer b.Unlock()
+	switch b.refresh(now) {
+	case BreakerState_closed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		min, rate := b.cnf.MinRequests, b.cnf.FailureRate
+		if min <= 0 {
+			min = defaultBreakerMinRequests
+		}
+		if rate <= 0 {
+			rate = defaultBreakerFailureRate
+		}
+		if b.requests >= min && float64(b.failures) >= rate*float64(b.requests) {
+			b.transit(BreakerState_open, now)
+		}
+	case BreakerState_halfOpen:
+		if failed {
+			b.transit(BreakerState_open, now)
+		} else if b.passed++; b.passed >= b.maxProbes() {
+			b.transit(BreakerState_closed, now)
+		}
+	}
+}
+
+func (b *breaker) maxProbes() int {
+	if b.cnf.HalfOpenProbes > 0 {
+		return b.cnf.HalfOpenProbes
+	}
+	return defaultBreakerProbes
+}
+
+func (b *breaker) failure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if b.cnf.IsFailure != nil {
+		return b.cnf.IsFailure(err)
+	}
+	return IsNetwork(err) || IsTimeout(err) || IsServerSelection(err) || IsNotPrimary(err)
+}
+
+// 需持锁调用
+func (b *breaker) transit(to string, now time.Time) {
+	from := b.current
+	b.current = to
+	b.start, b.requests, b.failures = now, 0, 0
+	b.probes, b.passed = 0, 0
+	if to == BreakerState_open {
+		b.openAt = now
+	}
+	if b.cnf.OnStateChange != nil && from != to {
+		go b.cnf.OnStateChange(b.name, from, to)
+	}
+}
+
+func GetCircuitBreaker(val interface{}, ok bool) (*CircuitBreaker, bool) {
+	switch val := val.(type) {
+	case nil:
+		return nil, true
+	case string:
+		val = strings.TrimSpace(val)
+		if val == "" || !conf.ToBool(val) {
+			return nil, true
+		}
+		return new(CircuitBreaker), true
+	case bool:
+		if !val {
+			return nil, true
+		}
+		return new(CircuitBreaker), true
+	case map[string]interface{}:
+		return &CircuitBreaker{
+			PerCollection:  conf.ToBool(val["PerCollection"]),
+			Window:         conf.ToDuration(val["Window"]),
+			MinRequests:    conf.ToInt(val["MinRequests"]),
+			FailureRate:    conf.ToFloat64(val["FailureRate"]),
+			OpenTimeout:    conf.ToDuration(val["OpenTimeout"]),
+			HalfOpenProbes: conf.ToInt(val["HalfOpenProbes"]),
+		}, true
+	case map[interface{}]interface{}:
+		ret := new(CircuitBreaker)
+		for k, v := range val {
+			switch conf.ToString(k) {
+			case "PerCollection":
+				ret.PerCollection = conf.ToBool(v)
+			case "Window":
+				ret.Window = conf.ToDuration(v)
+			case "MinRequests":
+				ret.MinRequests = conf.ToInt(v)
+			case "FailureRate":
+				ret.FailureRate = conf.ToFloat64(v)
+			case "OpenTimeout":
+				ret.OpenTimeout = conf.ToDuration(v)
+			case "HalfOpenProbes":
+				ret.HalfOpenProbes = conf.ToInt(v)
+			}
+		}
+		return ret, true
+	default:
+		panic(fmt.Sprintf("invalid value for circuit breaker: %v", val))
+	}
+}
@@ -0,0 +1,52 @@
+package mongodb
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{cnf: &CircuitBreaker{MinRequests: 4, FailureRate: 0.5, OpenTimeout: time.Second, HalfOpenProbes: 1}}
+	netErr := mongo.CommandError{Labels: []string{ErrorLabel_NetworkError}}
+	now := time.Now()
+
+	for i := 0; i < 4; i++ {
+		if !b.allow(now) {
+			t.Fatal("closed breaker should allow")
+		}
+		if i%2 == 0 {
+			b.done(now, netErr)
+		} else {
+			b.done(now, nil)
+		}
+	}
+	if b.state(now) != BreakerState_open || b.allow(now) {
+		t.Fatal("breaker should be open")
+	}
+
+	now = now.Add(time.Second)
+	if !b.allow(now) || b.state(now) != BreakerState_halfOpen {
+		t.Fatal("breaker should be half open and allow one probe")
+	}
+	if b.allow(now) {
+		t.Fatal("half open breaker should allow only one probe")
+	}
+	b.done(now, nil)
+	if b.state(now) != BreakerState_closed {
+		t.Fatal("breaker should be closed after probe succeeds")
+	}
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	cc := new(Client).CircuitBreaker(&CircuitBreaker{PerCollection: true, MinRequests: 1, FailureRate: 1})
+	op := &Operation{Database: "db", Collection: "a", Name: Op_FindId}
+	cc.circuit(op, func() error { return mongo.CommandError{Labels: []string{ErrorLabel_NetworkError}} })
+	if err := cc.circuit(op, func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expect ErrCircuitOpen, got %v", err)
+	}
+	if cc.DBBreakerState("db", "b") != BreakerState_closed {
+		t.Fatal("other collection should not be affected")
+	}
+}
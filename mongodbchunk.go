@@ -0,0 +1,283 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultChunkMaxCount = 1000
+	defaultChunkMaxBytes = 8 * 1024 * 1024 // 远小于48M的消息上限, 为命令本身留余量
+)
+
+// 分块写入选项
+type ChunkOptions struct {
+	MaxCount    int  // 每块最大条数, 默认1000
+	MaxBytes    int  // 每块估算的最大BSON字节数, 默认8M. 单条超出时独占一块
+	Concurrency int  // 并发执行的块数, 默认1即顺序执行
+	Ordered     bool // 有序: 块内按序执行且出错即停, 出错后不再启动后续块(并发时已启动的块会执行完)
+}
+
+// 分块写入失败的块, 错误非写错误(如网络, 熔断), 块内写入情况未知
+type FailedChunk struct {
+	Offset int // 块在原始输入中的起始下标
+	Count  int
+	Err    error
+}
+
+// 分块写入的汇总错误, 写错误合并后下标为原始输入中的下标, 可经errors.As取得mongo.BulkWriteException
+type ChunkError struct {
+	WriteErrors       []mongo.BulkWriteError
+	WriteConcernError *mongo.WriteConcernError
+	Failed            []*FailedChunk
+	Skipped           int // Ordered时因前序出错未执行的条数
+}
+
+func (e *ChunkError) Error() string {
+	var msgs []string
+	if len(e.WriteErrors) > 0 || e.WriteConcernError != nil {
+		msgs = append(msgs, e.exception().Error())
+	}
+	for _, fc := range e.Failed {
+		msgs = append(msgs, fmt.Sprintf("chunk [%v, %v): %v", fc.Offset, fc.Offset+fc.Count, fc.Err))
+	}
+	if e.Skipped > 0 {
+		msgs = append(msgs, fmt.Sprintf("%v skipped", e.Skipped))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ChunkError) Unwrap() error {
+	if len(e.WriteErrors) > 0 || e.WriteConcernError != nil {
+		return e.exception()
+	}
+	if len(e.Failed) > 0 {
+		return e.Failed[0].Err
+	}
+	return nil
+}
+
+func (e *ChunkError) exception() mongo.BulkWriteException {
+	return mongo.BulkWriteException{WriteErrors: e.WriteErrors, WriteConcernError: e.WriteConcernError}
+}
+
+// 合并单块的错误, offset为块的起始下标
+func (e *ChunkError) add(offset int, count int, err error) {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) {
+		e.Failed = append(e.Failed, &FailedChunk{Offset: offset, Count: count, Err: err})
+		return
+	}
+	for _, we := range bwe.WriteErrors {
+		we.Index += offset
+		e.WriteErrors = append(e.WriteErrors, we)
+	}
+	if bwe.WriteConcernError != nil && e.WriteConcernError == nil {
+		e.WriteConcernError = bwe.WriteConcernError
+	}
+}
+
+func (e *ChunkError) empty() bool {
+	return len(e.WriteErrors) == 0 && e.WriteConcernError == nil && len(e.Failed) == 0 && e.Skipped == 0
+}
+
+type chunk struct {
+	offset int
+	count  int
+}
+
+// 按条数及字节数切分
+func splitChunks(sizes []int, maxCount int, maxBytes int) []chunk {
+	var ret []chunk
+	cur := chunk{}
+	bytes := 0
+	for i, size := range sizes {
+		if cur.count > 0 && (cur.count >= maxCount || bytes+size > maxBytes) {
+			ret = append(ret, cur)
+			cur, bytes = chunk{offset: i}, 0
+		}
+		cur.count++
+		bytes += size
+	}
+	if cur.count > 0 {
+		ret = append(ret, cur)
+	}
+	return ret
+}
+
+func (co *ChunkOptions) normalize() ChunkOptions {
+	ret := ChunkOptions{}
+	if co != nil {
+		ret = *co
+	}
+	if ret.MaxCount <= 0 {
+		ret.MaxCount = defaultChunkMaxCount
+	}
+	if ret.MaxBytes <= 0 {
+		ret.MaxBytes = defaultChunkMaxBytes
+	}
+	if ret.Concurrency <= 0 {
+		ret.Concurrency = 1
+	}
+	return ret
+}
+
+// 以有限并发执行各块, 返回汇总错误. fn的错误由调用方合并结果后返回
+func (co *ChunkOptions) run(chunks []chunk, fn func(c chunk) error) *ChunkError {
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	ce := new(ChunkError)
+	failed := false
+	sem := make(chan struct{}, co.Concurrency)
+	for i, c := range chunks {
+		sem <- struct{}{}
+		mux.Lock()
+		stop := failed && co.Ordered
+		mux.Unlock()
+		if stop {
+			<-sem
+			for _, rest := range chunks[i:] {
+				ce.Skipped += rest.count
+			}
+			break
+		}
+		wg.Add(1)
+		go func(c chunk) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(c); err != nil {
+				mux.Lock()
+				ce.add(c.offset, c.count, err)
+				failed = true
+				mux.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	// 多块并发时按原始下标排序写错误
+	sortWriteErrors(ce.WriteErrors)
+	return ce
+}
+
+func sortWriteErrors(errs []mongo.BulkWriteError) {
+	for i := 1; i < len(errs); i++ {
+		for j := i; j > 0 && errs[j].Index < errs[j-1].Index; j-- {
+			errs[j], errs[j-1] = errs[j-1], errs[j]
+		}
+	}
+}
+
+func (cc *Client) InsertManyChunked(cl string, docs []interface{}, co *ChunkOptions) (*mongo.InsertManyResult, error) {
+	return cc.DBInsertManyChunked(cc.DB, cl, docs, co)
+}
+
+// 分块InsertMany, 适用任意大小的输入. 结果中InsertedIDs与docs一一对应(未执行的块为nil), 输入为空时返回空结果
+func (cc *Client) DBInsertManyChunked(db string, cl string, docs []interface{}, co *ChunkOptions) (result *mongo.InsertManyResult, err error) {
+	opt := co.normalize()
+	op := &Operation{Database: db, Collection: cl, Name: Op_InsertMany}
+	// 预先编码以计算大小, 编码结果直接用于写入避免重复编码
+	raws := make([]interface{}, len(docs))
+	sizes := make([]int, len(docs))
+	for _, doc := range docs {
+		if err := beforeInsert(doc); err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+	}
+	if v := cc.validationOf(db, cl); v != nil {
+		if err := v.validateDocs(true, docs...); err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+	}
+	for i, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+		raws[i], sizes[i] = bson.Raw(raw), len(raw)
+	}
+	result = &mongo.InsertManyResult{InsertedIDs: make([]interface{}, len(docs))}
+	ce := opt.run(splitChunks(sizes, opt.MaxCount, opt.MaxBytes), func(c chunk) error {
+		ret, err := cc.DBInsertMany(db, cl, raws[c.offset:c.offset+c.count], options.InsertMany().SetOrdered(opt.Ordered))
+		if ret != nil {
+			copy(result.InsertedIDs[c.offset:], ret.InsertedIDs)
+		}
+		return err
+	})
+	if !ce.empty() {
+		return result, cc.wrapError(op, ce)
+	}
+	for _, doc := range docs {
+		if err = afterInsert(doc); err != nil {
+			return result, cc.wrapError(op, err)
+		}
+	}
+	return result, nil
+}
+
+func (cc *Client) BulkWriteChunked(cl string, models []mongo.WriteModel, co *ChunkOptions) (*mongo.BulkWriteResult, error) {
+	return cc.DBBulkWriteChunked(cc.DB, cl, models, co)
+}
+
+// 分块BulkWrite, 适用任意大小的输入. 各块结果累加, UpsertedIDs的键为原始下标
+func (cc *Client) DBBulkWriteChunked(db string, cl string, models []mongo.WriteModel, co *ChunkOptions) (result *mongo.BulkWriteResult, err error) {
+	opt := co.normalize()
+	op := &Operation{Database: db, Collection: cl, Name: Op_BulkWrite}
+	sizes := make([]int, len(models))
+	for i, model := range models {
+		if sizes[i], err = modelSize(model); err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+	}
+	var mux sync.Mutex
+	result = &mongo.BulkWriteResult{UpsertedIDs: make(map[int64]interface{})}
+	ce := opt.run(splitChunks(sizes, opt.MaxCount, opt.MaxBytes), func(c chunk) error {
+		ret, err := cc.DBBulkWrite(db, cl, models[c.offset:c.offset+c.count], options.BulkWrite().SetOrdered(opt.Ordered))
+		if ret != nil {
+			mux.Lock()
+			result.InsertedCount += ret.InsertedCount
+			result.MatchedCount += ret.MatchedCount
+			result.ModifiedCount += ret.ModifiedCount
+			result.DeletedCount += ret.DeletedCount
+			result.UpsertedCount += ret.UpsertedCount
+			for idx, id := range ret.UpsertedIDs {
+				result.UpsertedIDs[idx+int64(c.offset)] = id
+			}
+			mux.Unlock()
+		}
+		return err
+	})
+	if !ce.empty() {
+		return result, cc.wrapError(op, ce)
+	}
+	return result, nil
+}
+
+// 估算WriteModel编码后的大小
+func modelSize(model mongo.WriteModel) (int, error) {
+	var parts bson.A
+	switch m := model.(type) {
+	case *mongo.InsertOneModel:
+		parts = bson.A{m.Document}
+	case *mongo.UpdateOneModel:
+		parts = bson.A{m.Filter, m.Update, m.ArrayFilters}
+	case *mongo.UpdateManyModel:
+		parts = bson.A{m.Filter, m.Update, m.ArrayFilters}
+	case *mongo.ReplaceOneModel:
+		parts = bson.A{m.Filter, m.Replacement}
+	case *mongo.DeleteOneModel:
+		parts = bson.A{m.Filter}
+	case *mongo.DeleteManyModel:
+		parts = bson.A{m.Filter}
+	default:
+		return 0, fmt.Errorf("unsupported write model %T", model)
+	}
+	raw, err := bson.Marshal(bson.D{{Key: "parts", Value: parts}})
+	return len(raw), err
+}
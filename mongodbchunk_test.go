@@ -0,0 +1,103 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"testing"
+)
+
+func TestClient_InsertManyChunked(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	ret, err := cc.InsertManyChunked("empty", nil, nil)
+	if err != nil || ret == nil || len(ret.InsertedIDs) != 0 {
+		t.Fatalf("empty insert: %v %v", ret, err)
+	}
+	bret, err := cc.BulkWrite("empty", nil)
+	if err != nil || bret == nil {
+		t.Fatalf("empty bulk write: %v %v", bret, err)
+	}
+
+	docs := make([]interface{}, 0, 250)
+	for i := 0; i < 250; i++ {
+		docs = append(docs, bson.M{"_id": i, "pad": strings.Repeat("x", 100)})
+	}
+	// 按字节数切分: 每块约9条
+	ret, err = cc.InsertManyChunked("user", docs, &mongodb.ChunkOptions{MaxCount: 50, MaxBytes: 1024, Concurrency: 4})
+	if err != nil || len(ret.InsertedIDs) != 250 || ret.InsertedIDs[249] != int32(249) {
+		t.Fatalf("insert: %v", err)
+	}
+	if n, err := cc.Count("user", nil); err != nil || n != 250 {
+		t.Fatalf("count: %v %v", n, err)
+	}
+
+	// 重复键: 无序时其余块继续, 写错误下标为原始下标
+	dups := []interface{}{bson.M{"_id": 1000}, bson.M{"_id": 10}, bson.M{"_id": 1001}, bson.M{"_id": 1002}, bson.M{"_id": 20}, bson.M{"_id": 1003}}
+	_, err = cc.InsertManyChunked("user", dups, &mongodb.ChunkOptions{MaxCount: 2, Concurrency: 2})
+	var ce *mongodb.ChunkError
+	if !errors.As(err, &ce) || len(ce.WriteErrors) != 2 || ce.WriteErrors[0].Index != 1 || ce.WriteErrors[1].Index != 4 || ce.Skipped != 0 {
+		t.Fatalf("unordered: %v", err)
+	}
+	if !mongodb.IsDuplicateKey(err) {
+		t.Fatalf("expect duplicate key: %v", err)
+	}
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || len(bwe.WriteErrors) != 2 {
+		t.Fatalf("expect bulk write exception: %v", err)
+	}
+	if n, _ := cc.Count("user", nil); n != 254 {
+		t.Fatalf("unordered count: %v", n)
+	}
+
+	// 有序时出错后不再执行后续块
+	dups = []interface{}{bson.M{"_id": 2000}, bson.M{"_id": 30}, bson.M{"_id": 2001}, bson.M{"_id": 2002}}
+	_, err = cc.InsertManyChunked("user", dups, &mongodb.ChunkOptions{MaxCount: 2, Ordered: true})
+	if !errors.As(err, &ce) || len(ce.WriteErrors) != 1 || ce.WriteErrors[0].Index != 1 || ce.Skipped != 2 {
+		t.Fatalf("ordered: %v", err)
+	}
+	if n, _ := cc.Count("user", nil); n != 255 {
+		t.Fatalf("ordered count: %v", n)
+	}
+}
+
+func TestClient_BulkWriteChunked(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	models := make([]mongo.WriteModel, 0, 100)
+	for i := 0; i < 100; i++ {
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": i}).SetUpdate(bson.M{"$set": bson.M{"n": i}}).SetUpsert(true))
+	}
+	ret, err := cc.BulkWriteChunked("user", models, &mongodb.ChunkOptions{MaxCount: 30, Concurrency: 3})
+	if err != nil || ret.UpsertedCount != 100 || len(ret.UpsertedIDs) != 100 || ret.UpsertedIDs[99] != int32(99) {
+		t.Fatalf("upsert: %v %v", ret, err)
+	}
+
+	models = []mongo.WriteModel{
+		mongo.NewUpdateManyModel().SetFilter(bson.M{"n": bson.M{"$lt": 10}}).SetUpdate(bson.M{"$set": bson.M{"small": true}}),
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": 5}),
+		mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": 99}),
+		mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": 98}).SetReplacement(bson.M{"n": -1}),
+	}
+	ret, err = cc.BulkWriteChunked("user", models, &mongodb.ChunkOptions{MaxCount: 1})
+	var ce *mongodb.ChunkError
+	if !errors.As(err, &ce) || len(ce.WriteErrors) != 1 || ce.WriteErrors[0].Index != 1 {
+		t.Fatalf("bulk: %v", err)
+	}
+	if ret.ModifiedCount != 11 || ret.DeletedCount != 1 {
+		t.Fatalf("bulk result: %+v", ret)
+	}
+}
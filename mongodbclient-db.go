@@ -1,19 +1,25 @@
 package mongodb
 
 import (
+	"context"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (cc *Client) DBListCollectionNames(db string, filters ...interface{}) ([]string, error) {
+func (cc *Client) DBListCollectionNames(db string, filters ...interface{}) (ret []string, err error) {
 	var filter interface{}
 	if len(filters) > 0 {
 		filter = filters[0]
 	} else {
 		filter = ALL
 	}
-	return cc.Client.Database(db).ListCollectionNames(nil, filter)
+	op := &Operation{Database: db, Name: Op_ListCollectionNames, Filter: filter}
+	err = cc.execDatabase(op, func() (err error) {
+		ret, err = cc.Client.Database(db).ListCollectionNames(nil, op.Filter)
+		return
+	})
+	return
 }
 
 func (cc *Client) DBCollection(db string, cl string, opts ...*options.CollectionOptions) *mongo.Collection {
@@ -24,46 +30,77 @@ func (cc *Client) DBCollection(db string, cl string, opts ...*options.Collection
 }
 
 func (cc *Client) DBCount(db string, cl string, filters ...interface{}) (ret int64, err error) {
-	if len(filters) == 0 {
-		return cc.Database(db).Collection(cl, cc.collectionOptions).EstimatedDocumentCount(nil)
-	} else {
-		return cc.Database(db).Collection(cl, cc.collectionOptions).CountDocuments(nil, filters[0])
+	op := &Operation{Database: db, Collection: cl, Name: Op_Count}
+	if len(filters) > 0 {
+		op.Filter = filters[0]
 	}
-
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		if op.Filter == nil {
+			ret, err = coll.EstimatedDocumentCount(nil)
+		} else {
+			ret, err = coll.CountDocuments(nil, op.Filter)
+		}
+		return
+	})
+	return
 }
 
 func (cc *Client) DBFindId(db string, cl string, id interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
-	err = cc.Database(db).Collection(cl, cc.collectionOptions).FindOne(nil, bson.M{"_id": id}, opts...).Decode(ret)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindId, Filter: bson.M{"_id": id}, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOneOptions)
+		not, err = notFound(cc.hedged(op, coll, ret, func(ctx context.Context, coll *mongo.Collection, ret interface{}) error {
+			return decode(coll.FindOne(ctx, op.Filter, opts...), ret)
+		}))
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFindOne(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
-	err = cc.Database(db).Collection(cl, cc.collectionOptions).FindOne(nil, filter, opts...).Decode(ret)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindOne, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOneOptions)
+		not, err = notFound(cc.hedged(op, coll, ret, func(ctx context.Context, coll *mongo.Collection, ret interface{}) error {
+			return decode(coll.FindOne(ctx, op.Filter, opts...), ret)
+		}))
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFind(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOptions) (err error) {
-	cur, err := cc.Database(db).Collection(cl, cc.collectionOptions).Find(nil, filter, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_Find, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) error {
+		opts, _ := op.Options.([]*options.FindOptions)
+		return cc.hedged(op, coll, ret, func(ctx context.Context, coll *mongo.Collection, ret interface{}) error {
+			cur, err := coll.Find(ctx, op.Filter, opts...)
+			if err == nil {
+				err = cur.All(ctx, ret)
+			}
+			return err
+		})
+	})
 	if err == nil {
-		err = cur.All(nil, ret)
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFindWith(db string, cl string, filter interface{}, with func(cur *mongo.Cursor) error, opts ...*options.FindOptions) (err error) {
-	cur, err := cc.Database(db).Collection(cl, cc.collectionOptions).Find(nil, filter, opts...)
+	var cur *mongo.Cursor
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindWith, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOptions)
+		cur, err = coll.Find(nil, op.Filter, opts...)
+		return
+	})
 	if err == nil {
 		defer cur.Close(nil)
 		err = with(cur)
@@ -72,160 +109,239 @@ func (cc *Client) DBFindWith(db string, cl string, filter interface{}, with func
 }
 
 func (cc *Client) DBDistinct(db string, cl string, fieldName string, filter interface{}, opts ...*options.DistinctOptions) (ret []interface{}, err error) {
-	ret, err = cc.Database(db).Collection(cl, cc.collectionOptions).Distinct(nil, fieldName, filter, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_Distinct, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.DistinctOptions)
+		ret, err = coll.Distinct(nil, fieldName, op.Filter, opts...)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBFindIdAndUpdate(db string, cl string, id interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
-	result := cc.Database(db).Collection(cl, cc.collectionOptions).FindOneAndUpdate(nil, bson.M{"_id": id}, update, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindIdAndUpdate, Filter: bson.M{"_id": id}, Update: update, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOneAndUpdateOptions)
+		not, err = decodeSingleResult(coll.FindOneAndUpdate(nil, op.Filter, op.Update, opts...), ret)
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFindIdAndReplace(db string, cl string, id interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
-	result := cc.Database(db).Collection(cl, cc.collectionOptions).FindOneAndReplace(nil, bson.M{"_id": id}, replace, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindIdAndReplace, Filter: bson.M{"_id": id}, Update: replace, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOneAndReplaceOptions)
+		not, err = decodeSingleResult(coll.FindOneAndReplace(nil, op.Filter, op.Update, opts...), ret)
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFindIdAndDelete(db string, cl string, id interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
-	result := cc.Database(db).Collection(cl, cc.collectionOptions).FindOneAndDelete(nil, bson.M{"_id": id}, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindIdAndDelete, Filter: bson.M{"_id": id}, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		not, err = decodeSingleResult(findAndDelete(coll, op), ret)
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFindOneAndUpdate(db string, cl string, filter interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
-	result := cc.Database(db).Collection(cl, cc.collectionOptions).FindOneAndUpdate(nil, filter, update, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindOneAndUpdate, Filter: filter, Update: update, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOneAndUpdateOptions)
+		not, err = decodeSingleResult(coll.FindOneAndUpdate(nil, op.Filter, op.Update, opts...), ret)
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFindOneAndReplace(db string, cl string, filter interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
-	result := cc.Database(db).Collection(cl, cc.collectionOptions).FindOneAndReplace(nil, filter, replace, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindOneAndReplace, Filter: filter, Update: replace, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOneAndReplaceOptions)
+		not, err = decodeSingleResult(coll.FindOneAndReplace(nil, op.Filter, op.Update, opts...), ret)
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBFindOneAndDelete(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
-	result := cc.Database(db).Collection(cl, cc.collectionOptions).FindOneAndDelete(nil, filter, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindOneAndDelete, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		not, err = decodeSingleResult(findAndDelete(coll, op), ret)
+		return
+	})
+	if err == nil && !not && ret != nil {
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBInsertOne(db string, cl string, doc interface{}, opts ...*options.InsertOneOptions) (result *mongo.InsertOneResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).InsertOne(nil, doc, opts...)
-	if err != nil {
+	op := &Operation{Database: db, Collection: cl, Name: Op_InsertOne, Document: doc, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.InsertOneOptions)
+		result, err = coll.InsertOne(nil, op.Document, opts...)
 		return
-	}
+	})
 	return
 }
 
 func (cc *Client) DBInsertMany(db string, cl string, docs []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).InsertMany(nil, docs, opts...)
-	if err != nil {
+	op := &Operation{Database: db, Collection: cl, Name: Op_InsertMany, Documents: docs, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.InsertManyOptions)
+		result, err = coll.InsertMany(nil, op.Documents, opts...)
 		return
-	}
+	})
 	return
 }
 
 func (cc *Client) DBReplaceId(db string, cl string, id interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).ReplaceOne(nil, bson.M{"_id": id}, replace, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_ReplaceId, Filter: bson.M{"_id": id}, Update: replace, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.ReplaceOptions)
+		result, err = coll.ReplaceOne(nil, op.Filter, op.Update, opts...)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBReplaceOne(db string, cl string, filter interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).ReplaceOne(nil, filter, replace, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_ReplaceOne, Filter: filter, Update: replace, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.ReplaceOptions)
+		result, err = coll.ReplaceOne(nil, op.Filter, op.Update, opts...)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBUpdateId(db string, cl string, id interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).UpdateOne(nil, bson.M{"_id": id}, update, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_UpdateId, Filter: bson.M{"_id": id}, Update: update, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.UpdateOptions)
+		result, err = coll.UpdateOne(nil, op.Filter, op.Update, opts...)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBUpdateOne(db string, cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).UpdateOne(nil, filter, update, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_UpdateOne, Filter: filter, Update: update, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.UpdateOptions)
+		result, err = coll.UpdateOne(nil, op.Filter, op.Update, opts...)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBUpdateMany(db string, cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).UpdateMany(nil, filter, update, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_UpdateMany, Filter: filter, Update: update, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.UpdateOptions)
+		result, err = coll.UpdateMany(nil, op.Filter, op.Update, opts...)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBDeleteId(db string, cl string, id interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).DeleteOne(nil, bson.M{"_id": id}, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_DeleteId, Filter: bson.M{"_id": id}, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		result, err = deleteDocs(coll, op, false)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBDeleteOne(db string, cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).DeleteOne(nil, filter, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_DeleteOne, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		result, err = deleteDocs(coll, op, false)
+		return
+	})
 	return
 }
 
 // 必须注意: empty filter会删除整个集合数据
 func (cc *Client) DBDeleteMany(db string, cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).DeleteMany(nil, filter, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_DeleteMany, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		result, err = deleteDocs(coll, op, true)
+		return
+	})
 	return
 }
 
 func (cc *Client) DBAggregate(db string, cl string, pipeline interface{}, ret interface{}, opts ...*options.AggregateOptions) (err error) {
-	cur, err := cc.Database(db).Collection(cl, cc.collectionOptions).Aggregate(nil, pipeline, opts...)
+	op := &Operation{Database: db, Collection: cl, Name: Op_Aggregate, Pipeline: pipeline, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.AggregateOptions)
+		cur, err := coll.Aggregate(nil, op.Pipeline, opts...)
+		if err == nil {
+			err = cur.All(nil, ret)
+		}
+		return
+	})
 	if err == nil {
-		err = cur.All(nil, ret)
+		err = cc.afterFind(op, ret)
 	}
 	return
 }
 
 func (cc *Client) DBAggregateWith(db string, cl string, pipeline interface{}, with func(cur *mongo.Cursor), opts ...*options.AggregateOptions) (err error) {
-	cur, err := cc.Database(db).Collection(cl, cc.collectionOptions).Aggregate(nil, pipeline, opts...)
+	return cc.DBAggregateWithErr(db, cl, pipeline, func(cur *mongo.Cursor) error {
+		with(cur)
+		return nil
+	}, opts...)
+}
+
+// 同DBAggregateWith, 回调返回的错误作为结果返回
+func (cc *Client) DBAggregateWithErr(db string, cl string, pipeline interface{}, with func(cur *mongo.Cursor) error, opts ...*options.AggregateOptions) (err error) {
+	var cur *mongo.Cursor
+	op := &Operation{Database: db, Collection: cl, Name: Op_AggregateWith, Pipeline: pipeline, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.AggregateOptions)
+		cur, err = coll.Aggregate(nil, op.Pipeline, opts...)
+		return
+	})
 	if err == nil {
 		defer cur.Close(nil)
-		with(cur)
+		err = with(cur)
 	}
 	return
 }
 
+// models为空时返回空结果, 驱动会返回ErrEmptySlice. 空models同样经过中间件
 func (cc *Client) DBBulkWrite(db string, cl string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error) {
-	if len(models) == 0 {
+	op := &Operation{Database: db, Collection: cl, Name: Op_BulkWrite, Models: models, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		if len(op.Models) == 0 {
+			result = &mongo.BulkWriteResult{UpsertedIDs: make(map[int64]interface{})}
+			return
+		}
+		opts, _ := op.Options.([]*options.BulkWriteOptions)
+		result, err = coll.BulkWrite(nil, op.Models, opts...)
 		return
-	}
-	result, err = cc.Database(db).Collection(cl, cc.collectionOptions).BulkWrite(nil, models, opts...)
+	})
 	return
 }
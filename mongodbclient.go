@@ -11,6 +11,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"go.mongodb.org/mongo-driver/tag"
 	"net"
+	"sync"
 )
 
 var ALL = bson.M{}
@@ -27,7 +28,26 @@ func ObjectId(s string) *primitive.ObjectID {
 type Client struct {
 	*mongo.Client
 	DB                string
+	key               string // Setup时的主键, 用于错误信息
 	collectionOptions *options.CollectionOptions
+	retryPolicy       *RetryPolicy
+	circuitBreaker    *CircuitBreaker
+	breakers          *sync.Map // 熔断器, 按客户端或集合区分
+	limiter           *Limiter
+	limits            *sync.Map // 并发信号量, 按客户端或集合区分
+	priority          int       // 当前句柄的优先级, 见WithPriority
+	readFallback      *readFallback
+	hedge             *Hedge
+	maxPageSize       int64  // FindPage/FindKeyset每页最大条数
+	pageTokenSecret   []byte // FindKeyset的token签名密钥
+	journal           *journal
+	timestamps        *sync.Map // 自动时间戳策略, 按db.cl区分
+	softDeletes       *sync.Map // 软删除策略, 按db.cl区分
+	withDeleted       bool      // 读操作包含已软删除的文档, 见WithDeleted
+	versionings       *sync.Map // 乐观锁策略, 按db.cl区分
+	models            *sync.Map // 注册的文档类型, 按db.cl区分, 用于BeforeDelete
+	middlewares       []Middleware
+	validations       *sync.Map // 写前校验策略, 按db.cl区分
 	ALL               bson.M
 	ObjectId          func(s string) *primitive.ObjectID
 }
@@ -49,35 +69,8 @@ func newClient(opt *Config) (ret *Client, err error) {
 		opts.SetAuth(auth)
 	}
 
-	if opt.ReadPreference != nil {
-
-		var rpopts []readpref.Option
-		if size := len(opt.ReadPreference.RTagSet); size > 0 {
-			var set tag.Set
-			for k, v := range opt.ReadPreference.RTagSet {
-				set = append(set, tag.Tag{
-					Name:  k,
-					Value: v,
-				})
-			}
-			rpopts = append(rpopts, readpref.WithTagSets(set))
-		}
-		if opt.ReadPreference.RMaxStateness > 0 {
-			rpopts = append(rpopts, readpref.WithMaxStaleness(opt.ReadPreference.RMaxStateness))
-		}
-
-		switch opt.ReadPreference.RMode {
-		case ReadPreference_primary:
-			opts.SetReadPreference(readpref.Primary())
-		case ReadPreference_primaryPreferred:
-			opts.SetReadPreference(readpref.PrimaryPreferred(rpopts...))
-		case ReadPreference_secondary:
-			opts.SetReadPreference(readpref.Secondary(rpopts...))
-		case ReadPreference_secondaryPreferred:
-			opts.SetReadPreference(readpref.SecondaryPreferred(rpopts...))
-		case ReadPreference_nearest:
-			opts.SetReadPreference(readpref.Nearest(rpopts...))
-		}
+	if rp := toReadPref(opt.ReadPreference); rp != nil {
+		opts.SetReadPreference(rp)
 	}
 
 	if opt.ReadConcern != nil {
@@ -168,6 +161,10 @@ func newClient(opt *Config) (ret *Client, err error) {
 		opts.SetZstdLevel(opt.ZstdLevel)
 	}
 
+	if opt.Monitor != nil {
+		opts.SetMonitor(opt.Monitor)
+	}
+
 	opts.SetRetryReads(opt.RetryReads)
 	opts.SetRetryWrites(opt.RetryWrites)
 
@@ -176,256 +173,221 @@ func newClient(opt *Config) (ret *Client, err error) {
 		return
 	}
 	ret = &Client{
-		Client:   client,
-		DB:       opt.Database,
-		ALL:      ALL, // 快捷引用
-		ObjectId: ObjectId,
+		Client:      client,
+		DB:          opt.Database,
+		ALL:         ALL, // 快捷引用
+		ObjectId:    ObjectId,
+		retryPolicy: opt.RetryPolicy,
+		// 按集合的策略在创建时初始化, 以便WithXXX等句柄与原客户端共用
+		timestamps:  new(sync.Map),
+		softDeletes: new(sync.Map),
+		versionings: new(sync.Map),
+		models:      new(sync.Map),
+		validations: new(sync.Map),
+	}
+	if opt.CircuitBreaker != nil {
+		ret.CircuitBreaker(opt.CircuitBreaker)
+	}
+	if opt.Limiter != nil {
+		ret.Limiter(opt.Limiter)
+	}
+	if opt.MaxPageSize > 0 {
+		ret.MaxPageSize(opt.MaxPageSize)
+	}
+	if opt.PageTokenSecret != "" {
+		ret.PageTokenSecret(opt.PageTokenSecret)
+	}
+	if opt.Journal != nil {
+		if err = ret.OpenJournal(opt.Journal); err != nil {
+			client.Disconnect(nil)
+			return nil, err
+		}
 	}
 	return
 }
 
+func toReadPref(opt *ReadPreference) *readpref.ReadPref {
+	if opt == nil {
+		return nil
+	}
+
+	var rpopts []readpref.Option
+	if size := len(opt.RTagSet); size > 0 {
+		var set tag.Set
+		for k, v := range opt.RTagSet {
+			set = append(set, tag.Tag{
+				Name:  k,
+				Value: v,
+			})
+		}
+		rpopts = append(rpopts, readpref.WithTagSets(set))
+	}
+	if opt.RMaxStateness > 0 {
+		rpopts = append(rpopts, readpref.WithMaxStaleness(opt.RMaxStateness))
+	}
+
+	switch opt.RMode {
+	case ReadPreference_primary:
+		return readpref.Primary()
+	case ReadPreference_primaryPreferred:
+		return readpref.PrimaryPreferred(rpopts...)
+	case ReadPreference_secondary:
+		return readpref.Secondary(rpopts...)
+	case ReadPreference_secondaryPreferred:
+		return readpref.SecondaryPreferred(rpopts...)
+	case ReadPreference_nearest:
+		return readpref.Nearest(rpopts...)
+	}
+	return nil
+}
+
+// 浅拷贝, 用于派生不同调用属性的句柄
+func (cc *Client) clone() *Client {
+	ret := *cc
+	return &ret
+}
+
 func (cc *Client) CollectionOptions(opts *options.CollectionOptions) *Client {
 	cc.collectionOptions = opts
 	return cc
 }
 
+// 关闭连接, 由Setup注册的客户端同时注销其全部主键, 之后可以相同主键重新Setup
 func (cc *Client) Close() (err error) {
+	if cc.journal != nil {
+		cc.journal.close()
+	}
 	if cc.Client != nil {
+		unregister(cc.Client)
 		err = cc.Client.Disconnect(nil)
 	}
 	return
 }
 
-func (cc *Client) ListDatabaseNames(filters ...interface{}) ([]string, error) {
+func (cc *Client) ListDatabaseNames(filters ...interface{}) (ret []string, err error) {
 	var filter interface{}
 	if len(filters) > 0 {
 		filter = filters[0]
 	} else {
 		filter = ALL
 	}
-	return cc.Client.ListDatabaseNames(nil, filter)
+	op := &Operation{Name: Op_ListDatabaseNames, Filter: filter}
+	err = cc.execDatabase(op, func() (err error) {
+		ret, err = cc.Client.ListDatabaseNames(nil, op.Filter)
+		return
+	})
+	return
 }
 
 func (cc *Client) ListCollectionNames(filters ...interface{}) ([]string, error) {
-	var filter interface{}
-	if len(filters) > 0 {
-		filter = filters[0]
-	} else {
-		filter = ALL
-	}
-	return cc.Client.Database(cc.DB).ListCollectionNames(nil, filter)
+	return cc.DBListCollectionNames(cc.DB, filters...)
 }
 
 func (cc *Client) Collection(cl string, opts ...*options.CollectionOptions) *mongo.Collection {
-	if cc.collectionOptions != nil {
-		opts = append(opts, cc.collectionOptions)
-	}
-	return cc.Database(cc.DB).Collection(cl, opts...)
+	return cc.DBCollection(cc.DB, cl, opts...)
 }
 
 func (cc *Client) Count(cl string, filters ...interface{}) (ret int64, err error) {
-	if len(filters) == 0 {
-		return cc.Database(cc.DB).Collection(cl, cc.collectionOptions).EstimatedDocumentCount(nil)
-	} else {
-		return cc.Database(cc.DB).Collection(cl, cc.collectionOptions).CountDocuments(nil, filters[0])
-	}
-
+	return cc.DBCount(cc.DB, cl, filters...)
 }
 
 func (cc *Client) FindId(cl string, id interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
-	err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOne(nil, bson.M{"_id": id}, opts...).Decode(ret)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindId(cc.DB, cl, id, ret, opts...)
 }
 
 func (cc *Client) FindOne(cl string, filter interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
-	err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOne(nil, filter, opts...).Decode(ret)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindOne(cc.DB, cl, filter, ret, opts...)
 }
 
 func (cc *Client) Find(cl string, filter interface{}, ret interface{}, opts ...*options.FindOptions) (err error) {
-	cur, err := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).Find(nil, filter, opts...)
-	if err == nil {
-		err = cur.All(nil, ret)
-	}
-	return
+	return cc.DBFind(cc.DB, cl, filter, ret, opts...)
 }
 
 func (cc *Client) FindWith(cl string, filter interface{}, with func(cur *mongo.Cursor) error, opts ...*options.FindOptions) (err error) {
-	cur, err := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).Find(nil, filter, opts...)
-	if err == nil {
-		defer cur.Close(nil)
-		err = with(cur)
-	}
-	return
+	return cc.DBFindWith(cc.DB, cl, filter, with, opts...)
 }
 
 func (cc *Client) Distinct(cl string, fieldName string, filter interface{}, opts ...*options.DistinctOptions) (ret []interface{}, err error) {
-	ret, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).Distinct(nil, fieldName, filter, opts...)
-	return
+	return cc.DBDistinct(cc.DB, cl, fieldName, filter, opts...)
 }
 
 func (cc *Client) FindIdAndUpdate(cl string, id interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
-	result := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOneAndUpdate(nil, bson.M{"_id": id}, update, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindIdAndUpdate(cc.DB, cl, id, update, ret, opts...)
 }
 
 func (cc *Client) FindIdAndReplace(cl string, id interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
-	result := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOneAndReplace(nil, bson.M{"_id": id}, replace, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindIdAndReplace(cc.DB, cl, id, replace, ret, opts...)
 }
 
 func (cc *Client) FindIdAndDelete(cl string, id interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
-	result := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOneAndDelete(nil, bson.M{"_id": id}, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindIdAndDelete(cc.DB, cl, id, ret, opts...)
 }
 
 func (cc *Client) FindOneAndUpdate(cl string, filter interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
-	result := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOneAndUpdate(nil, filter, update, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindOneAndUpdate(cc.DB, cl, filter, update, ret, opts...)
 }
 
 func (cc *Client) FindOneAndReplace(cl string, filter interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
-	result := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOneAndReplace(nil, filter, replace, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindOneAndReplace(cc.DB, cl, filter, replace, ret, opts...)
 }
 
 func (cc *Client) FindOneAndDelete(cl string, filter interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
-	result := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).FindOneAndDelete(nil, filter, opts...)
-	if ret != nil {
-		err = result.Decode(ret)
-		if err == mongo.ErrNoDocuments {
-			not = true
-			err = nil
-		}
-	}
-	return
+	return cc.DBFindOneAndDelete(cc.DB, cl, filter, ret, opts...)
 }
 
 func (cc *Client) InsertOne(cl string, doc interface{}, opts ...*options.InsertOneOptions) (result *mongo.InsertOneResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).InsertOne(nil, doc, opts...)
-	if err != nil {
-		return
-	}
-	return
+	return cc.DBInsertOne(cc.DB, cl, doc, opts...)
 }
 
 func (cc *Client) InsertMany(cl string, docs []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).InsertMany(nil, docs, opts...)
-	if err != nil {
-		return
-	}
-	return
+	return cc.DBInsertMany(cc.DB, cl, docs, opts...)
 }
 
 func (cc *Client) ReplaceId(cl string, id interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).ReplaceOne(nil, bson.M{"_id": id}, replace, opts...)
-	return
+	return cc.DBReplaceId(cc.DB, cl, id, replace, opts...)
 }
 
 func (cc *Client) ReplaceOne(cl string, filter interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).ReplaceOne(nil, filter, replace, opts...)
-	return
+	return cc.DBReplaceOne(cc.DB, cl, filter, replace, opts...)
 }
 
 func (cc *Client) UpdateId(cl string, id interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).UpdateOne(nil, bson.M{"_id": id}, update, opts...)
-	return
+	return cc.DBUpdateId(cc.DB, cl, id, update, opts...)
 }
 
 func (cc *Client) UpdateOne(cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).UpdateOne(nil, filter, update, opts...)
-	return
+	return cc.DBUpdateOne(cc.DB, cl, filter, update, opts...)
 }
 
 func (cc *Client) UpdateMany(cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).UpdateMany(nil, filter, update, opts...)
-	return
+	return cc.DBUpdateMany(cc.DB, cl, filter, update, opts...)
 }
 
 func (cc *Client) DeleteId(cl string, id interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).DeleteOne(nil, bson.M{"_id": id}, opts...)
-	return
+	return cc.DBDeleteId(cc.DB, cl, id, opts...)
 }
 
 func (cc *Client) DeleteOne(cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).DeleteOne(nil, filter, opts...)
-	return
+	return cc.DBDeleteOne(cc.DB, cl, filter, opts...)
 }
 
 // 必须注意: empty filter会删除整个集合数据
 func (cc *Client) DeleteMany(cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).DeleteMany(nil, filter, opts...)
-	return
+	return cc.DBDeleteMany(cc.DB, cl, filter, opts...)
 }
 
 func (cc *Client) Aggregate(cl string, pipeline interface{}, ret interface{}, opts ...*options.AggregateOptions) (err error) {
-	cur, err := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).Aggregate(nil, pipeline, opts...)
-	if err == nil {
-		err = cur.All(nil, ret)
-	}
-	return
+	return cc.DBAggregate(cc.DB, cl, pipeline, ret, opts...)
 }
 
 func (cc *Client) AggregateWith(cl string, pipeline interface{}, with func(cur *mongo.Cursor), opts ...*options.AggregateOptions) (err error) {
-	cur, err := cc.Database(cc.DB).Collection(cl, cc.collectionOptions).Aggregate(nil, pipeline, opts...)
-	if err == nil {
-		defer cur.Close(nil)
-		with(cur)
-	}
-	return
+	return cc.DBAggregateWith(cc.DB, cl, pipeline, with, opts...)
+}
+
+func (cc *Client) AggregateWithErr(cl string, pipeline interface{}, with func(cur *mongo.Cursor) error, opts ...*options.AggregateOptions) (err error) {
+	return cc.DBAggregateWithErr(cc.DB, cl, pipeline, with, opts...)
 }
 
 func (cc *Client) BulkWrite(cl string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error) {
-	if len(models) == 0 {
-		return
-	}
-	result, err = cc.Database(cc.DB).Collection(cl, cc.collectionOptions).BulkWrite(nil, models, opts...)
-	return
+	return cc.DBBulkWrite(cc.DB, cl, models, opts...)
 }
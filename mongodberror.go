@@ -0,0 +1,266 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// 常用服务端错误码
+const (
+	ErrorCode_DuplicateKey         = 11000
+	ErrorCode_WriteConflict        = 112
+	ErrorCode_DocumentValidation   = 121
+	ErrorCode_MaxTimeMSExpired     = 50
+	ErrorCode_NetworkTimeout       = 89
+	ErrorCode_ExceededTimeLimit    = 262
+	ErrorCode_WriteConcernFailed   = 64 // wtimeout超时
+	ErrorCode_NotMaster            = 10107
+	ErrorCode_NotMasterNoSlaveOk   = 13435
+	ErrorCode_NotMasterOrSecondary = 13436
+	ErrorCode_PrimarySteppedDown   = 189
+	ErrorCode_InterruptedReplState = 11602
+	ErrorCode_ShutdownInProgress   = 91
+)
+
+// 错误标签
+const (
+	ErrorLabel_NetworkError              = "NetworkError"
+	ErrorLabel_RetryableWriteError       = "RetryableWriteError"
+	ErrorLabel_TransientTransactionError = "TransientTransactionError"
+)
+
+var (
+	duplicateKeyCodes = []int{ErrorCode_DuplicateKey, 11001, 12582}
+	notPrimaryCodes   = []int{ErrorCode_NotMaster, ErrorCode_NotMasterNoSlaveOk, ErrorCode_NotMasterOrSecondary, ErrorCode_PrimarySteppedDown, ErrorCode_InterruptedReplState, ErrorCode_ShutdownInProgress, 11600}
+	timeoutCodes      = []int{ErrorCode_MaxTimeMSExpired, ErrorCode_NetworkTimeout, ErrorCode_ExceededTimeLimit, ErrorCode_WriteConcernFailed, 202}
+	retryableCodes    = []int{11600, 11602, 10107, 13435, 13436, 189, 91, 7, 6, 89, 9001, 262} // 与驱动保持一致
+
+	duplicateKeyRegexp = regexp.MustCompile(`index:\s+(\S+)\s+dup key:\s+(.*)$`)
+)
+
+// helper方法返回的错误, 附带客户端主键, 数据库, 集合与操作名称. 原始错误可通过errors.As/Unwrap获取
+type Error struct {
+	Key        string // 客户端主键
+	Database   string // 数据库
+	Collection string // 集合
+	Op         string // 操作名称, 如FindId, InsertOne
+	Err        error  // 原始错误
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mongodb %v %v.%v %v: %v", e.Key, e.Database, e.Collection, e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (cc *Client) wrapError(op *Operation, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{
+		Key:        cc.key,
+		Database:   op.Database,
+		Collection: op.Collection,
+		Op:         op.Name,
+		Err:        err,
+	}
+}
+
+// 重复键信息
+type DuplicateKey struct {
+	Pos   int    // 在InsertMany/BulkWrite输入中的下标, 单文档操作为0
+	Index string // 违反的索引名称
+	Key   string // 重复的键值, 如 { name: "xxx" }
+}
+
+// 提取错误中的全部错误码, 支持CommandError, WriteException, BulkWriteException
+func ErrorCodes(err error) (codes []int) {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		codes = append(codes, int(ce.Code))
+	}
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			codes = append(codes, e.Code)
+		}
+		if we.WriteConcernError != nil {
+			codes = append(codes, we.WriteConcernError.Code)
+		}
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		for _, e := range bwe.WriteErrors {
+			codes = append(codes, e.Code)
+		}
+		if bwe.WriteConcernError != nil {
+			codes = append(codes, bwe.WriteConcernError.Code)
+		}
+	}
+	return
+}
+
+// 判断错误是否带有指定标签
+func HasErrorLabel(err error, label string) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) && ce.HasErrorLabel(label) {
+		return true
+	}
+	var we mongo.WriteException
+	if errors.As(err, &we) && we.HasErrorLabel(label) {
+		return true
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.HasErrorLabel(label) {
+		return true
+	}
+	return false
+}
+
+func hasErrorCode(err error, targets []int) bool {
+	for _, c := range ErrorCodes(err) {
+		for _, t := range targets {
+			if c == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 返回全部重复键信息
+func GetDuplicateKeys(err error) (ret []*DuplicateKey) {
+	parse := func(pos int, code int, msg string) {
+		for _, c := range duplicateKeyCodes {
+			if c == code {
+				dk := &DuplicateKey{Pos: pos}
+				if m := duplicateKeyRegexp.FindStringSubmatch(msg); m != nil {
+					dk.Index, dk.Key = m[1], strings.TrimSpace(m[2])
+				}
+				ret = append(ret, dk)
+				return
+			}
+		}
+	}
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			parse(e.Index, e.Code, e.Message)
+		}
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		for _, e := range bwe.WriteErrors {
+			parse(e.Index, e.Code, e.Message)
+		}
+	}
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		parse(0, int(ce.Code), ce.Message)
+	}
+	return
+}
+
+// 返回第一个重复键信息
+func GetDuplicateKey(err error) (*DuplicateKey, bool) {
+	if dks := GetDuplicateKeys(err); len(dks) > 0 {
+		return dks[0], true
+	}
+	return nil, false
+}
+
+// 是否违反唯一索引
+func IsDuplicateKey(err error) bool {
+	return hasErrorCode(err, duplicateKeyCodes)
+}
+
+// 是否写冲突(事务或并发更新)
+func IsWriteConflict(err error) bool {
+	return hasErrorCode(err, []int{ErrorCode_WriteConflict})
+}
+
+// 是否违反集合的validator规则
+func IsDocumentValidation(err error) bool {
+	return hasErrorCode(err, []int{ErrorCode_DocumentValidation})
+}
+
+// 是否非主节点或主节点切换中
+func IsNotPrimary(err error) bool {
+	if hasErrorCode(err, notPrimaryCodes) {
+		return true
+	}
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && strings.Contains(ce.Message, "not master")
+}
+
+// 是否服务端选择失败, 例如选主期间或集群不可达.
+// 当前驱动版本未导出服务端选择错误类型(以fmt.Errorf包装), 故匹配其哨兵错误, 或最内层错误以驱动固定的前缀开头
+func IsServerSelection(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, topology.ErrServerSelectionTimeout) {
+		return true
+	}
+	for inner := errors.Unwrap(err); inner != nil; inner = errors.Unwrap(err) {
+		err = inner
+	}
+	return strings.HasPrefix(err.Error(), "server selection error: ")
+}
+
+// 是否超时: context超时, 网络超时, maxTimeMS, wtimeout, 服务端选择失败(驱动在超时前持续重新选择)
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return hasErrorCode(err, timeoutCodes) || IsServerSelection(err)
+}
+
+// 是否网络错误. context超时不算网络错误
+func IsNetwork(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if HasErrorLabel(err, ErrorLabel_NetworkError) {
+		return true
+	}
+	var ce topology.ConnectionError
+	if errors.As(err, &ce) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// 是否可重试: 网络错误, 服务端选择失败, 可重试错误码或错误标签. 调用方取消或超时不可重试
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if HasErrorLabel(err, ErrorLabel_RetryableWriteError) || HasErrorLabel(err, ErrorLabel_TransientTransactionError) {
+		return true
+	}
+	return IsNetwork(err) || IsServerSelection(err) || hasErrorCode(err, retryableCodes)
+}
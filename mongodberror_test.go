@@ -0,0 +1,64 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+func TestIsDuplicateKey(t *testing.T) {
+	cc := &Client{key: "test"}
+	err := cc.wrapError(&Operation{Database: "db", Collection: "user", Name: Op_InsertMany}, mongo.BulkWriteException{
+		WriteErrors: []mongo.BulkWriteError{
+			{WriteError: mongo.WriteError{Index: 2, Code: 11000, Message: `E11000 duplicate key error collection: db.user index: name_1 dup key: { name: "xxx" }`}},
+		},
+	})
+	if !IsDuplicateKey(err) {
+		t.Fatal("expect duplicate key")
+	}
+	dk, ok := GetDuplicateKey(err)
+	if !ok || dk.Pos != 2 || dk.Index != "name_1" || dk.Key != `{ name: "xxx" }` {
+		t.Fatalf("invalid duplicate key: %+v", dk)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Key != "test" || e.Collection != "user" || e.Op != "InsertMany" {
+		t.Fatalf("invalid wrapped error: %v", err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	if !IsRetryable(mongo.CommandError{Code: ErrorCode_NotMaster}) || !IsNotPrimary(mongo.CommandError{Code: ErrorCode_NotMaster}) {
+		t.Fatal("not master should be retryable")
+	}
+	if !IsRetryable(mongo.CommandError{Labels: []string{ErrorLabel_NetworkError}}) {
+		t.Fatal("network error should be retryable")
+	}
+	if IsRetryable(mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: ErrorCode_DuplicateKey}}}) {
+		t.Fatal("duplicate key should not be retryable")
+	}
+	if IsRetryable(context.DeadlineExceeded) || !IsTimeout(context.DeadlineExceeded) {
+		t.Fatal("deadline exceeded should be timeout only")
+	}
+	if !IsWriteConflict(mongo.CommandError{Code: ErrorCode_WriteConflict}) || !IsDocumentValidation(mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: ErrorCode_DocumentValidation}}}) {
+		t.Fatal("invalid classification")
+	}
+}
+
+func TestIsServerSelection(t *testing.T) {
+	cc := &Client{key: "test"}
+	selectErr := errors.New("server selection error: server selection timeout, current topology: { Type: Unknown }")
+	if !IsServerSelection(cc.wrapError(&Operation{Name: Op_Find}, selectErr)) {
+		t.Fatal("expect server selection")
+	}
+	if !IsTimeout(cc.wrapError(&Operation{Name: Op_Find}, selectErr)) {
+		t.Fatal("server selection should be timeout")
+	}
+	// 仅错误信息中包含该文本不算
+	if IsServerSelection(mongo.CommandError{Message: "user text: server selection error"}) {
+		t.Fatal("unexpected server selection")
+	}
+	if IsTimeout(mongo.CommandError{Message: "user text: server selection timeout"}) {
+		t.Fatal("unexpected timeout")
+	}
+}
@@ -0,0 +1,146 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 操作名称
+const (
+	Op_ListDatabaseNames   = "ListDatabaseNames"
+	Op_ListCollectionNames = "ListCollectionNames"
+	Op_Count               = "Count"
+	Op_FindId              = "FindId"
+	Op_FindOne             = "FindOne"
+	Op_Find                = "Find"
+	Op_FindPage            = "FindPage"
+	Op_FindKeyset          = "FindKeyset"
+	Op_FindWith            = "FindWith"
+	Op_FindIter            = "FindIter"
+	Op_Distinct            = "Distinct"
+	Op_FindIdAndUpdate     = "FindIdAndUpdate"
+	Op_FindIdAndReplace    = "FindIdAndReplace"
+	Op_FindIdAndDelete     = "FindIdAndDelete"
+	Op_FindOneAndUpdate    = "FindOneAndUpdate"
+	Op_FindOneAndReplace   = "FindOneAndReplace"
+	Op_FindOneAndDelete    = "FindOneAndDelete"
+	Op_InsertOne           = "InsertOne"
+	Op_InsertMany          = "InsertMany"
+	Op_ReplaceId           = "ReplaceId"
+	Op_ReplaceOne          = "ReplaceOne"
+	Op_UpdateId            = "UpdateId"
+	Op_UpdateOne           = "UpdateOne"
+	Op_UpdateMany          = "UpdateMany"
+	Op_DeleteId            = "DeleteId"
+	Op_DeleteOne           = "DeleteOne"
+	Op_DeleteMany          = "DeleteMany"
+	Op_Aggregate           = "Aggregate"
+	Op_AggregateWith       = "AggregateWith"
+	Op_AggregateIter       = "AggregateIter"
+	Op_BulkWrite           = "BulkWrite"
+	Op_ParallelScan        = "ParallelScan"
+)
+
+// 操作描述, helper方法执行时构造, 闭包从中读取参数
+type Operation struct {
+	Key        string             // 客户端主键
+	Database   string             // 数据库
+	Collection string             // 集合
+	Name       string             // 操作名称, 见Op_XXX常量
+	Filter     interface{}        // 查询条件
+	Update     interface{}        // update或replace文档
+	Document   interface{}        // InsertOne文档
+	Documents  []interface{}      // InsertMany文档
+	Models     []mongo.WriteModel // BulkWrite模型
+	Pipeline   interface{}        // Aggregate管道
+	Options    interface{}        // 调用方传入的原始选项, 如[]*options.UpdateOptions, 中间件替换时须保持类型
+	Context    context.Context    // 调用方的context, 为空时不可取消, 如FindIter/ParallelScan
+
+	keep  string        // 替换时需从原文档保留的字段(自动时间戳的createdAt), 每次执行前读取
+	purge bool          // 物理删除已软删除的文档, 不改写为软删除
+	ids   []interface{} // DeleteMany已调用BeforeDelete的文档_id, 仅删除这些文档
+}
+
+// 统一执行入口: 所有集合级helper方法都经由此处访问集合, 读操作按需降级
+func (cc *Client) exec(op *Operation, fn func(coll *mongo.Collection) error) error {
+	return cc.execDatabase(op, func() error {
+		if err := fn(cc.Database(op.Database).Collection(op.Collection, cc.collectionOptions)); err != nil {
+			return cc.fallback(op, fn, err)
+		}
+		cc.readFallback.record(op, nil)
+		return nil
+	})
+}
+
+// 统一执行入口: 先经过中间件, 再执行操作, 并包装错误信息
+func (cc *Client) execDatabase(op *Operation, fn func() error) error {
+	op.Key = cc.key
+	return cc.wrapError(op, cc.intercept(op, func() error {
+		return cc.execOperation(op, fn)
+	}))
+}
+
+// 按集合策略改写文档后, 依次经过本地写日志, 熔断, 重试, 并发限制
+func (cc *Client) execOperation(op *Operation, fn func() error) (err error) {
+	if err = cc.beforeHooks(op); err != nil {
+		return cc.wrapError(op, err)
+	}
+	if err = cc.validate(op); err != nil {
+		return cc.wrapError(op, err)
+	}
+	doc, docs := op.Document, op.Documents
+	if err = cc.stamp(op); err != nil {
+		return cc.wrapError(op, err)
+	}
+	cc.markDeleted(op)
+	cc.excludeDeleted(op)
+	run := func() error {
+		return cc.circuit(op, func() error {
+			// 删除前读取文档调用BeforeDelete, 记入本地写日志时不执行
+			if err := cc.beforeDelete(op); err != nil {
+				return err
+			}
+			return cc.retry(op, func() error {
+				return cc.limit(op, func() error {
+					if err := cc.keepField(op); err != nil {
+						return err
+					}
+					return fn()
+				})
+			})
+		})
+	}
+	// 启用本地写日志时, 不可达错误的写操作记入日志
+	if cc.journal != nil {
+		err = cc.journal.exec(op, run)
+	} else {
+		err = run()
+	}
+	if err == nil {
+		err = afterHooks(op, doc, docs)
+	}
+	if err != nil {
+		err = cc.wrapError(op, err)
+	}
+	return
+}
+
+// 解析单文档结果, ErrNoDocuments转为not
+func decodeSingleResult(result *mongo.SingleResult, ret interface{}) (not bool, err error) {
+	return notFound(decode(result, ret))
+}
+
+// ret为空时仅返回错误
+func decode(result *mongo.SingleResult, ret interface{}) error {
+	if ret != nil {
+		return result.Decode(ret)
+	}
+	return result.Err()
+}
+
+func notFound(err error) (bool, error) {
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	return false, err
+}
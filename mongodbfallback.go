@@ -0,0 +1,107 @@
+package mongodb
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"sync"
+	"time"
+)
+
+// 读降级元数据, 记录句柄最近一次读操作的结果. 并发读取时应使用Load
+type ReadMeta struct {
+	Fallback bool  // 是否降级为secondaryPreferred读
+	Stale    bool  // 结果可能过期(来自从节点)
+	Cause    error // 触发降级的主节点选择错误
+
+	mux sync.Mutex
+}
+
+// 加锁读取当前元数据
+func (m *ReadMeta) Load() (fallback bool, stale bool, cause error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.Fallback, m.Stale, m.Cause
+}
+
+type readFallback struct {
+	maxStaleness time.Duration
+	meta         *ReadMeta
+}
+
+// 返回读降级句柄: FindId/FindOne/Find/FindPage/FindWith/Aggregate/AggregateWith遇到主节点选择失败时以secondaryPreferred重读.
+// maxStaleness为0表示不限制从节点延迟(否则不得小于90秒), meta非空时记录每次读操作是否降级, 主节点读成功时重置.
+// 多个goroutine共用句柄时meta仅反映最近一次读, 需逐次获取时应为每次调用创建句柄
+// 注意: 主节点选择失败需等待serverSelectionTimeout, 对延迟敏感的场景应调小该配置
+func (cc *Client) WithReadFallback(maxStaleness time.Duration, meta *ReadMeta) *Client {
+	ret := cc.clone()
+	ret.readFallback = &readFallback{
+		maxStaleness: maxStaleness,
+		meta:         meta,
+	}
+	return ret
+}
+
+// 读操作失败后尝试降级, 返回降级后的结果
+func (cc *Client) fallback(op *Operation, fn func(coll *mongo.Collection) error, err error) error {
+	rf := cc.readFallback
+	if rf == nil || !(IsServerSelection(err) || IsNotPrimary(err)) || !fallbackable(op) {
+		return err
+	}
+	var rpopts []readpref.Option
+	if rf.maxStaleness > 0 {
+		rpopts = append(rpopts, readpref.WithMaxStaleness(rf.maxStaleness))
+	}
+	coll := cc.Database(op.Database).Collection(op.Collection, cc.collectionOptions, options.Collection().SetReadPreference(readpref.SecondaryPreferred(rpopts...)))
+	if ferr := fn(coll); ferr != nil {
+		rf.record(op, nil)
+		return ferr
+	}
+	rf.record(op, err)
+	return nil
+}
+
+// 加锁记录读操作结果, cause为空表示未降级
+func (rf *readFallback) record(op *Operation, cause error) {
+	if rf == nil || rf.meta == nil || !fallbackable(op) {
+		return
+	}
+	m := rf.meta
+	m.mux.Lock()
+	m.Fallback, m.Stale, m.Cause = cause != nil, cause != nil, cause
+	m.mux.Unlock()
+}
+
+func fallbackable(op *Operation) bool {
+	switch op.Name {
+	case Op_FindId, Op_FindOne, Op_Find, Op_FindPage, Op_FindKeyset, Op_FindWith, Op_FindIter, Op_ParallelScan:
+		return true
+	case Op_Aggregate, Op_AggregateWith, Op_AggregateIter:
+		return !hasWriteStage(op.Pipeline) // $out/$merge只能在主节点执行
+	}
+	return false
+}
+
+func hasWriteStage(pipeline interface{}) bool {
+	raw, err := bson.Marshal(bson.M{"pipeline": pipeline})
+	if err != nil {
+		return true
+	}
+	stages, ok := bson.Raw(raw).Lookup("pipeline").ArrayOK()
+	if !ok {
+		return true
+	}
+	vals, _ := stages.Values()
+	for _, val := range vals {
+		if stage, ok := val.DocumentOK(); ok {
+			if _, err := stage.LookupErr("$out"); err == nil {
+				return true
+			}
+			if _, err := stage.LookupErr("$merge"); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
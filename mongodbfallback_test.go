@@ -0,0 +1,55 @@
+package mongodb
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+func TestClient_WithReadFallback(t *testing.T) {
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta ReadMeta
+	cc := (&Client{Client: client}).WithReadFallback(0, &meta)
+	selectErr := errors.New("server selection error: server selection timeout")
+
+	var calls int
+	err = cc.exec(&Operation{Database: "db", Collection: "cl", Name: Op_FindId}, func(coll *mongo.Collection) error {
+		if calls++; calls == 1 {
+			return selectErr
+		}
+		return nil
+	})
+	if fallback, stale, cause := meta.Load(); err != nil || calls != 2 || !fallback || !stale || cause != selectErr {
+		t.Fatalf("expect fallback read, got %v %v %v %v %v", err, calls, fallback, stale, cause)
+	}
+
+	err = cc.exec(&Operation{Database: "db", Collection: "cl", Name: Op_FindId}, func(coll *mongo.Collection) error {
+		return nil
+	})
+	if fallback, stale, cause := meta.Load(); err != nil || fallback || stale || cause != nil {
+		t.Fatalf("expect meta reset after primary read, got %v %v %v %v", err, fallback, stale, cause)
+	}
+
+	calls = 0
+	err = cc.exec(&Operation{Database: "db", Collection: "cl", Name: Op_UpdateId}, func(coll *mongo.Collection) error {
+		calls++
+		return selectErr
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("write should not fallback, got %v %v", err, calls)
+	}
+}
+
+func TestHasWriteStage(t *testing.T) {
+	if hasWriteStage(mongo.Pipeline{bson.D{{Key: "$match", Value: bson.M{}}}}) {
+		t.Fatal("$match is not a write stage")
+	}
+	if !hasWriteStage([]bson.M{{"$match": bson.M{}}, {"$out": "other"}}) {
+		t.Fatal("$out is a write stage")
+	}
+}
@@ -0,0 +1,116 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+const defaultHedgeDelay = 50 * time.Millisecond
+
+// 对冲读配置: FindId/FindOne/Find首个请求超过Delay未返回时, 以ReadPreference再发起一次相同的读, 先成功者胜出, 另一个被取消.
+// 未找到文档仅以首个请求的结果为准, 对冲读的未找到可能来自落后的从节点
+type Hedge struct {
+	Delay          time.Duration   // 发起对冲读的延迟, 默认50毫秒
+	ReadPreference *ReadPreference // 对冲读的读优先, 默认nearest
+
+	requests int64
+	hedged   int64
+	wins     int64
+}
+
+// 对冲读统计
+type HedgeStats struct {
+	Requests int64 // 经过对冲逻辑的读请求数
+	Hedged   int64 // 触发对冲的次数
+	Wins     int64 // 对冲读先于首个请求返回的次数
+}
+
+func (h *Hedge) Stats() HedgeStats {
+	return HedgeStats{
+		Requests: atomic.LoadInt64(&h.requests),
+		Hedged:   atomic.LoadInt64(&h.hedged),
+		Wins:     atomic.LoadInt64(&h.wins),
+	}
+}
+
+// 返回对冲读句柄, 同一个Hedge可被多个句柄共用以汇总统计
+func (cc *Client) WithHedge(h *Hedge) *Client {
+	ret := cc.clone()
+	ret.hedge = h
+	return ret
+}
+
+type hedgeResult struct {
+	ret    interface{}
+	err    error
+	hedged bool
+}
+
+// 执行可对冲的读, read需将结果解码至传入的ret. 各路读解码至独立的副本, 胜出者再复制到ret
+func (cc *Client) hedged(op *Operation, coll *mongo.Collection, ret interface{}, read func(ctx context.Context, coll *mongo.Collection, ret interface{}) error) error {
+	h := cc.hedge
+	if h == nil || ret == nil || reflect.TypeOf(ret).Kind() != reflect.Ptr {
+		return read(context.Background(), coll, ret)
+	}
+	atomic.AddInt64(&h.requests, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan *hedgeResult, 2)
+	start := func(coll *mongo.Collection, hedged bool) {
+		r := &hedgeResult{ret: reflect.New(reflect.TypeOf(ret).Elem()).Interface(), hedged: hedged}
+		go func() {
+			r.err = read(ctx, coll, r.ret)
+			results <- r
+		}()
+	}
+	start(coll, false)
+
+	delay := h.Delay
+	if delay <= 0 {
+		delay = defaultHedgeDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	var first *hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			rp := toReadPref(h.ReadPreference)
+			if rp == nil {
+				rp = readpref.Nearest()
+			}
+			start(cc.Database(op.Database).Collection(op.Collection, cc.collectionOptions, options.Collection().SetReadPreference(rp)), true)
+			atomic.AddInt64(&h.hedged, 1)
+			pending++
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.hedged {
+					atomic.AddInt64(&h.wins, 1)
+				}
+				reflect.ValueOf(ret).Elem().Set(reflect.ValueOf(r.ret).Elem())
+				return nil
+			}
+			if r.err == mongo.ErrNoDocuments {
+				if !r.hedged {
+					return r.err
+				}
+				// 对冲读的节点可能落后, 未找到不作为最终结果, 以首个请求为准
+				continue
+			}
+			if first == nil {
+				first = r
+			}
+		}
+	}
+	return first.err // 全部失败(首个请求在对冲前失败则不再对冲), 交由重试/降级处理
+}
@@ -0,0 +1,63 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+	"time"
+)
+
+func TestClient_WithHedge(t *testing.T) {
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Hedge{Delay: 10 * time.Millisecond}
+	cc := (&Client{Client: client}).WithHedge(h)
+	op := &Operation{Database: "db", Collection: "cl", Name: Op_FindId}
+	primary := cc.Database("db").Collection("cl")
+
+	cancelled := make(chan bool, 1)
+	var ret bson.M
+	err = cc.hedged(op, primary, &ret, func(ctx context.Context, coll *mongo.Collection, ret interface{}) error {
+		if coll == primary {
+			select {
+			case <-ctx.Done():
+				cancelled <- true
+				return ctx.Err()
+			case <-time.After(time.Second):
+				cancelled <- false
+			}
+		}
+		*(ret.(*bson.M)) = bson.M{"hedged": coll != primary}
+		return nil
+	})
+	if err != nil || ret["hedged"] != true {
+		t.Fatalf("expect hedged result, got %v %v", ret, err)
+	}
+	if !<-cancelled {
+		t.Fatal("slow read should be cancelled")
+	}
+	if stats := h.Stats(); stats.Requests != 1 || stats.Hedged != 1 || stats.Wins != 1 {
+		t.Fatalf("invalid stats: %+v", stats)
+	}
+
+	// 对冲读未找到时等待首个请求
+	ret = nil
+	err = cc.hedged(op, primary, &ret, func(ctx context.Context, coll *mongo.Collection, ret interface{}) error {
+		if coll != primary {
+			return mongo.ErrNoDocuments
+		}
+		time.Sleep(50 * time.Millisecond)
+		*(ret.(*bson.M)) = bson.M{"primary": true}
+		return nil
+	})
+	if err != nil || ret["primary"] != true {
+		t.Fatalf("expect primary result, got %v %v", ret, err)
+	}
+	if stats := h.Stats(); stats.Hedged != 2 || stats.Wins != 1 {
+		t.Fatalf("invalid stats: %+v", stats)
+	}
+}
@@ -0,0 +1,64 @@
+package mongodb
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Client的helper方法集合, 业务代码依赖该接口即可在单元测试中替换为mongodbtest.NewClient()的内存实现.
+// FindWith/AggregateWith直接暴露*mongo.Cursor, 无法脱离驱动实现, 故不在此列
+type Helper interface {
+	ListCollectionNames(filters ...interface{}) ([]string, error)
+	Count(cl string, filters ...interface{}) (ret int64, err error)
+	FindId(cl string, id interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error)
+	FindOne(cl string, filter interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error)
+	Find(cl string, filter interface{}, ret interface{}, opts ...*options.FindOptions) (err error)
+	FindPage(cl string, filter interface{}, page int64, size int64, sort interface{}, ret interface{}, opts ...*PageOptions) (*Page, error)
+	Distinct(cl string, fieldName string, filter interface{}, opts ...*options.DistinctOptions) (ret []interface{}, err error)
+	FindIdAndUpdate(cl string, id interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error)
+	FindIdAndReplace(cl string, id interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error)
+	FindIdAndDelete(cl string, id interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error)
+	FindOneAndUpdate(cl string, filter interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error)
+	FindOneAndReplace(cl string, filter interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error)
+	FindOneAndDelete(cl string, filter interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error)
+	InsertOne(cl string, doc interface{}, opts ...*options.InsertOneOptions) (result *mongo.InsertOneResult, err error)
+	InsertMany(cl string, docs []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error)
+	ReplaceId(cl string, id interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error)
+	ReplaceOne(cl string, filter interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error)
+	UpdateId(cl string, id interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error)
+	UpdateOne(cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error)
+	UpdateMany(cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error)
+	DeleteId(cl string, id interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error)
+	DeleteOne(cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error)
+	DeleteMany(cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error)
+	Aggregate(cl string, pipeline interface{}, ret interface{}, opts ...*options.AggregateOptions) (err error)
+	BulkWrite(cl string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error)
+
+	DBListCollectionNames(db string, filters ...interface{}) ([]string, error)
+	DBCount(db string, cl string, filters ...interface{}) (ret int64, err error)
+	DBFindId(db string, cl string, id interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error)
+	DBFindOne(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error)
+	DBFind(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOptions) (err error)
+	DBFindPage(db string, cl string, filter interface{}, page int64, size int64, sort interface{}, ret interface{}, opts ...*PageOptions) (*Page, error)
+	DBDistinct(db string, cl string, fieldName string, filter interface{}, opts ...*options.DistinctOptions) (ret []interface{}, err error)
+	DBFindIdAndUpdate(db string, cl string, id interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error)
+	DBFindIdAndReplace(db string, cl string, id interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error)
+	DBFindIdAndDelete(db string, cl string, id interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error)
+	DBFindOneAndUpdate(db string, cl string, filter interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error)
+	DBFindOneAndReplace(db string, cl string, filter interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error)
+	DBFindOneAndDelete(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error)
+	DBInsertOne(db string, cl string, doc interface{}, opts ...*options.InsertOneOptions) (result *mongo.InsertOneResult, err error)
+	DBInsertMany(db string, cl string, docs []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error)
+	DBReplaceId(db string, cl string, id interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error)
+	DBReplaceOne(db string, cl string, filter interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error)
+	DBUpdateId(db string, cl string, id interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error)
+	DBUpdateOne(db string, cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error)
+	DBUpdateMany(db string, cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error)
+	DBDeleteId(db string, cl string, id interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error)
+	DBDeleteOne(db string, cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error)
+	DBDeleteMany(db string, cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error)
+	DBAggregate(db string, cl string, pipeline interface{}, ret interface{}, opts ...*options.AggregateOptions) (err error)
+	DBBulkWrite(db string, cl string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error)
+}
+
+var _ Helper = (*Client)(nil)
@@ -0,0 +1,224 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+)
+
+// 文档生命周期钩子, 由文档类型实现, helper方法自动调用. Before钩子返回错误时中止操作, 错误经*Error包装返回.
+// 钩子按接口断言调用, 指针接收者的实现需传入指针
+type BeforeInserter interface {
+	BeforeInsert() error // InsertOne/InsertMany/InsertManyChunked写入前, 逐个文档调用, 可修改文档
+}
+
+type AfterInserter interface {
+	AfterInsert() error // 插入成功后逐个文档调用
+}
+
+type BeforeUpdater interface {
+	BeforeUpdate() error // ReplaceXXX/FindXXXAndReplace的替换文档, 或实现该接口的UpdateXXX更新文档
+}
+
+type AfterFinder interface {
+	AfterFind() error // FindId/FindOne/Find/FindPage/FindKeyset/Aggregate/FindIter/AggregateIter及FindXXXAndYYY解码后逐个文档调用, XXXWith及ParallelScan交出原始结果, 不调用
+}
+
+type BeforeDeleter interface {
+	BeforeDelete() error // DeleteXXX/FindXXXAndDelete删除前, 对匹配的文档逐个调用, 需以Model注册集合的文档类型. 记入本地写日志的删除不调用
+}
+
+var beforeDeleterType = reflect.TypeOf((*BeforeDeleter)(nil)).Elem()
+
+func (cc *Client) Model(cl string, model interface{}) *Client {
+	return cc.DBModel(cc.DB, cl, model)
+}
+
+// 注册集合的文档类型(如(*User)(nil)), 删除前按该类型读取匹配的文档以调用BeforeDelete. model为nil时取消
+func (cc *Client) DBModel(db string, cl string, model interface{}) *Client {
+	if model == nil {
+		cc.models.Delete(db + "." + cl)
+		return cc
+	}
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	cc.models.Store(db+"."+cl, t)
+	return cc
+}
+
+// 在execDatabase中调用, 按操作调用文档的Before钩子
+func (cc *Client) beforeHooks(op *Operation) error {
+	switch op.Name {
+	case Op_InsertOne:
+		return beforeInsert(op.Document)
+	case Op_InsertMany:
+		for _, doc := range op.Documents {
+			if err := beforeInsert(doc); err != nil {
+				return err
+			}
+		}
+	case Op_UpdateId, Op_UpdateOne, Op_UpdateMany, Op_FindIdAndUpdate, Op_FindOneAndUpdate,
+		Op_ReplaceId, Op_ReplaceOne, Op_FindIdAndReplace, Op_FindOneAndReplace:
+		if h, ok := op.Update.(BeforeUpdater); ok {
+			return h.BeforeUpdate()
+		}
+	}
+	return nil
+}
+
+// 插入成功后调用, docs为改写(如自动时间戳)前的原始文档
+func afterHooks(op *Operation, doc interface{}, docs []interface{}) error {
+	switch op.Name {
+	case Op_InsertOne:
+		return afterInsert(doc)
+	case Op_InsertMany:
+		for _, doc := range docs {
+			if err := afterInsert(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func beforeInsert(doc interface{}) error {
+	if h, ok := doc.(BeforeInserter); ok {
+		return h.BeforeInsert()
+	}
+	return nil
+}
+
+func afterInsert(doc interface{}) error {
+	if h, ok := doc.(AfterInserter); ok {
+		return h.AfterInsert()
+	}
+	return nil
+}
+
+// 按注册的文档类型读取将被删除的文档并调用BeforeDelete, 未注册或类型未实现时不读取.
+// 在熔断之内, 重试之外调用, 直接读取集合, 不经中间件, 对冲及降级
+func (cc *Client) beforeDelete(op *Operation) error {
+	switch op.Name {
+	case Op_DeleteId, Op_DeleteOne, Op_FindIdAndDelete, Op_FindOneAndDelete, Op_DeleteMany:
+	default:
+		return nil
+	}
+	if cc.models == nil {
+		return nil
+	}
+	v, ok := cc.models.Load(op.Database + "." + op.Collection)
+	if !ok {
+		return nil
+	}
+	t := v.(reflect.Type)
+	if !reflect.PtrTo(t).Implements(beforeDeleterType) {
+		return nil
+	}
+	if op.Name == Op_DeleteMany {
+		return cc.beforeDeleteMany(op, t)
+	}
+	return cc.beforeDeleteOne(op, t)
+}
+
+// 单文档删除: 按删除的条件及排序读取文档调用BeforeDelete, 再将条件限定为该文档的_id, 保证删除的即是调用钩子的文档
+func (cc *Client) beforeDeleteOne(op *Operation, t reflect.Type) error {
+	fopt := options.FindOne()
+	switch opts := op.Options.(type) {
+	case []*options.DeleteOptions:
+		fopt.Collation = options.MergeDeleteOptions(opts...).Collation
+	case []*options.FindOneAndDeleteOptions:
+		o := options.MergeFindOneAndDeleteOptions(opts...)
+		fopt.Collation, fopt.Sort = o.Collation, o.Sort
+	}
+	coll := cc.Database(op.Database).Collection(op.Collection, cc.collectionOptions)
+	raw, err := coll.FindOne(op.Context, op.Filter, fopt).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ptr := reflect.New(t)
+	if err = bson.Unmarshal(raw, ptr.Interface()); err != nil {
+		return err
+	}
+	if err = ptr.Interface().(BeforeDeleter).BeforeDelete(); err != nil {
+		return err
+	}
+	op.Filter = bson.D{{Key: "$and", Value: bson.A{allIfNil(op.Filter), bson.D{{Key: "_id", Value: raw.Lookup("_id")}}}}}
+	return nil
+}
+
+// 多文档删除: 以游标分批读取文档逐个调用BeforeDelete, 仅保留_id, 删除时按_id分批限定, 读取之后才匹配的文档不会被删除
+func (cc *Client) beforeDeleteMany(op *Operation, t reflect.Type) error {
+	ctx := op.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	fopt := options.Find().SetBatchSize(deleteBatchSize)
+	if opts, ok := op.Options.([]*options.DeleteOptions); ok {
+		fopt.Collation = options.MergeDeleteOptions(opts...).Collation
+	}
+	coll := cc.Database(op.Database).Collection(op.Collection, cc.collectionOptions)
+	cur, err := coll.Find(ctx, op.Filter, fopt)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+	ids := make([]interface{}, 0)
+	for cur.Next(ctx) {
+		ptr := reflect.New(t)
+		if err = cur.Decode(ptr.Interface()); err != nil {
+			return err
+		}
+		if err = ptr.Interface().(BeforeDeleter).BeforeDelete(); err != nil {
+			return err
+		}
+		ids = append(ids, cur.Current.Lookup("_id"))
+	}
+	if err = cur.Err(); err != nil {
+		return err
+	}
+	op.ids = ids
+	return nil
+}
+
+func (cc *Client) afterFind(op *Operation, ret interface{}) error {
+	if err := afterFind(ret); err != nil {
+		return cc.wrapError(op, err)
+	}
+	return nil
+}
+
+// 解码后调用AfterFind, ret为单个文档或文档切片的指针
+func afterFind(ret interface{}) error {
+	if h, ok := ret.(AfterFinder); ok {
+		return h.AfterFind()
+	}
+	rv := reflect.ValueOf(ret)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return nil
+	}
+	for i, sv := 0, rv.Elem(); i < sv.Len(); i++ {
+		ev := sv.Index(i)
+		if ev.Kind() == reflect.Interface {
+			ev = ev.Elem()
+		}
+		if !ev.IsValid() || ev.Kind() == reflect.Ptr && ev.IsNil() {
+			continue
+		}
+		if ev.Kind() != reflect.Ptr && ev.CanAddr() {
+			ev = ev.Addr()
+		}
+		if h, ok := ev.Interface().(AfterFinder); ok {
+			if err := h.AfterFind(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
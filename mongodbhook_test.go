@@ -0,0 +1,168 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"testing"
+)
+
+var errReadonly = errors.New("readonly")
+
+type hookedUser struct {
+	Id       int    `bson:"_id"`
+	Name     string `bson:"name"`
+	Readonly bool   `bson:"readonly"`
+	found    bool
+	inserted bool
+}
+
+func (u *hookedUser) BeforeInsert() error {
+	if u.Name == "" {
+		return errors.New("name required")
+	}
+	u.Name = strings.ToLower(u.Name)
+	return nil
+}
+
+func (u *hookedUser) AfterInsert() error {
+	u.inserted = true
+	return nil
+}
+
+func (u *hookedUser) BeforeUpdate() error {
+	u.Name = strings.ToLower(u.Name)
+	return nil
+}
+
+func (u *hookedUser) AfterFind() error {
+	u.found = true
+	return nil
+}
+
+func (u *hookedUser) BeforeDelete() error {
+	if u.Readonly {
+		return errReadonly
+	}
+	return nil
+}
+
+func TestClient_Hooks(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cc := mdb.Model("user", (*hookedUser)(nil))
+	defer cc.Close()
+
+	u := &hookedUser{Id: 1, Name: "Tom"}
+	if _, err := cc.InsertOne("user", u); err != nil || !u.inserted {
+		t.Fatalf("insert: %+v %v", u, err)
+	}
+	// Before钩子返回错误时中止, 整批不写入
+	if _, err := cc.InsertMany("user", []interface{}{&hookedUser{Id: 2, Name: "A"}, &hookedUser{Id: 3}}); err == nil || !strings.Contains(err.Error(), "name required") {
+		t.Fatalf("expect abort, got %v", err)
+	}
+	users := []interface{}{&hookedUser{Id: 2, Name: "Jerry"}, &hookedUser{Id: 3, Name: "Spike", Readonly: true}}
+	if _, err := cc.InsertMany("user", users); err != nil || !users[0].(*hookedUser).inserted || !users[1].(*hookedUser).inserted {
+		t.Fatalf("insert many: %v", err)
+	}
+
+	var one hookedUser
+	if _, err := cc.FindId("user", 1, &one); err != nil || !one.found || one.Name != "tom" {
+		t.Fatalf("find id: %+v %v", one, err)
+	}
+	var all []hookedUser
+	if err := cc.Find("user", mongodb.ALL, &all); err != nil || len(all) != 3 || !all[0].found || !all[2].found {
+		t.Fatalf("find: %+v %v", all, err)
+	}
+	var ptrs []*hookedUser
+	if _, err := cc.FindPage("user", mongodb.ALL, 1, 10, bson.M{"_id": 1}, &ptrs); err != nil || len(ptrs) != 3 || !ptrs[1].found {
+		t.Fatalf("find page: %+v %v", ptrs, err)
+	}
+
+	if _, err := cc.ReplaceId("user", 1, &hookedUser{Id: 1, Name: "TOMMY"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.FindId("user", 1, &one); err != nil || one.Name != "tommy" {
+		t.Fatalf("replace: %+v %v", one, err)
+	}
+
+	var agg []hookedUser
+	if err := cc.Aggregate("user", []bson.M{{"$sort": bson.M{"_id": 1}}}, &agg); err != nil || len(agg) != 3 || !agg[0].found || !agg[2].found {
+		t.Fatalf("aggregate: %+v %v", agg, err)
+	}
+	it, err := cc.AggregateIter(nil, "user", []bson.M{{"$sort": bson.M{"_id": 1}}}, (*hookedUser)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+		if u := it.Value().(*hookedUser); !u.found {
+			t.Fatalf("aggregate iter: %+v", u)
+		}
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按删除的排序读取调用钩子的文档, 即实际删除的文档
+	if _, err := cc.FindOneAndDelete("user", mongodb.ALL, &one, options.FindOneAndDelete().SetSort(bson.M{"_id": -1})); !errors.Is(err, errReadonly) {
+		t.Fatalf("expect readonly, got %v", err)
+	}
+	if _, err := cc.DeleteId("user", 3); !errors.Is(err, errReadonly) {
+		t.Fatalf("expect readonly, got %v", err)
+	}
+	if _, err := cc.DeleteMany("user", mongodb.ALL); !errors.Is(err, errReadonly) {
+		t.Fatalf("expect readonly, got %v", err)
+	}
+	if ret, err := cc.DeleteMany("user", bson.M{"readonly": false}); err != nil || ret.DeletedCount != 2 {
+		t.Fatalf("delete: %v %v", ret, err)
+	}
+}
+
+var deleting func(it *deletingItem)
+
+type deletingItem struct {
+	Id  int    `bson:"_id"`
+	Tag string `bson:"tag"`
+}
+
+func (it *deletingItem) BeforeDelete() error {
+	deleting(it)
+	return nil
+}
+
+func TestClient_BeforeDeleteMany(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cc := mdb.Model("item", (*deletingItem)(nil))
+	defer cc.Close()
+
+	if _, err := cc.InsertMany("item", []interface{}{bson.M{"_id": 1, "tag": "a"}, bson.M{"_id": 2, "tag": "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	var seen []int
+	deleting = func(it *deletingItem) {
+		seen = append(seen, it.Id)
+		if len(seen) == 2 {
+			// 读取之后才匹配的文档未调用钩子, 不被删除
+			if _, err := cc.Database("test").Collection("item").InsertOne(nil, bson.M{"_id": 3, "tag": "a"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if ret, err := cc.DeleteMany("item", bson.M{"tag": "a"}); err != nil || ret.DeletedCount != 2 || len(seen) != 2 {
+		t.Fatalf("delete: %v %v %v", ret, seen, err)
+	}
+	var left []bson.M
+	if err := cc.Find("item", mongodb.ALL, &left); err != nil || len(left) != 1 || left[0]["_id"] != int32(3) {
+		t.Fatalf("left: %v %v", left, err)
+	}
+}
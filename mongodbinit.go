@@ -46,6 +46,12 @@ func init() {
 			zstdLevel, _ := conf.ElemInt(config, "zstdLevel")
 			retryReads, _ := conf.ElemBool(config, "retryReads")
 			retryWrites, _ := conf.ElemBool(config, "retryWrites")
+			retryPolicy, _ := GetRetryPolicy(conf.Elem(config, "retryPolicy"))
+			circuitBreaker, _ := GetCircuitBreaker(conf.Elem(config, "circuitBreaker"))
+			limiter, _ := GetLimiter(conf.Elem(config, "limiter"))
+			maxPageSize, _ := conf.ElemInt64(config, "maxPageSize")
+			pageTokenSecret, _ := conf.ElemString(config, "pageTokenSecret")
+			journal, _ := GetJournal(conf.Elem(config, "journal"))
 
 			if err := Setup(key, &Config{
 				Address:                address,
@@ -72,6 +78,12 @@ func init() {
 				ZstdLevel:              zstdLevel,
 				RetryReads:             retryReads,
 				RetryWrites:            retryWrites,
+				RetryPolicy:            retryPolicy,
+				CircuitBreaker:         circuitBreaker,
+				Limiter:                limiter,
+				MaxPageSize:            maxPageSize,
+				PageTokenSecret:        pageTokenSecret,
+				Journal:                journal,
 			}); err != nil {
 				panic(err)
 			}
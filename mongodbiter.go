@@ -0,0 +1,208 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sync"
+)
+
+// 结果迭代器, 逐条解码为指定类型. 遍历结束, 出错或ctx取消后自动关闭游标, 提前退出时须调用Close
+//
+//	it, err := cc.FindIter(ctx, "user", filter, (*User)(nil))
+//	defer it.Close()
+//	for it.Next() {
+//	    u := it.Value().(*User)
+//	}
+//	err = it.Err()
+type Iterator struct {
+	cc    *Client
+	op    *Operation
+	ctx   context.Context
+	cur   *mongo.Cursor
+	typ   reflect.Type
+	value interface{}
+	err   error
+
+	mux     sync.Mutex
+	done    chan struct{} // Chan模式下通知后台goroutine退出
+	exited  chan struct{}
+	closing bool
+}
+
+// typ为结果类型的样例值, 如(*User)(nil)得到*User, User{}得到User, nil得到bson.M
+func (cc *Client) FindIter(ctx context.Context, cl string, filter interface{}, typ interface{}, opts ...*options.FindOptions) (*Iterator, error) {
+	return cc.DBFindIter(ctx, cc.DB, cl, filter, typ, opts...)
+}
+
+func (cc *Client) DBFindIter(ctx context.Context, db string, cl string, filter interface{}, typ interface{}, opts ...*options.FindOptions) (*Iterator, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cur *mongo.Cursor
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindIter, Filter: filter, Options: opts, Context: ctx}
+	if err := cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.FindOptions)
+		cur, err = coll.Find(ctx, op.Filter, opts...)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	return newIterator(ctx, cc, op, cur, typ), nil
+}
+
+func (cc *Client) AggregateIter(ctx context.Context, cl string, pipeline interface{}, typ interface{}, opts ...*options.AggregateOptions) (*Iterator, error) {
+	return cc.DBAggregateIter(ctx, cc.DB, cl, pipeline, typ, opts...)
+}
+
+func (cc *Client) DBAggregateIter(ctx context.Context, db string, cl string, pipeline interface{}, typ interface{}, opts ...*options.AggregateOptions) (*Iterator, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cur *mongo.Cursor
+	op := &Operation{Database: db, Collection: cl, Name: Op_AggregateIter, Pipeline: pipeline, Options: opts, Context: ctx}
+	if err := cc.exec(op, func(coll *mongo.Collection) (err error) {
+		opts, _ := op.Options.([]*options.AggregateOptions)
+		cur, err = coll.Aggregate(ctx, op.Pipeline, opts...)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	return newIterator(ctx, cc, op, cur, typ), nil
+}
+
+func newIterator(ctx context.Context, cc *Client, op *Operation, cur *mongo.Cursor, typ interface{}) *Iterator {
+	t := reflect.TypeOf(typ)
+	if t == nil {
+		t = reflect.TypeOf(bson.M(nil))
+	}
+	return &Iterator{cc: cc, op: op, ctx: ctx, cur: cur, typ: t}
+}
+
+// 取下一条并解码, 返回false表示结束或出错(见Err)
+func (it *Iterator) Next() bool {
+	it.mux.Lock()
+	defer it.mux.Unlock()
+	return it.next()
+}
+
+func (it *Iterator) next() bool {
+	it.value = nil
+	if it.cur == nil {
+		return false
+	}
+	if !it.cur.Next(it.ctx) {
+		it.fail(it.cur.Err())
+		return false
+	}
+	var ptr reflect.Value
+	if it.typ.Kind() == reflect.Ptr {
+		ptr = reflect.New(it.typ.Elem())
+	} else {
+		ptr = reflect.New(it.typ)
+	}
+	if err := it.cur.Decode(ptr.Interface()); err != nil {
+		it.fail(err)
+		return false
+	}
+	if it.op.Name == Op_FindIter || it.op.Name == Op_AggregateIter {
+		if err := afterFind(ptr.Interface()); err != nil {
+			it.fail(err)
+			return false
+		}
+	}
+	if it.typ.Kind() == reflect.Ptr {
+		it.value = ptr.Interface()
+	} else {
+		it.value = ptr.Elem().Interface()
+	}
+	return true
+}
+
+// 记录错误并关闭游标
+func (it *Iterator) fail(err error) {
+	if err != nil && it.err == nil {
+		it.err = it.cc.wrapError(it.op, err)
+	}
+	it.closeCursor()
+}
+
+func (it *Iterator) closeCursor() {
+	if it.cur != nil {
+		it.cur.Close(context.Background())
+		it.cur = nil
+	}
+}
+
+// 当前值, 类型由创建时的typ决定
+func (it *Iterator) Value() interface{} {
+	return it.value
+}
+
+// 遍历中的错误, 包括游标, 解码错误及ctx取消
+func (it *Iterator) Err() error {
+	it.mux.Lock()
+	defer it.mux.Unlock()
+	return it.err
+}
+
+// 关闭游标, 可重复调用. Chan模式下等待后台goroutine退出
+func (it *Iterator) Close() error {
+	it.mux.Lock()
+	if it.done == nil {
+		it.closeCursor()
+		it.mux.Unlock()
+		return nil
+	}
+	if !it.closing {
+		it.closing = true
+		close(it.done)
+	}
+	exited := it.exited
+	it.mux.Unlock()
+	<-exited
+	return nil
+}
+
+// 通道方式遍历: 后台goroutine逐条解码并写入无缓冲通道, 消费方读取后才取下一条.
+// 遍历结束, 出错, ctx取消或Close后通道关闭, 之后以Err获取错误. 只能调用一次, 且不可与Next混用
+func (it *Iterator) Chan() <-chan interface{} {
+	ch := make(chan interface{})
+	it.mux.Lock()
+	it.done = make(chan struct{})
+	it.exited = make(chan struct{})
+	done, exited := it.done, it.exited
+	it.mux.Unlock()
+
+	go func() {
+		defer close(exited)
+		defer close(ch)
+		for {
+			it.mux.Lock()
+			ok := it.next()
+			value := it.value
+			it.mux.Unlock()
+			if !ok {
+				return
+			}
+			select {
+			case ch <- value:
+			case <-done:
+				it.stop(nil)
+				return
+			case <-it.ctx.Done():
+				it.stop(it.ctx.Err())
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (it *Iterator) stop(err error) {
+	it.mux.Lock()
+	it.fail(err)
+	it.mux.Unlock()
+}
@@ -0,0 +1,115 @@
+package mongodb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+type iterItem struct {
+	Id  string `bson:"_id"`
+	Age int    `bson:"age"`
+}
+
+func TestClient_FindIter(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	docs := make([]interface{}, 0, 30)
+	for i := 0; i < 30; i++ {
+		docs = append(docs, &iterItem{Id: fmt.Sprintf("i%02d", i), Age: i})
+	}
+	docs = append(docs, bson.M{"_id": "bad", "age": "x"})
+	if _, err := cc.InsertMany("item", docs); err != nil {
+		t.Fatal(err)
+	}
+	valid := bson.M{"_id": bson.M{"$ne": "bad"}}
+
+	it, err := cc.FindIter(nil, "item", valid, (*iterItem)(nil), options.Find().SetBatchSize(7).SetSort(bson.M{"age": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.Next() {
+		if v := it.Value().(*iterItem); v.Age != n {
+			t.Fatalf("unexpected: %v", v)
+		}
+		n++
+	}
+	if it.Err() != nil || n != 30 {
+		t.Fatalf("unexpected: %v %v", n, it.Err())
+	}
+	it.Close()
+
+	// 解码错误中止遍历
+	it, err = cc.FindIter(nil, "item", mongodb.ALL, iterItem{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() || it.Err() == nil {
+		t.Fatalf("expect decode error: %v", it.Value())
+	}
+	if e, ok := it.Err().(*mongodb.Error); !ok || e.Op != mongodb.Op_FindIter {
+		t.Fatalf("unexpected error: %v", it.Err())
+	}
+
+	// 聚合结果以通道读取, ctx取消后通道关闭
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it, err = cc.AggregateIter(ctx, "item", mongo.Pipeline{{{Key: "$match", Value: valid}}, {{Key: "$sort", Value: bson.M{"age": 1}}}}, nil, options.Aggregate().SetBatchSize(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n = 0
+	for v := range it.Chan() {
+		if v.(bson.M)["age"] != int32(n) {
+			t.Fatalf("unexpected: %v", v)
+		}
+		if n++; n == 10 {
+			cancel()
+		}
+	}
+	if it.Err() == nil || n > 11 {
+		t.Fatalf("expect canceled: %v %v", n, it.Err())
+	}
+	it.Close()
+
+	// 提前Close释放后台goroutine
+	it, err = cc.FindIter(nil, "item", valid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := it.Chan()
+	<-ch
+	it.Close()
+	for range ch {
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+
+	// AggregateWithErr返回回调中的错误
+	stop := errors.New("stop")
+	var seen int
+	err = cc.AggregateWithErr("item", mongo.Pipeline{{{Key: "$match", Value: valid}}}, func(cur *mongo.Cursor) error {
+		for cur.Next(nil) {
+			if seen++; seen == 3 {
+				return stop
+			}
+		}
+		return cur.Err()
+	})
+	if err != stop || seen != 3 {
+		t.Fatalf("expect callback error: %v %v", seen, err)
+	}
+}
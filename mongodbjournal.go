@@ -0,0 +1,858 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/obase/conf"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJournalMaxBytes       = 64 * 1024 * 1024
+	defaultJournalReplayInterval = 5 * time.Second
+	defaultJournalMarkers        = "_journal"
+	journalMarkerRetention       = 24 * time.Hour // 清理失败而遗留的幂等键的保留时间
+	journalGuard                 = "_jkey"        // 不支持事务时写入被非幂等更新修改的文档, 记录最近一次重放的条目键
+)
+
+var (
+	ErrJournalClosed  = errors.New("mongodb journal closed")
+	errJournalPending = errors.New("mongodb journal pending") // 日志非空, 为保证顺序直接记录
+)
+
+// 本地写日志(store-and-forward)配置. 写操作因网络, 服务端选择失败或熔断而失败时追加至本地文件, 连接恢复后按序重放.
+// 日志非空期间的新写操作同样记入日志以保证顺序. 被记入日志的写操作返回*JournaledError
+// 重放无法保证幂等的写操作(非_id条件的UpdateOne/ReplaceOne/DeleteOne, upsert或管道形式的非幂等更新)不记录
+type Journal struct {
+	Path           string        `json:"Path" yaml:"Path"`                     // 日志文件路径
+	MaxBytes       int64         `json:"MaxBytes" yaml:"MaxBytes"`             // 日志文件大小上限, 超出时不再记录并返回原错误, 默认64M
+	ReplayInterval time.Duration `json:"ReplayInterval" yaml:"ReplayInterval"` // 重放检查间隔, 默认5秒
+	Markers        string        `json:"Markers" yaml:"Markers"`               // 已重放条目的幂等键集合, 与条目同库, 默认_journal. 条目移出日志后删除
+
+	OnDrop func(entry *JournalEntry, err error) `json:"-" yaml:"-"` // 条目因非网络错误无法重放而被丢弃时回调
+}
+
+// 日志条目, 写操作统一转换为BulkWrite模型
+type JournalEntry struct {
+	Key        string          `bson:"_id"` // 幂等键
+	Time       time.Time       `bson:"time"`
+	Database   string          `bson:"db"`
+	Collection string          `bson:"cl"`
+	Op         string          `bson:"op"`
+	Ordered    bool            `bson:"ordered"`
+	Models     []*JournalModel `bson:"models"`
+	Cause      string          `bson:"cause"` // 记录时的原始错误
+}
+
+type JournalModel struct {
+	Type         string             `bson:"type"` // insertOne | updateOne | updateMany | replaceOne | deleteOne | deleteMany
+	Filter       interface{}        `bson:"filter,omitempty"`
+	Document     interface{}        `bson:"doc,omitempty"` // 插入文档, update或replace文档
+	Upsert       bool               `bson:"upsert,omitempty"`
+	ArrayFilters []interface{}      `bson:"arrayFilters,omitempty"`
+	Collation    *options.Collation `bson:"collation,omitempty"`
+	Hint         interface{}        `bson:"hint,omitempty"`
+	Keep         string             `bson:"keep,omitempty"`  // 重放时从原文档读取的字段, 如自动时间戳的createdAt
+	Guard        bool               `bson:"guard,omitempty"` // 非幂等更新, 重放须在事务中执行或以条目键守卫
+}
+
+// 日志统计
+type JournalStats struct {
+	Pending   int    // 待重放条目数
+	Bytes     int64  // 日志文件大小
+	Journaled int64  // 累计记录条目数
+	Replayed  int64  // 累计重放成功条目数
+	Dropped   int64  // 累计丢弃条目数
+	LastError string // 最近一次重放失败的错误
+}
+
+// 写操作已记入本地日志, 将在连接恢复后重放. 原始错误可经Unwrap获取
+type JournaledError struct {
+	Key string // 条目幂等键
+	Err error
+}
+
+func (e *JournaledError) Error() string {
+	return fmt.Sprintf("journaled %v: %v", e.Key, e.Err)
+}
+
+func (e *JournaledError) Unwrap() error {
+	return e.Err
+}
+
+func IsJournaled(err error) bool {
+	var je *JournaledError
+	return errors.As(err, &je)
+}
+
+type journal struct {
+	cfg   Journal
+	cc    *Client // 不带日志的句柄, 用于重放
+	mux   sync.Mutex
+	file  *os.File
+	stats JournalStats
+
+	replayMux sync.Mutex
+	txn       *bool           // 部署是否支持事务, 首次重放非幂等条目时探测
+	created   map[string]bool // 已创建幂等键集合的数据库
+	done      chan struct{}
+	exited    chan struct{}
+}
+
+// 启用本地写日志, 打开(或创建)日志文件并启动后台重放
+func (cc *Client) OpenJournal(j *Journal) (err error) {
+	jn := &journal{cfg: *j, done: make(chan struct{}), exited: make(chan struct{})}
+	if jn.cfg.Path == "" {
+		return fmt.Errorf("mongodb journal requires Path")
+	}
+	if jn.cfg.MaxBytes <= 0 {
+		jn.cfg.MaxBytes = defaultJournalMaxBytes
+	}
+	if jn.cfg.ReplayInterval <= 0 {
+		jn.cfg.ReplayInterval = defaultJournalReplayInterval
+	}
+	if jn.cfg.Markers == "" {
+		jn.cfg.Markers = defaultJournalMarkers
+	}
+	// 读取已有条目, 截掉进程崩溃时写了一半的尾部
+	entries, size, err := readJournal(jn.cfg.Path)
+	if err != nil {
+		return
+	}
+	if jn.file, err = os.OpenFile(jn.cfg.Path, os.O_CREATE|os.O_RDWR, 0644); err != nil {
+		return
+	}
+	if err = jn.file.Truncate(size); err == nil {
+		_, err = jn.file.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		jn.file.Close()
+		return
+	}
+	jn.stats.Pending, jn.stats.Bytes = len(entries), size
+
+	jn.cc = cc.clone()
+	jn.cc.journal = nil
+	cc.journal = jn
+	go jn.loop()
+	return nil
+}
+
+// 当前日志中的全部条目, 未启用日志时返回nil
+func (cc *Client) JournalEntries() ([]*JournalEntry, error) {
+	if cc.journal == nil {
+		return nil, nil
+	}
+	return cc.journal.entries()
+}
+
+func (cc *Client) JournalStats() *JournalStats {
+	if cc.journal == nil {
+		return nil
+	}
+	cc.journal.mux.Lock()
+	defer cc.journal.mux.Unlock()
+	ret := cc.journal.stats
+	return &ret
+}
+
+// 立即重放, 遇到网络类错误时停止并返回该错误
+func (cc *Client) ReplayJournal() error {
+	if cc.journal == nil {
+		return nil
+	}
+	return cc.journal.replay()
+}
+
+// 在execDatabase中调用: 日志非空时直接记录, 否则执行后对不可达错误记录
+func (jn *journal) exec(op *Operation, fn func() error) error {
+	if !journalable(op) {
+		return fn()
+	}
+	jn.mux.Lock()
+	pending := jn.stats.Pending
+	jn.mux.Unlock()
+	// 首次执行前确定插入文档的_id, 结果未知时记录的文档与已发送的相同, 重放可经重复键识别
+	assignIds(op)
+	if pending > 0 {
+		return jn.append(op, errJournalPending, nil)
+	}
+	err := fn()
+	if err != nil && unreachable(err) {
+		return jn.append(op, err, err)
+	}
+	return err
+}
+
+// 为未指定_id的插入文档补充_id, 替换op中的文档及模型, 不修改调用方的原值
+func assignIds(op *Operation) {
+	switch op.Name {
+	case Op_InsertOne:
+		if doc, err := ensureId(op.Document); err == nil {
+			op.Document = doc
+		}
+	case Op_InsertMany:
+		docs := make([]interface{}, len(op.Documents))
+		for i, doc := range op.Documents {
+			if raw, err := ensureId(doc); err == nil {
+				docs[i] = raw
+			} else {
+				docs[i] = doc
+			}
+		}
+		op.Documents = docs
+	case Op_BulkWrite:
+		models := make([]mongo.WriteModel, len(op.Models))
+		for i, model := range op.Models {
+			models[i] = model
+			if m, ok := model.(*mongo.InsertOneModel); ok {
+				if doc, err := ensureId(m.Document); err == nil {
+					models[i] = mongo.NewInsertOneModel().SetDocument(doc)
+				}
+			}
+		}
+		op.Models = models
+	}
+}
+
+func journalable(op *Operation) bool {
+	switch op.Name {
+	case Op_InsertOne, Op_InsertMany, Op_ReplaceId, Op_ReplaceOne, Op_UpdateId, Op_UpdateOne, Op_UpdateMany, Op_DeleteId, Op_DeleteOne, Op_DeleteMany, Op_BulkWrite:
+		return true
+	}
+	return false
+}
+
+func unreachable(err error) bool {
+	return IsNetwork(err) || IsServerSelection(err) || errors.Is(err, ErrCircuitOpen)
+}
+
+// 记录条目, 失败时返回orig(为nil时返回记录错误)
+func (jn *journal) append(op *Operation, cause error, orig error) error {
+	entry, err := newJournalEntry(op, cause)
+	if err == nil {
+		var bs []byte
+		if bs, err = bson.Marshal(entry); err == nil {
+			jn.mux.Lock()
+			switch {
+			case jn.file == nil:
+				err = ErrJournalClosed
+			case jn.stats.Bytes+int64(len(bs)) > jn.cfg.MaxBytes:
+				err = fmt.Errorf("mongodb journal full: %v bytes", jn.stats.Bytes)
+			default:
+				if _, err = jn.file.Write(bs); err == nil {
+					err = jn.file.Sync()
+				}
+				if err == nil {
+					jn.stats.Pending++
+					jn.stats.Journaled++
+					jn.stats.Bytes += int64(len(bs))
+				}
+			}
+			jn.mux.Unlock()
+		}
+	}
+	if err != nil {
+		if orig != nil {
+			return orig
+		}
+		return err
+	}
+	return &JournaledError{Key: entry.Key, Err: cause}
+}
+
+func newJournalEntry(op *Operation, cause error) (*JournalEntry, error) {
+	entry := &JournalEntry{
+		Key:        primitive.NewObjectID().Hex(),
+		Time:       time.Now(),
+		Database:   op.Database,
+		Collection: op.Collection,
+		Op:         op.Name,
+		Ordered:    true,
+		Cause:      cause.Error(),
+	}
+	var models []mongo.WriteModel
+	switch op.Name {
+	case Op_InsertOne:
+		models = []mongo.WriteModel{mongo.NewInsertOneModel().SetDocument(op.Document)}
+	case Op_InsertMany:
+		for _, doc := range op.Documents {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		}
+		if opts, ok := op.Options.([]*options.InsertManyOptions); ok {
+			if o := options.MergeInsertManyOptions(opts...); o.Ordered != nil {
+				entry.Ordered = *o.Ordered
+			}
+		}
+	case Op_ReplaceId, Op_ReplaceOne:
+		m := mongo.NewReplaceOneModel().SetFilter(op.Filter).SetReplacement(op.Update)
+		if opts, ok := op.Options.([]*options.ReplaceOptions); ok {
+			o := options.MergeReplaceOptions(opts...)
+			m.Upsert, m.Collation, m.Hint = o.Upsert, o.Collation, o.Hint
+		}
+		models = []mongo.WriteModel{m}
+	case Op_UpdateId, Op_UpdateOne, Op_UpdateMany:
+		o := options.Update()
+		if opts, ok := op.Options.([]*options.UpdateOptions); ok {
+			o = options.MergeUpdateOptions(opts...)
+		}
+		if op.Name == Op_UpdateMany {
+			m := mongo.NewUpdateManyModel().SetFilter(op.Filter).SetUpdate(op.Update)
+			m.Upsert, m.Collation, m.Hint, m.ArrayFilters = o.Upsert, o.Collation, o.Hint, o.ArrayFilters
+			models = []mongo.WriteModel{m}
+		} else {
+			m := mongo.NewUpdateOneModel().SetFilter(op.Filter).SetUpdate(op.Update)
+			m.Upsert, m.Collation, m.Hint, m.ArrayFilters = o.Upsert, o.Collation, o.Hint, o.ArrayFilters
+			models = []mongo.WriteModel{m}
+		}
+	case Op_DeleteId, Op_DeleteOne, Op_DeleteMany:
+		o := options.Delete()
+		if opts, ok := op.Options.([]*options.DeleteOptions); ok {
+			o = options.MergeDeleteOptions(opts...)
+		}
+		switch {
+		case op.Name == Op_DeleteMany:
+			for _, filter := range deleteFilters(op) {
+				if op.Update != nil { // 软删除
+					models = append(models, &mongo.UpdateManyModel{Filter: filter, Update: op.Update, Collation: o.Collation, Hint: o.Hint})
+				} else {
+					models = append(models, &mongo.DeleteManyModel{Filter: filter, Collation: o.Collation, Hint: o.Hint})
+				}
+			}
+		case op.Update != nil:
+			models = []mongo.WriteModel{&mongo.UpdateOneModel{Filter: op.Filter, Update: op.Update, Collation: o.Collation, Hint: o.Hint}}
+		default:
+			models = []mongo.WriteModel{&mongo.DeleteOneModel{Filter: op.Filter, Collation: o.Collation, Hint: o.Hint}}
+		}
+	case Op_BulkWrite:
+		models = op.Models
+		if opts, ok := op.Options.([]*options.BulkWriteOptions); ok {
+			if o := options.MergeBulkWriteOptions(opts...); o.Ordered != nil {
+				entry.Ordered = *o.Ordered
+			}
+		}
+	}
+	// 写入后, 记录幂等键前中断时条目会再次重放, 非幂等的模型须能加守卫, 否则不记录
+	safe := op.Name != Op_BulkWrite && (&RetryPolicy{}).idempotent(op)
+	for _, model := range models {
+		jm, err := toJournalModel(model)
+		if err != nil {
+			return nil, err
+		}
+		if !safe && !(&RetryPolicy{}).idempotent(modelOperation(model)) {
+			if !guardable(op, model) {
+				return nil, fmt.Errorf("mongodb journal: %v is not idempotent on replay", op.Name)
+			}
+			jm.Guard = true
+		}
+		entry.Models = append(entry.Models, jm)
+	}
+	// 尚未读取到原文档的保留字段, 留待重放时读取
+	if d, ok := op.Update.(bson.D); ok && op.keep != "" && isZeroField(d, op.keep) {
+		entry.Models[0].Keep = op.keep
+	}
+	return entry, nil
+}
+
+func toJournalModel(model mongo.WriteModel) (*JournalModel, error) {
+	var jm *JournalModel
+	switch m := model.(type) {
+	case *mongo.InsertOneModel:
+		// _id已由assignIds在首次执行前确定
+		jm = &JournalModel{Type: "insertOne", Document: m.Document}
+	case *mongo.UpdateOneModel:
+		jm = &JournalModel{Type: "updateOne", Filter: m.Filter, Document: m.Update, Upsert: m.Upsert != nil && *m.Upsert, Collation: m.Collation, Hint: m.Hint}
+		if m.ArrayFilters != nil {
+			jm.ArrayFilters = m.ArrayFilters.Filters
+		}
+	case *mongo.UpdateManyModel:
+		jm = &JournalModel{Type: "updateMany", Filter: m.Filter, Document: m.Update, Upsert: m.Upsert != nil && *m.Upsert, Collation: m.Collation, Hint: m.Hint}
+		if m.ArrayFilters != nil {
+			jm.ArrayFilters = m.ArrayFilters.Filters
+		}
+	case *mongo.ReplaceOneModel:
+		jm = &JournalModel{Type: "replaceOne", Filter: m.Filter, Document: m.Replacement, Upsert: m.Upsert != nil && *m.Upsert, Collation: m.Collation, Hint: m.Hint}
+	case *mongo.DeleteOneModel:
+		jm = &JournalModel{Type: "deleteOne", Filter: m.Filter, Collation: m.Collation, Hint: m.Hint}
+	case *mongo.DeleteManyModel:
+		jm = &JournalModel{Type: "deleteMany", Filter: m.Filter, Collation: m.Collation, Hint: m.Hint}
+	default:
+		return nil, fmt.Errorf("unsupported write model %T", model)
+	}
+	// 预先编码, 避免记录后调用方修改原文档
+	bs, err := bson.Marshal(jm)
+	if err != nil {
+		return nil, err
+	}
+	ret := new(JournalModel)
+	return ret, bson.Unmarshal(bs, ret)
+}
+
+func ensureId(doc interface{}) (bson.Raw, error) {
+	bs, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	raw := bson.Raw(bs)
+	if _, err = raw.LookupErr("_id"); err == nil {
+		return raw, nil
+	}
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	d := bson.D{{Key: "_id", Value: primitive.NewObjectID()}}
+	for _, elem := range elems {
+		d = append(d, bson.E{Key: elem.Key(), Value: elem.Value()})
+	}
+	bs, err = bson.Marshal(d)
+	return bs, err
+}
+
+func (m *JournalModel) writeModel() mongo.WriteModel {
+	var collation = m.Collation
+	switch m.Type {
+	case "insertOne":
+		return mongo.NewInsertOneModel().SetDocument(m.Document)
+	case "updateOne":
+		ret := &mongo.UpdateOneModel{Filter: m.Filter, Update: m.Document, Collation: collation, Hint: m.Hint}
+		ret.SetUpsert(m.Upsert)
+		if len(m.ArrayFilters) > 0 {
+			ret.SetArrayFilters(options.ArrayFilters{Filters: m.ArrayFilters})
+		}
+		return ret
+	case "updateMany":
+		ret := &mongo.UpdateManyModel{Filter: m.Filter, Update: m.Document, Collation: collation, Hint: m.Hint}
+		ret.SetUpsert(m.Upsert)
+		if len(m.ArrayFilters) > 0 {
+			ret.SetArrayFilters(options.ArrayFilters{Filters: m.ArrayFilters})
+		}
+		return ret
+	case "replaceOne":
+		ret := &mongo.ReplaceOneModel{Filter: m.Filter, Replacement: m.Document, Collation: collation, Hint: m.Hint}
+		return ret.SetUpsert(m.Upsert)
+	case "deleteOne":
+		return &mongo.DeleteOneModel{Filter: m.Filter, Collation: collation, Hint: m.Hint}
+	case "deleteMany":
+		return &mongo.DeleteManyModel{Filter: m.Filter, Collation: collation, Hint: m.Hint}
+	}
+	return nil
+}
+
+// 按序读取条目, 返回完整条目的总字节数
+func readJournal(path string) (entries []*JournalEntry, size int64, err error) {
+	bs, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return
+	}
+	for len(bs) >= 4 {
+		n := int(binary.LittleEndian.Uint32(bs))
+		if n < 5 || n > len(bs) {
+			break
+		}
+		entry := new(JournalEntry)
+		if bson.Unmarshal(bs[:n], entry) != nil {
+			break
+		}
+		entries = append(entries, entry)
+		size += int64(n)
+		bs = bs[n:]
+	}
+	return
+}
+
+func (jn *journal) entries() ([]*JournalEntry, error) {
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	entries, _, err := readJournal(jn.cfg.Path)
+	return entries, err
+}
+
+func (jn *journal) loop() {
+	defer close(jn.exited)
+	ticker := time.NewTicker(jn.cfg.ReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			jn.mux.Lock()
+			pending := jn.stats.Pending
+			jn.mux.Unlock()
+			if pending > 0 {
+				jn.replay()
+			}
+		case <-jn.done:
+			return
+		}
+	}
+}
+
+// 按序重放全部条目直至日志为空. 网络类错误时停止等待下次重放, 其他错误丢弃该条目
+func (jn *journal) replay() error {
+	jn.replayMux.Lock()
+	defer jn.replayMux.Unlock()
+	for {
+		entries, err := jn.entries()
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		done := 0
+		var rerr error
+		keys := make(map[string]bson.A)
+		for _, entry := range entries {
+			if err := jn.apply(entry); err != nil {
+				if unreachable(err) {
+					rerr = err
+					break
+				}
+				jn.mux.Lock()
+				jn.stats.Dropped++
+				jn.mux.Unlock()
+				if jn.cfg.OnDrop != nil {
+					jn.cfg.OnDrop(entry, err)
+				}
+			} else {
+				jn.mux.Lock()
+				jn.stats.Replayed++
+				jn.mux.Unlock()
+			}
+			keys[entry.Database] = append(keys[entry.Database], entry.Key)
+			done++
+		}
+		if err = jn.truncate(done); err != nil {
+			return err
+		}
+		jn.cleanup(keys)
+		jn.mux.Lock()
+		if rerr != nil {
+			jn.stats.LastError = rerr.Error()
+		}
+		jn.mux.Unlock()
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// 重放单个条目. 先检查幂等键, 写入成功后记录幂等键
+func (jn *journal) apply(entry *JournalEntry) error {
+	not, err := jn.cc.DBFindId(entry.Database, jn.cfg.Markers, entry.Key, nil)
+	if err != nil || !not {
+		return err
+	}
+	models := make([]mongo.WriteModel, 0, len(entry.Models))
+	inserts, guarded := true, false
+	for _, m := range entry.Models {
+		if err = jn.keep(entry, m); err != nil {
+			return err
+		}
+		wm := m.writeModel()
+		if wm == nil {
+			return fmt.Errorf("unsupported journal model type %v", m.Type)
+		}
+		inserts = inserts && m.Type == "insertOne"
+		guarded = guarded || m.Guard
+		models = append(models, wm)
+	}
+	if guarded {
+		txn, err := jn.transactions()
+		if err != nil {
+			return err
+		}
+		if txn {
+			return jn.applyTxn(entry, models)
+		}
+		for i, m := range entry.Models {
+			if m.Guard {
+				if models[i], err = guard(models[i], entry.Key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, err = jn.cc.DBBulkWrite(entry.Database, entry.Collection, models, options.BulkWrite().SetOrdered(entry.Ordered))
+	// 插入的_id在首次执行前已确定, 重复键说明原操作实际已写入
+	if err != nil && !(inserts && onlyDuplicateKeys(err)) {
+		return err
+	}
+	_, err = jn.cc.DBInsertOne(entry.Database, jn.cfg.Markers, jn.marker(entry))
+	if IsDuplicateKey(err) {
+		err = nil
+	}
+	return err
+}
+
+func (jn *journal) marker(entry *JournalEntry) bson.D {
+	return bson.D{{Key: "_id", Value: entry.Key}, {Key: "time", Value: time.Now()}}
+}
+
+// 部署是否支持事务(副本集或mongos), 探测成功后缓存
+func (jn *journal) transactions() (bool, error) {
+	if jn.txn != nil {
+		return *jn.txn, nil
+	}
+	raw, err := jn.cc.Database("admin").RunCommand(nil, bson.D{{Key: "isMaster", Value: 1}}).DecodeBytes()
+	if err != nil {
+		return false, err
+	}
+	setName, _ := raw.Lookup("setName").StringValueOK()
+	msg, _ := raw.Lookup("msg").StringValueOK()
+	wire, _ := raw.Lookup("maxWireVersion").Int32OK()
+	txn := setName != "" && wire >= 7 || msg == "isdbgrid" && wire >= 8
+	jn.txn = &txn
+	return txn, nil
+}
+
+// 在事务中检查幂等键, 写入模型及幂等键, 中断时均不生效
+func (jn *journal) applyTxn(entry *JournalEntry, models []mongo.WriteModel) error {
+	db := jn.cc.Database(entry.Database)
+	// 4.4之前的事务中不能创建集合, 预先创建幂等键集合
+	if !jn.created[entry.Database] {
+		if err := db.RunCommand(nil, bson.D{{Key: "create", Value: jn.cfg.Markers}}).Err(); err != nil && !hasErrorCode(err, []int{48}) { // NamespaceExists
+			return err
+		}
+		if jn.created == nil {
+			jn.created = make(map[string]bool)
+		}
+		jn.created[entry.Database] = true
+	}
+	markers := db.Collection(jn.cfg.Markers, jn.cc.collectionOptions)
+	coll := db.Collection(entry.Collection, jn.cc.collectionOptions)
+	sess, err := jn.cc.StartSession()
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(context.Background())
+	_, err = sess.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		if err := markers.FindOne(sc, bson.D{{Key: "_id", Value: entry.Key}}).Err(); err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if _, err := coll.BulkWrite(sc, models, options.BulkWrite().SetOrdered(entry.Ordered)); err != nil {
+			return nil, err
+		}
+		_, err := markers.InsertOne(sc, jn.marker(entry))
+		return nil, err
+	})
+	return err
+}
+
+// 非幂等更新能否以条目键守卫: 作用于单个_id或多个文档, 非upsert且非管道形式
+func guardable(op *Operation, model mongo.WriteModel) bool {
+	var update interface{}
+	var upsert *bool
+	switch m := model.(type) {
+	case *mongo.UpdateOneModel:
+		if op.Name != Op_UpdateId && !idFilter(m.Filter) {
+			return false // 其他条件下首次已生效时, 重放会作用于另一个匹配的文档
+		}
+		update, upsert = m.Update, m.Upsert
+	case *mongo.UpdateManyModel:
+		update, upsert = m.Update, m.Upsert
+	default:
+		return false
+	}
+	if _, ok := toPipeline(update); ok {
+		return false
+	}
+	return upsert == nil || !*upsert
+}
+
+// 仅更新未带本条目键的文档并写入键, 再次重放时跳过已更新的文档
+func guard(model mongo.WriteModel, key string) (mongo.WriteModel, error) {
+	cond := bson.D{{Key: journalGuard, Value: bson.D{{Key: "$ne", Value: key}}}}
+	switch m := model.(type) {
+	case *mongo.UpdateOneModel:
+		d, err := toD(m.Update)
+		if err != nil {
+			return nil, err
+		}
+		ret := *m
+		ret.Filter = bson.D{{Key: "$and", Value: bson.A{allIfNil(m.Filter), cond}}}
+		if ret.Update, err = addToOperator(d, "$set", journalGuard, key); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case *mongo.UpdateManyModel:
+		d, err := toD(m.Update)
+		if err != nil {
+			return nil, err
+		}
+		ret := *m
+		ret.Filter = bson.D{{Key: "$and", Value: bson.A{allIfNil(m.Filter), cond}}}
+		if ret.Update, err = addToOperator(d, "$set", journalGuard, key); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	}
+	return model, nil
+}
+
+func (jn *journal) keep(entry *JournalEntry, m *JournalModel) error {
+	if m.Keep == "" {
+		return nil
+	}
+	d, err := toD(m.Document)
+	if err != nil {
+		return err
+	}
+	coll := jn.cc.Database(entry.Database).Collection(entry.Collection, jn.cc.collectionOptions)
+	raw, err := coll.FindOne(nil, m.Filter, options.FindOne().SetProjection(bson.D{{Key: m.Keep, Value: 1}})).DecodeBytes()
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	var val interface{} = entry.Time
+	if err == nil {
+		if v, err := raw.LookupErr(m.Keep); err == nil {
+			val = v
+		}
+	}
+	m.Document = setField(d, m.Keep, val)
+	return nil
+}
+
+// 条目移出日志后不再需要幂等键. 删除失败时遗留的幂等键在之后的清理中按时间删除
+func (jn *journal) cleanup(keys map[string]bson.A) {
+	expired := time.Now().Add(-journalMarkerRetention)
+	for db, ids := range keys {
+		jn.cc.DBDeleteMany(db, jn.cfg.Markers, bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}},
+			bson.D{{Key: "time", Value: bson.D{{Key: "$lt", Value: expired}}}},
+		}}})
+	}
+}
+
+func onlyDuplicateKeys(err error) bool {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || len(bwe.WriteErrors) == 0 || bwe.WriteConcernError != nil {
+		return false
+	}
+	for _, we := range bwe.WriteErrors {
+		if !hasErrorCode(mongo.WriteException{WriteErrors: []mongo.WriteError{we.WriteError}}, duplicateKeyCodes) {
+			return false
+		}
+	}
+	return true
+}
+
+// 删除已处理的前n个条目, 重写期间追加的条目保留
+func (jn *journal) truncate(n int) error {
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	if jn.file == nil {
+		return ErrJournalClosed
+	}
+	bs, err := ioutil.ReadFile(jn.cfg.Path)
+	if err != nil {
+		return err
+	}
+	// 按长度前缀跳过前n个条目, 其余原样保留
+	for i := 0; i < n && len(bs) >= 4; i++ {
+		size := int(binary.LittleEndian.Uint32(bs))
+		if size > len(bs) {
+			size = len(bs)
+		}
+		bs = bs[size:]
+	}
+	tmp := jn.cfg.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(bs); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, jn.cfg.Path)
+	}
+	if err != nil {
+		return err
+	}
+	jn.file.Close()
+	if jn.file, err = os.OpenFile(jn.cfg.Path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		jn.file = nil
+		return err
+	}
+	jn.stats.Pending -= n
+	jn.stats.Bytes = int64(len(bs))
+	return nil
+}
+
+// 停止后台重放并关闭文件, 未重放的条目保留在文件中
+func (jn *journal) close() error {
+	select {
+	case <-jn.done:
+	default:
+		close(jn.done)
+	}
+	<-jn.exited
+	jn.replayMux.Lock()
+	defer jn.replayMux.Unlock()
+	jn.mux.Lock()
+	defer jn.mux.Unlock()
+	if jn.file == nil {
+		return nil
+	}
+	err := jn.file.Close()
+	jn.file = nil
+	return err
+}
+
+func GetJournal(val interface{}, ok bool) (*Journal, bool) {
+	switch val := val.(type) {
+	case nil:
+		return nil, true
+	case string:
+		val = strings.TrimSpace(val)
+		if val == "" {
+			return nil, true
+		}
+		return &Journal{Path: val}, true
+	case map[string]interface{}:
+		if conf.ToString(val["Path"]) == "" {
+			return nil, true
+		}
+		return &Journal{
+			Path:           conf.ToString(val["Path"]),
+			MaxBytes:       conf.ToInt64(val["MaxBytes"]),
+			ReplayInterval: conf.ToDuration(val["ReplayInterval"]),
+			Markers:        conf.ToString(val["Markers"]),
+		}, true
+	case map[interface{}]interface{}:
+		ret := new(Journal)
+		for k, v := range val {
+			switch conf.ToString(k) {
+			case "Path":
+				ret.Path = conf.ToString(v)
+			case "MaxBytes":
+				ret.MaxBytes = conf.ToInt64(v)
+			case "ReplayInterval":
+				ret.ReplayInterval = conf.ToDuration(v)
+			case "Markers":
+				ret.Markers = conf.ToString(v)
+			}
+		}
+		if ret.Path == "" {
+			return nil, true
+		}
+		return ret, true
+	default:
+		panic(fmt.Sprintf("invalid value for journal: %v", val))
+	}
+}
@@ -0,0 +1,317 @@
+package mongodb_test
+
+import (
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 可切换断开的TCP代理, 模拟网络分区
+type toggleProxy struct {
+	ln     net.Listener
+	target string
+	mux    sync.Mutex
+	down   bool
+	cut    bool // 请求照常转发, 回复被丢弃并断开, 模拟写入后丢失回复
+	conns  []net.Conn
+}
+
+func newToggleProxy(target string) (*toggleProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &toggleProxy{ln: ln, target: target}
+	go p.serve()
+	return p, nil
+}
+
+func (p *toggleProxy) serve() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		p.mux.Lock()
+		if p.down {
+			p.mux.Unlock()
+			conn.Close()
+			continue
+		}
+		up, err := net.Dial("tcp", p.target)
+		if err != nil {
+			p.mux.Unlock()
+			conn.Close()
+			continue
+		}
+		p.conns = append(p.conns, conn, up)
+		p.mux.Unlock()
+		go func() {
+			io.Copy(up, conn)
+			up.Close()
+		}()
+		go func() {
+			p.reply(conn, up)
+			conn.Close()
+		}()
+	}
+}
+
+func (p *toggleProxy) reply(conn net.Conn, up net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := up.Read(buf)
+		if n > 0 {
+			p.mux.Lock()
+			cut := p.cut
+			p.mux.Unlock()
+			if cut {
+				up.Close()
+				return
+			}
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *toggleProxy) setCut(cut bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.cut = cut
+}
+
+func (p *toggleProxy) setDown(down bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.down = down
+	if down {
+		for _, c := range p.conns {
+			c.Close()
+		}
+		p.conns = nil
+	}
+}
+
+// 经由可切换代理连接测试服务并启用本地写日志, done依次关闭客户端, 代理及服务
+func newJournalClient(t *testing.T) (*mongodb.Client, *toggleProxy, func()) {
+	srv, err := mongodbtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := newToggleProxy(srv.Addr())
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mongodbjournal")
+	if err != nil {
+		proxy.ln.Close()
+		srv.Close()
+		t.Fatal(err)
+	}
+	cnf := srv.Config("test")
+	cnf.Address = []string{proxy.ln.Addr().String()}
+	cnf.ServerSelectionTimeout = 200 * time.Millisecond
+	cnf.HeartbeatInterval = 500 * time.Millisecond
+	cnf.Journal = &mongodb.Journal{Path: filepath.Join(dir, "journal"), ReplayInterval: time.Hour}
+	cc, err := mongodb.NewClient(cnf)
+	if err != nil {
+		proxy.ln.Close()
+		srv.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return cc, proxy, func() {
+		cc.Close()
+		proxy.ln.Close()
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// 恢复连接后重放直至成功
+func replayJournal(t *testing.T, cc *mongodb.Client) {
+	deadline := time.Now().Add(5 * time.Second)
+	err := cc.ReplayJournal()
+	for ; err != nil && time.Now().Before(deadline); err = cc.ReplayJournal() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Journal(t *testing.T) {
+	cc, proxy, done := newJournalClient(t)
+	defer done()
+
+	if _, err := cc.InsertOne("user", bson.M{"_id": 1, "n": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy.setDown(true)
+	_, err := cc.UpdateId("user", 1, bson.M{"$inc": bson.M{"n": 1}})
+	if !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	// 日志非空时后续写入同样记录, 保证顺序
+	if _, err = cc.InsertOne("user", bson.M{"name": "tom"}); !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	if _, err = cc.UpdateOne("user", bson.M{"_id": 2}, bson.M{"$set": bson.M{"n": 2}}, options.Update().SetUpsert(true)); !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	entries, err := cc.JournalEntries()
+	if err != nil || len(entries) != 3 || !entries[2].Models[0].Upsert || cc.JournalStats().Pending != 3 {
+		t.Fatalf("entries: %v %v", entries, err)
+	}
+	if err := cc.ReplayJournal(); err == nil {
+		t.Fatal("expect replay failure while down")
+	}
+
+	proxy.setDown(false)
+	replayJournal(t, cc)
+	if st := cc.JournalStats(); st.Pending != 0 || st.Replayed != 3 || st.Bytes != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+	var ret []bson.M
+	if err := cc.Find("user", mongodb.ALL, &ret, options.Find().SetSort(bson.M{"n": 1})); err != nil || len(ret) != 3 || ret[1]["n"] != int32(1) || ret[2]["n"] != int32(2) {
+		t.Fatalf("replayed: %v %v", ret, err)
+	}
+	// 条目移出日志后删除幂等键
+	if n, _ := cc.Count("_journal", nil); n != 0 {
+		t.Fatalf("markers: %v", n)
+	}
+	if _, err := cc.InsertOne("user", bson.M{"_id": 3}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_JournalLostReply(t *testing.T) {
+	cc, proxy, done := newJournalClient(t)
+	defer done()
+	if err := cc.Ping(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端已写入但回复丢失, 记录的文档须与已发送的_id相同, 重放时不重复插入
+	proxy.setCut(true)
+	if _, err := cc.InsertOne("user", bson.M{"name": "lost"}); !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	proxy.setCut(false)
+	replayJournal(t, cc)
+	if n, err := cc.Count("user", bson.M{"name": "lost"}); err != nil || n != 1 {
+		t.Fatalf("expect a single document, got %v %v", n, err)
+	}
+	if st := cc.JournalStats(); st.Replayed != 1 || st.Dropped != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestClient_JournalKeepCreated(t *testing.T) {
+	cc, proxy, done := newJournalClient(t)
+	defer done()
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cc.Timestamps("user", &mongodb.Timestamps{Now: func() time.Time { return created }})
+	if _, err := cc.InsertOne("user", bson.M{"_id": 1, "name": "tom"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不可达时替换文档无法读取原文档的createdAt, 由重放时读取
+	proxy.setDown(true)
+	if _, err := cc.ReplaceId("user", 1, bson.M{"name": "jack"}); !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	if entries, err := cc.JournalEntries(); err != nil || len(entries) != 1 || entries[0].Models[0].Keep != "createdAt" {
+		t.Fatalf("entries: %v %v", entries, err)
+	}
+	proxy.setDown(false)
+	replayJournal(t, cc)
+
+	var ret bson.M
+	if _, err := cc.FindId("user", 1, &ret); err != nil || ret["name"] != "jack" || !ret["createdAt"].(primitive.DateTime).Time().Equal(created) {
+		t.Fatalf("replayed: %v %v", ret, err)
+	}
+}
+
+func TestClient_JournalGuard(t *testing.T) {
+	cc, proxy, done := newJournalClient(t)
+	defer done()
+	if _, err := cc.InsertOne("user", bson.M{"_id": 1, "n": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy.setDown(true)
+	if _, err := cc.UpdateId("user", 1, bson.M{"$inc": bson.M{"n": 1}}); !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	// 非_id条件的非幂等更新重放时可能作用于另一个文档, 不记录
+	if _, err := cc.UpdateOne("user", bson.M{"n": 0}, bson.M{"$inc": bson.M{"n": 1}}); err == nil || mongodb.IsJournaled(err) {
+		t.Fatalf("expect not journaled, got %v", err)
+	}
+	entries, err := cc.JournalEntries()
+	if err != nil || len(entries) != 1 || !entries[0].Models[0].Guard {
+		t.Fatalf("entries: %v %v", entries, err)
+	}
+	proxy.setDown(false)
+
+	// 模拟已写入而幂等键未记录时中断: 文档已更新并带有条目键
+	coll := cc.Database("test").Collection("user")
+	deadline := time.Now().Add(5 * time.Second)
+	_, err = coll.UpdateOne(nil, bson.M{"_id": 1}, bson.M{"$inc": bson.M{"n": 1}, "$set": bson.M{"_jkey": entries[0].Key}})
+	for ; err != nil && time.Now().Before(deadline); _, err = coll.UpdateOne(nil, bson.M{"_id": 1}, bson.M{"$inc": bson.M{"n": 1}, "$set": bson.M{"_jkey": entries[0].Key}}) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayJournal(t, cc)
+	var ret bson.M
+	if _, err := cc.FindId("user", 1, &ret); err != nil || ret["n"] != int32(1) {
+		t.Fatalf("replayed: %v %v", ret, err)
+	}
+	if st := cc.JournalStats(); st.Pending != 0 || st.Replayed != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestClient_JournalBeforeDelete(t *testing.T) {
+	cc, proxy, done := newJournalClient(t)
+	defer done()
+	cc.Model("item", (*deletingItem)(nil))
+	if _, err := cc.InsertOne("item", bson.M{"_id": 1, "tag": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	deleting = func(it *deletingItem) { calls++ }
+
+	// 不可达时读取失败, 删除记入日志, 不调用钩子
+	proxy.setDown(true)
+	if _, err := cc.DeleteMany("item", bson.M{"tag": "a"}); !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	if _, err := cc.DeleteId("item", 1); !mongodb.IsJournaled(err) {
+		t.Fatalf("expect journaled, got %v", err)
+	}
+	proxy.setDown(false)
+	replayJournal(t, cc)
+	if n, err := cc.Count("item", nil); err != nil || n != 0 || calls != 0 {
+		t.Fatalf("replayed: %v %v %v", n, calls, err)
+	}
+}
@@ -0,0 +1,346 @@
+package mongodb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"hash"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidPageToken = errors.New("mongodb invalid page token")
+
+// 未配置pageTokenSecret时使用进程内随机密钥, 多实例部署或需跨重启使用token时必须配置
+var defaultPageTokenSecret = func() []byte {
+	ret := make([]byte, 32)
+	if _, err := rand.Read(ret); err != nil {
+		panic(err)
+	}
+	return ret
+}()
+
+// 游标分页结果, 数据解码至FindKeyset的ret
+type KeysetPage struct {
+	Next string // 下一页token, 无下一页时为空
+	Prev string // 上一页token, 无上一页时为空
+}
+
+type keysetField struct {
+	key string
+	dir int
+}
+
+// token内容, 以HMAC-SHA256签名防篡改
+type keysetToken struct {
+	Prev   bool            `bson:"p,omitempty"` // 向前翻页
+	Coll   string          `bson:"c"`           // db.cl, 防止token用于其他集合
+	Filter []byte          `bson:"f"`           // 查询条件摘要, 防止token用于不同条件
+	Sort   string          `bson:"s"`           // 排序签名, 防止token用于不同排序
+	Keys   []bson.RawValue `bson:"k"`           // 边界文档的排序键值
+}
+
+// 设置token签名密钥
+func (cc *Client) PageTokenSecret(secret string) *Client {
+	cc.pageTokenSecret = []byte(secret)
+	return cc
+}
+
+func (cc *Client) FindKeyset(cl string, filter interface{}, sort bson.D, token string, size int64, ret interface{}) (*KeysetPage, error) {
+	return cc.DBFindKeyset(cc.DB, cl, filter, sort, token, size, ret)
+}
+
+// 游标(keyset)分页: 按sort排序, 以上一次返回的Next/Prev token定位, token为空时返回首页.
+// sort末尾自动补充_id(方向同最后一个字段)以处理相同键值, 排序字段须存在且类型一致
+func (cc *Client) DBFindKeyset(db string, cl string, filter interface{}, sort bson.D, token string, size int64, ret interface{}) (result *KeysetPage, err error) {
+	if filter == nil {
+		filter = ALL
+	}
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindKeyset, Filter: filter}
+	fields, err := keysetFields(sort)
+	if err != nil {
+		return nil, cc.wrapError(op, err)
+	}
+	digest, err := filterDigest(filter)
+	if err != nil {
+		return nil, cc.wrapError(op, err)
+	}
+	scope := &keysetToken{Coll: db + "." + cl, Filter: digest, Sort: keysetSignature(fields)}
+	max := cc.maxPageSize
+	if max <= 0 {
+		max = DefaultMaxPageSize
+	}
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+	if size > max {
+		size = max
+	}
+
+	var from *keysetToken
+	if token != "" {
+		if from, err = cc.decodeKeysetToken(token, scope, len(fields)); err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+		op.Filter = bson.D{{Key: "$and", Value: bson.A{filter, keysetFilter(fields, from.Keys, from.Prev)}}}
+	}
+	backward := from != nil && from.Prev
+
+	opt := options.Find().SetSort(keysetSort(fields, backward)).SetLimit(size + 1)
+	var raws []bson.Raw
+	if err = cc.exec(op, func(coll *mongo.Collection) error {
+		cur, err := coll.Find(nil, op.Filter, opt)
+		if err == nil {
+			err = cur.All(nil, &raws)
+		}
+		return err
+	}); err != nil {
+		return
+	}
+
+	// 多取的一条仅用于判断翻页方向上是否还有数据
+	more := int64(len(raws)) > size
+	if more {
+		raws = raws[:size]
+	}
+	if backward {
+		for i, j := 0, len(raws)-1; i < j; i, j = i+1, j-1 {
+			raws[i], raws[j] = raws[j], raws[i]
+		}
+	}
+	if err = decodeRaws(raws, ret); err == nil {
+		err = afterFind(ret)
+	}
+	if err != nil {
+		return nil, cc.wrapError(op, err)
+	}
+
+	result = new(KeysetPage)
+	if len(raws) == 0 {
+		return
+	}
+	if hasNext := more || backward; hasNext {
+		if result.Next, err = cc.encodeKeysetToken(scope.at(false, keysetKeys(fields, raws[len(raws)-1]))); err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+	}
+	if hasPrev := (backward && more) || (!backward && from != nil); hasPrev {
+		if result.Prev, err = cc.encodeKeysetToken(scope.at(true, keysetKeys(fields, raws[0]))); err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+	}
+	return
+}
+
+// 解析排序, 方向为正数表示升序, 负数表示降序. _id之后的字段无意义, 直接忽略
+func keysetFields(sort bson.D) ([]keysetField, error) {
+	if len(sort) == 0 {
+		return []keysetField{{key: "_id", dir: 1}}, nil
+	}
+	ret := make([]keysetField, 0, len(sort)+1)
+	for _, e := range sort {
+		var dir int
+		switch v := e.Value.(type) {
+		case int:
+			dir = v
+		case int32:
+			dir = int(v)
+		case int64:
+			dir = int(v)
+		case float64:
+			dir = int(v)
+		}
+		if dir == 0 {
+			return nil, fmt.Errorf("invalid sort direction for %v: %v", e.Key, e.Value)
+		}
+		if dir > 0 {
+			dir = 1
+		} else {
+			dir = -1
+		}
+		ret = append(ret, keysetField{key: e.Key, dir: dir})
+		if e.Key == "_id" {
+			return ret, nil
+		}
+	}
+	return append(ret, keysetField{key: "_id", dir: ret[len(ret)-1].dir}), nil
+}
+
+// 以scope的集合, 条件及排序生成指定边界的token
+func (scope *keysetToken) at(prev bool, keys []bson.RawValue) *keysetToken {
+	ret := *scope
+	ret.Prev, ret.Keys = prev, keys
+	return &ret
+}
+
+// 查询条件的摘要, 文档按键排序后计算, 消除bson.M的遍历顺序差异
+func filterDigest(filter interface{}) ([]byte, error) {
+	raw, err := bson.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if err = digestValue(h, bson.RawValue{Type: bsontype.EmbeddedDocument, Value: raw}); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil)[:16], nil
+}
+
+func digestValue(h hash.Hash, val bson.RawValue) error {
+	h.Write([]byte{byte(val.Type)})
+	switch val.Type {
+	case bsontype.EmbeddedDocument:
+		elems, err := val.Document().Elements()
+		if err != nil {
+			return err
+		}
+		sort.Slice(elems, func(i, j int) bool {
+			return elems[i].Key() < elems[j].Key()
+		})
+		fmt.Fprintf(h, "%d:", len(elems))
+		for _, elem := range elems {
+			fmt.Fprintf(h, "%q", elem.Key())
+			if err = digestValue(h, elem.Value()); err != nil {
+				return err
+			}
+		}
+	case bsontype.Array:
+		vals, err := val.Array().Values()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%d:", len(vals))
+		for _, v := range vals {
+			if err = digestValue(h, v); err != nil {
+				return err
+			}
+		}
+	default:
+		h.Write(val.Value)
+	}
+	return nil
+}
+
+func keysetSignature(fields []keysetField) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = fmt.Sprintf("%v:%v", f.key, f.dir)
+	}
+	return strings.Join(parts, ",")
+}
+
+func keysetSort(fields []keysetField, backward bool) bson.D {
+	ret := make(bson.D, len(fields))
+	for i, f := range fields {
+		dir := f.dir
+		if backward {
+			dir = -dir
+		}
+		ret[i] = bson.E{Key: f.key, Value: dir}
+	}
+	return ret
+}
+
+// 位于边界键值之后(backward时之前)的条件: (k1 > v1) or (k1 = v1 and k2 > v2) or ...
+func keysetFilter(fields []keysetField, keys []bson.RawValue, backward bool) bson.D {
+	or := make(bson.A, 0, len(fields))
+	for i, f := range fields {
+		cond := make(bson.D, 0, i+1)
+		for j := 0; j < i; j++ {
+			cond = append(cond, bson.E{Key: fields[j].key, Value: keys[j]})
+		}
+		op := "$gt"
+		if (f.dir < 0) != backward {
+			op = "$lt"
+		}
+		cond = append(cond, bson.E{Key: f.key, Value: bson.D{{Key: op, Value: keys[i]}}})
+		or = append(or, cond)
+	}
+	return bson.D{{Key: "$or", Value: or}}
+}
+
+// 提取排序键值, 缺失的字段视为null
+func keysetKeys(fields []keysetField, raw bson.Raw) []bson.RawValue {
+	ret := make([]bson.RawValue, len(fields))
+	for i, f := range fields {
+		val, err := raw.LookupErr(strings.Split(f.key, ".")...)
+		if err != nil {
+			val = bson.RawValue{Type: bsontype.Null}
+		}
+		ret[i] = val
+	}
+	return ret
+}
+
+func (cc *Client) tokenSecret() []byte {
+	if len(cc.pageTokenSecret) > 0 {
+		return cc.pageTokenSecret
+	}
+	return defaultPageTokenSecret
+}
+
+// token格式: base64url(bson).base64url(hmac)
+func (cc *Client) encodeKeysetToken(t *keysetToken) (string, error) {
+	bs, err := bson.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, cc.tokenSecret())
+	mac.Write(bs)
+	return base64.RawURLEncoding.EncodeToString(bs) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// 校验签名, 并要求集合, 条件及排序与scope一致
+func (cc *Client) decodeKeysetToken(token string, scope *keysetToken, keys int) (*keysetToken, error) {
+	idx := strings.IndexByte(token, '.')
+	if idx < 0 {
+		return nil, ErrInvalidPageToken
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(token[:idx])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(token[idx+1:])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	mac := hmac.New(sha256.New, cc.tokenSecret())
+	mac.Write(bs)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidPageToken
+	}
+	ret := new(keysetToken)
+	if err = bson.Unmarshal(bs, ret); err != nil || ret.Coll != scope.Coll || !bytes.Equal(ret.Filter, scope.Filter) || ret.Sort != scope.Sort || len(ret.Keys) != keys {
+		return nil, ErrInvalidPageToken
+	}
+	return ret, nil
+}
+
+// 逐个解码至ret指向的切片, 语义同Cursor.All
+func decodeRaws(raws []bson.Raw, ret interface{}) error {
+	rv := reflect.ValueOf(ret)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("results argument must be a pointer to a slice")
+	}
+	sv := rv.Elem()
+	et := sv.Type().Elem()
+	out := reflect.MakeSlice(sv.Type(), 0, len(raws))
+	for _, raw := range raws {
+		ev := reflect.New(et)
+		if err := bson.Unmarshal(raw, ev.Interface()); err != nil {
+			return err
+		}
+		out = reflect.Append(out, ev.Elem())
+	}
+	sv.Set(out)
+	return nil
+}
@@ -0,0 +1,125 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type keysetItem struct {
+	Id        primitive.ObjectID `bson:"_id"`
+	Name      string             `bson:"name"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+func TestClient_FindKeyset(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cc := mdb.PageTokenSecret("secret")
+	defer cc.Close()
+
+	// 每3条createdAt相同, 依赖_id区分
+	base := time.Date(2020, 8, 18, 0, 0, 0, 0, time.UTC)
+	docs := make([]interface{}, 0, 25)
+	for i := 0; i < 25; i++ {
+		docs = append(docs, &keysetItem{Id: mongodbtest.NamedObjectId(string(rune('a' + i))), Name: string(rune('a' + i)), CreatedAt: base.Add(time.Duration(i/3) * time.Hour)})
+	}
+	if _, err := cc.InsertMany("item", docs); err != nil {
+		t.Fatal(err)
+	}
+	sort := bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}}
+	var expect []*keysetItem
+	if err := cc.Find("item", mongodb.ALL, &expect, options.Find().SetSort(sort)); err != nil {
+		t.Fatal(err)
+	}
+
+	var pages []string
+	var all []*keysetItem
+	token := ""
+	for {
+		var items []*keysetItem
+		page, err := cc.FindKeyset("item", nil, sort, token, 6, &items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, items...)
+		pages = append(pages, token)
+		if page.Next == "" {
+			break
+		}
+		token = page.Next
+		if len(pages) > 10 {
+			t.Fatal("too many pages")
+		}
+	}
+	if len(pages) != 5 || !sameItems(all, expect) {
+		t.Fatalf("unexpected: %v pages %v", len(pages), all)
+	}
+
+	// 从第3页向前翻页回到首页
+	var items []*keysetItem
+	page, err := cc.FindKeyset("item", nil, sort, pages[2], 6, &items)
+	if err != nil || page.Prev == "" || !sameItems(items, expect[12:18]) {
+		t.Fatalf("unexpected: %v %v", items, err)
+	}
+	page, err = cc.FindKeyset("item", nil, sort, page.Prev, 6, &items)
+	if err != nil || page.Prev == "" || page.Next == "" || !sameItems(items, expect[6:12]) {
+		t.Fatalf("unexpected: %+v %v %v", page, items, err)
+	}
+	page, err = cc.FindKeyset("item", nil, sort, page.Prev, 6, &items)
+	if err != nil || page.Prev != "" || page.Next == "" || !sameItems(items, expect[:6]) {
+		t.Fatalf("unexpected: %+v %v %v", page, items, err)
+	}
+
+	// 键顺序不同的相同条件, token仍然有效
+	filter := bson.M{"name": bson.M{"$ne": ""}, "createdAt": bson.M{"$gte": base}}
+	if page, err = cc.FindKeyset("item", filter, sort, "", 6, &items); err != nil || page.Next == "" {
+		t.Fatalf("unexpected: %+v %v", page, err)
+	}
+	if _, err = cc.FindKeyset("item", bson.D{{Key: "createdAt", Value: bson.M{"$gte": base}}, {Key: "name", Value: bson.M{"$ne": ""}}}, sort, page.Next, 6, &items); err != nil {
+		t.Fatal(err)
+	}
+
+	// 篡改, 更换集合, 条件, 排序或密钥均被拒绝
+	tampered := []byte(pages[1])
+	tampered[3] ^= 1
+	for _, c := range []struct {
+		cc     *mongodb.Client
+		cl     string
+		filter interface{}
+		token  string
+		sort   bson.D
+	}{
+		{cc, "item", nil, string(tampered), sort},
+		{cc, "item", nil, pages[1], bson.D{{Key: "createdAt", Value: 1}}},
+		{cc, "item", nil, "bad", sort},
+		{cc, "other", nil, pages[1], sort},
+		{cc, "item", bson.M{"name": "a"}, pages[1], sort},
+		{cc.PageTokenSecret("other"), "item", nil, pages[1], sort},
+	} {
+		if _, err := c.cc.FindKeyset(c.cl, c.filter, c.sort, c.token, 6, &items); !errors.Is(err, mongodb.ErrInvalidPageToken) {
+			t.Fatalf("expect invalid token but %v", err)
+		}
+	}
+}
+
+func sameItems(a, b []*keysetItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !reflect.DeepEqual(a[i].Id, b[i].Id) {
+			return false
+		}
+	}
+	return true
+}
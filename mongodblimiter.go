@@ -0,0 +1,238 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/obase/conf"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Priority_low    = -1 // 后台批处理, 队列紧张时最先被丢弃
+	Priority_normal = 0  // 默认
+	Priority_high   = 1  // 面向用户的关键读写, 队列满时可挤占低优先级等待者
+
+	defaultLimiterQueueTimeout = time.Second
+)
+
+var (
+	ErrQueueTimeout = errors.New("mongodb operation queue timeout") // 排队超时
+	ErrOverloaded   = errors.New("mongodb operation overloaded")    // 队列已满或被高优先级挤占
+)
+
+// 并发限制配置. 超过MaxInFlight的操作进入等待队列, 释放时按优先级从高到低唤醒
+type Limiter struct {
+	PerCollection    bool          `json:"PerCollection" yaml:"PerCollection"`       // 是否按集合独立限制, 默认整个客户端共用
+	MaxInFlight      int           `json:"MaxInFlight" yaml:"MaxInFlight"`           // 最大并发操作数, 小于等于0表示不限制
+	MaxQueue         int           `json:"MaxQueue" yaml:"MaxQueue"`                 // 等待队列长度, 默认等于MaxInFlight
+	QueueTimeout     time.Duration `json:"QueueTimeout" yaml:"QueueTimeout"`         // 排队超时, 默认1秒
+	LowPriorityQueue int           `json:"LowPriorityQueue" yaml:"LowPriorityQueue"` // 低优先级最多排队数, 默认MaxQueue的1/4
+}
+
+func (cc *Client) Limiter(l *Limiter) *Client {
+	cc.limiter = l
+	cc.limits = new(sync.Map)
+	return cc
+}
+
+// 返回指定优先级的客户端句柄, 与原客户端共用连接, 熔断与限流状态
+func (cc *Client) WithPriority(priority int) *Client {
+	ret := cc.clone()
+	ret.priority = priority
+	return ret
+}
+
+func (cc *Client) limit(op *Operation, fn func() error) error {
+	if cc.limiter == nil || cc.limiter.MaxInFlight <= 0 {
+		return fn()
+	}
+	name := ""
+	if cc.limiter.PerCollection {
+		name = op.Database + "." + op.Collection
+	}
+	v, ok := cc.limits.Load(name)
+	if !ok {
+		v, _ = cc.limits.LoadOrStore(name, newSemaphore(cc.limiter))
+	}
+	sem := v.(*semaphore)
+	if err := sem.acquire(cc.priority); err != nil {
+		return err
+	}
+	defer sem.release()
+	return fn()
+}
+
+type waiter struct {
+	priority int
+	ch       chan error // nil表示获得执行权
+}
+
+// 带优先级等待队列的信号量
+type semaphore struct {
+	sync.Mutex
+	max      int
+	maxQueue int
+	maxLow   int
+	timeout  time.Duration
+	inflight int
+	waiters  []*waiter
+}
+
+func newSemaphore(l *Limiter) *semaphore {
+	s := &semaphore{
+		max:      l.MaxInFlight,
+		maxQueue: l.MaxQueue,
+		maxLow:   l.LowPriorityQueue,
+		timeout:  l.QueueTimeout,
+	}
+	if s.maxQueue <= 0 {
+		s.maxQueue = s.max
+	}
+	if s.maxLow <= 0 {
+		if s.maxLow = s.maxQueue / 4; s.maxLow == 0 {
+			s.maxLow = 1
+		}
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultLimiterQueueTimeout
+	}
+	return s
+}
+
+func (s *semaphore) acquire(priority int) error {
+	s.Lock()
+	if s.inflight < s.max && len(s.waiters) == 0 {
+		s.inflight++
+		s.Unlock()
+		return nil
+	}
+	if priority < Priority_normal && s.count(priority) >= s.maxLow {
+		s.Unlock()
+		return ErrOverloaded
+	}
+	if len(s.waiters) >= s.maxQueue && !s.shed(priority) {
+		s.Unlock()
+		return ErrOverloaded
+	}
+	w := &waiter{priority: priority, ch: make(chan error, 1)}
+	s.waiters = append(s.waiters, w)
+	s.Unlock()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-w.ch:
+		return err
+	case <-timer.C:
+		s.Lock()
+		removed := s.remove(w)
+		s.Unlock()
+		if removed {
+			return ErrQueueTimeout
+		}
+		return <-w.ch // 超时同时已被唤醒或挤占
+	}
+}
+
+func (s *semaphore) release() {
+	s.Lock()
+	defer s.Unlock()
+	if w := s.pop(); w != nil {
+		w.ch <- nil // 直接转交执行权, inflight不变
+		return
+	}
+	s.inflight--
+}
+
+// 需持锁调用: 指定优先级的排队数
+func (s *semaphore) count(priority int) (n int) {
+	for _, w := range s.waiters {
+		if w.priority == priority {
+			n++
+		}
+	}
+	return
+}
+
+// 需持锁调用: 丢弃最后进入的更低优先级等待者
+func (s *semaphore) shed(priority int) bool {
+	for i := len(s.waiters) - 1; i >= 0; i-- {
+		if w := s.waiters[i]; w.priority < priority {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			w.ch <- ErrOverloaded
+			return true
+		}
+	}
+	return false
+}
+
+// 需持锁调用: 取出优先级最高且最早进入的等待者
+func (s *semaphore) pop() *waiter {
+	idx := -1
+	for i, w := range s.waiters {
+		if idx < 0 || w.priority > s.waiters[idx].priority {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil
+	}
+	w := s.waiters[idx]
+	s.waiters = append(s.waiters[:idx], s.waiters[idx+1:]...)
+	return w
+}
+
+// 需持锁调用
+func (s *semaphore) remove(w *waiter) bool {
+	for i, v := range s.waiters {
+		if v == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func GetLimiter(val interface{}, ok bool) (*Limiter, bool) {
+	switch val := val.(type) {
+	case nil:
+		return nil, true
+	case string:
+		val = strings.TrimSpace(val)
+		if val == "" {
+			return nil, true
+		}
+		return &Limiter{MaxInFlight: conf.ToInt(val)}, true
+	case int:
+		return &Limiter{MaxInFlight: val}, true
+	case map[string]interface{}:
+		return &Limiter{
+			PerCollection:    conf.ToBool(val["PerCollection"]),
+			MaxInFlight:      conf.ToInt(val["MaxInFlight"]),
+			MaxQueue:         conf.ToInt(val["MaxQueue"]),
+			QueueTimeout:     conf.ToDuration(val["QueueTimeout"]),
+			LowPriorityQueue: conf.ToInt(val["LowPriorityQueue"]),
+		}, true
+	case map[interface{}]interface{}:
+		ret := new(Limiter)
+		for k, v := range val {
+			switch conf.ToString(k) {
+			case "PerCollection":
+				ret.PerCollection = conf.ToBool(v)
+			case "MaxInFlight":
+				ret.MaxInFlight = conf.ToInt(v)
+			case "MaxQueue":
+				ret.MaxQueue = conf.ToInt(v)
+			case "QueueTimeout":
+				ret.QueueTimeout = conf.ToDuration(v)
+			case "LowPriorityQueue":
+				ret.LowPriorityQueue = conf.ToInt(v)
+			}
+		}
+		return ret, true
+	default:
+		panic(fmt.Sprintf("invalid value for limiter: %v", val))
+	}
+}
@@ -0,0 +1,33 @@
+package mongodb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := newSemaphore(&Limiter{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond})
+	if err := s.acquire(Priority_normal); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.acquire(Priority_normal); err != ErrQueueTimeout {
+		t.Fatalf("expect ErrQueueTimeout, got %v", err)
+	}
+
+	low := make(chan error, 1)
+	go func() { low <- s.acquire(Priority_low) }()
+	time.Sleep(10 * time.Millisecond)
+	high := make(chan error, 1)
+	go func() { high <- s.acquire(Priority_high) }()
+	if err := <-low; err != ErrOverloaded {
+		t.Fatalf("low priority waiter should be shed, got %v", err)
+	}
+	s.release()
+	if err := <-high; err != nil {
+		t.Fatalf("high priority waiter should acquire, got %v", err)
+	}
+	s.release()
+	if s.inflight != 0 {
+		t.Fatalf("expect no inflight, got %v", s.inflight)
+	}
+}
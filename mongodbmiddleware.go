@@ -0,0 +1,73 @@
+package mongodb
+
+import "sync"
+
+// 中间件, 包裹每次helper操作(含重试等内部环节), 可读取或修改op(如追加租户条件)后调用next执行, 不调用next即中止操作.
+// 返回的错误经*Error包装
+type Middleware func(op *Operation, next func() error) error
+
+var (
+	middlewareMux sync.RWMutex
+	middlewares   []*globalMiddleware // 按注册顺序, 以指针区分每次注册以便注销
+)
+
+type globalMiddleware struct {
+	fn Middleware
+}
+
+// 注册全局中间件, 作用于所有客户端, 先于客户端中间件执行. 返回的函数注销本次注册的中间件, 可重复调用
+func Use(mws ...Middleware) func() {
+	entries := make([]*globalMiddleware, len(mws))
+	for i, mw := range mws {
+		entries[i] = &globalMiddleware{fn: mw}
+	}
+	middlewareMux.Lock()
+	defer middlewareMux.Unlock()
+	middlewares = append(middlewares[:len(middlewares):len(middlewares)], entries...)
+	return func() {
+		middlewareMux.Lock()
+		defer middlewareMux.Unlock()
+		ret := make([]*globalMiddleware, 0, len(middlewares))
+	next:
+		for _, m := range middlewares {
+			for _, e := range entries {
+				if m == e {
+					continue next
+				}
+			}
+			ret = append(ret, m)
+		}
+		middlewares = ret
+	}
+}
+
+// 注册客户端中间件, 按注册顺序由外至内执行. 需在使用客户端前完成注册
+func (cc *Client) Use(mws ...Middleware) *Client {
+	cc.middlewares = append(cc.middlewares[:len(cc.middlewares):len(cc.middlewares)], mws...)
+	return cc
+}
+
+// 依次经过全局及客户端中间件后执行fn
+func (cc *Client) intercept(op *Operation, fn func() error) error {
+	middlewareMux.RLock()
+	global := middlewares
+	middlewareMux.RUnlock()
+	if len(global) == 0 && len(cc.middlewares) == 0 {
+		return fn()
+	}
+	chain := make([]Middleware, 0, len(global)+len(cc.middlewares))
+	for _, m := range global {
+		chain = append(chain, m.fn)
+	}
+	chain = append(chain, cc.middlewares...)
+	var next func(i int) error
+	next = func(i int) error {
+		if i == len(chain) {
+			return fn()
+		}
+		return chain[i](op, func() error {
+			return next(i + 1)
+		})
+	}
+	return next(0)
+}
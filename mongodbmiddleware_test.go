@@ -0,0 +1,93 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+func TestClient_Middleware(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	var trace []string
+	unuse := mongodb.Use(func(op *mongodb.Operation, next func() error) error {
+		trace = append(trace, "global:"+op.Name)
+		return next()
+	})
+	defer unuse()
+	errDenied := errors.New("denied")
+	var findOpts []*options.FindOptions
+	cc := mdb.Use(
+		func(op *mongodb.Operation, next func() error) error {
+			trace = append(trace, "client:"+op.Name)
+			if op.Name == mongodb.Op_DeleteMany {
+				return errDenied
+			}
+			if opts, ok := op.Options.([]*options.FindOptions); ok {
+				findOpts = opts
+			}
+			return next()
+		},
+		// 租户条件
+		func(op *mongodb.Operation, next func() error) error {
+			switch op.Name {
+			case mongodb.Op_Find, mongodb.Op_Count:
+				op.Filter = bson.M{"$and": bson.A{op.Filter, bson.M{"tenant": "a"}}}
+			case mongodb.Op_FindOne:
+				// 替换选项, 执行时读取
+				op.Options = []*options.FindOneOptions{options.FindOne().SetProjection(bson.M{"tenant": 0})}
+			}
+			return next()
+		},
+	)
+	defer cc.Close()
+
+	if _, err := cc.InsertMany("user", []interface{}{bson.M{"_id": 1, "tenant": "a"}, bson.M{"_id": 2, "tenant": "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace) != 2 || trace[0] != "global:InsertMany" || trace[1] != "client:InsertMany" {
+		t.Fatalf("trace: %v", trace)
+	}
+	var ret []bson.M
+	if err := cc.Find("user", mongodb.ALL, &ret, options.Find().SetLimit(10)); err != nil || len(ret) != 1 || ret[0]["_id"] != int32(1) {
+		t.Fatalf("find: %v %v", ret, err)
+	}
+	if len(findOpts) != 1 || *findOpts[0].Limit != 10 {
+		t.Fatalf("options: %v", findOpts)
+	}
+	if n, err := cc.Count("user", mongodb.ALL); err != nil || n != 1 {
+		t.Fatalf("count: %v %v", n, err)
+	}
+	_, err = cc.DeleteMany("user", mongodb.ALL)
+	var me *mongodb.Error
+	if !errors.Is(err, errDenied) || !errors.As(err, &me) || me.Op != mongodb.Op_DeleteMany {
+		t.Fatalf("expect denied, got %v", err)
+	}
+	// 派生句柄沿用中间件
+	if n, err := cc.WithDeleted().Count("user", mongodb.ALL); err != nil || n != 1 {
+		t.Fatalf("derived: %v %v", n, err)
+	}
+	var one bson.M
+	if _, err := cc.FindOne("user", bson.M{"_id": 2}, &one); err != nil || one["_id"] != int32(2) || one["tenant"] != nil {
+		t.Fatalf("find one: %v %v", one, err)
+	}
+	// 空models同样经过中间件
+	trace = nil
+	if _, err := cc.BulkWrite("user", nil); err != nil || len(trace) != 2 || trace[1] != "client:BulkWrite" {
+		t.Fatalf("empty bulk write: %v %v", trace, err)
+	}
+
+	// 注销后全局中间件不再执行
+	unuse()
+	trace = nil
+	if _, err := cc.Count("user", mongodb.ALL); err != nil || len(trace) != 1 || trace[0] != "client:Count" {
+		t.Fatalf("unused: %v %v", trace, err)
+	}
+}
@@ -0,0 +1,196 @@
+package mongodb
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+)
+
+const (
+	DefaultPageSize    = 20   // size<=0时的每页条数
+	DefaultMaxPageSize = 1000 // 默认每页最大条数
+)
+
+// $facet结果超过16M(BSONObjectTooLarge)或服务端不支持$facet(3.4以下)时改用Count+Find
+var pageFallbackCodes = map[int32]bool{
+	10334:   true,
+	16389:   true,
+	4031700: true,
+	40324:   true,
+}
+
+// 分页结果, 数据解码至FindPage的ret
+type Page struct {
+	Page    int64 // 当前页, 从1开始
+	Size    int64 // 每页条数, 已按MaxPageSize截断
+	Total   int64 // 总条数, NoCount时为-1
+	Pages   int64 // 总页数, NoCount时为-1
+	HasNext bool  // 是否有下一页
+}
+
+// 分页选项
+type PageOptions struct {
+	NoCount    bool        // 不统计总数, 以多取一条判断是否有下一页, 用于大集合
+	Projection interface{} // 投影
+}
+
+type pageFacet struct {
+	Items bson.RawValue `bson:"items"`
+	Total []struct {
+		N int64 `bson:"n"`
+	} `bson:"total"`
+}
+
+// 设置每页最大条数, 超出时截断
+func (cc *Client) MaxPageSize(size int64) *Client {
+	cc.maxPageSize = size
+	return cc
+}
+
+func (cc *Client) FindPage(cl string, filter interface{}, page int64, size int64, sort interface{}, ret interface{}, opts ...*PageOptions) (*Page, error) {
+	return cc.DBFindPage(cc.DB, cl, filter, page, size, sort, ret, opts...)
+}
+
+// 分页查询, page从1开始, sort为空时不排序(结果顺序不确定, 建议至少按_id排序).
+// 统计总数时以单次$facet聚合同时返回数据与总数
+func (cc *Client) DBFindPage(db string, cl string, filter interface{}, page int64, size int64, sort interface{}, ret interface{}, opts ...*PageOptions) (result *Page, err error) {
+	if filter == nil {
+		filter = ALL
+	}
+	opt := new(PageOptions)
+	for _, o := range opts {
+		if o != nil {
+			if o.NoCount {
+				opt.NoCount = true
+			}
+			if o.Projection != nil {
+				opt.Projection = o.Projection
+			}
+		}
+	}
+	max := cc.maxPageSize
+	if max <= 0 {
+		max = DefaultMaxPageSize
+	}
+	result = &Page{Page: page, Size: size, Total: -1, Pages: -1}
+	if result.Page < 1 {
+		result.Page = 1
+	}
+	if result.Size <= 0 {
+		result.Size = DefaultPageSize
+	}
+	if result.Size > max {
+		result.Size = max
+	}
+	skip := (result.Page - 1) * result.Size
+
+	op := &Operation{Database: db, Collection: cl, Name: Op_FindPage, Filter: filter, Options: opts}
+	err = cc.exec(op, func(coll *mongo.Collection) error {
+		if opt.NoCount {
+			return findPageNoCount(coll, op.Filter, skip, sort, opt.Projection, ret, result)
+		}
+		err := findPageFacet(coll, op.Filter, skip, sort, opt.Projection, ret, result)
+		if ce, ok := err.(mongo.CommandError); ok && pageFallbackCodes[ce.Code] {
+			err = findPageCount(coll, op.Filter, skip, sort, opt.Projection, ret, result)
+		}
+		return err
+	})
+	if err == nil {
+		err = cc.afterFind(op, ret)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func findPageFacet(coll *mongo.Collection, filter interface{}, skip int64, sort interface{}, projection interface{}, ret interface{}, result *Page) error {
+	items := bson.A{}
+	if skip > 0 {
+		items = append(items, bson.M{"$skip": skip})
+	}
+	items = append(items, bson.M{"$limit": result.Size})
+	if projection != nil {
+		items = append(items, bson.M{"$project": projection})
+	}
+	// $sort须位于$facet之前才能使用索引, $facet内各分支无法利用索引
+	pipeline := bson.A{bson.M{"$match": filter}}
+	if sort != nil {
+		pipeline = append(pipeline, bson.M{"$sort": sort})
+	}
+	pipeline = append(pipeline, bson.M{"$facet": bson.D{
+		{Key: "items", Value: items},
+		{Key: "total", Value: bson.A{bson.M{"$count": "n"}}},
+	}})
+	cur, err := coll.Aggregate(nil, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(nil)
+	if !cur.Next(nil) {
+		if err = cur.Err(); err == nil {
+			err = errors.New("no result of $facet")
+		}
+		return err
+	}
+	var facet pageFacet
+	if err = cur.Decode(&facet); err != nil {
+		return err
+	}
+	if err = facet.Items.Unmarshal(ret); err != nil {
+		return err
+	}
+	var total int64
+	if len(facet.Total) > 0 {
+		total = facet.Total[0].N
+	}
+	result.setTotal(total)
+	return nil
+}
+
+func findPageCount(coll *mongo.Collection, filter interface{}, skip int64, sort interface{}, projection interface{}, ret interface{}, result *Page) error {
+	total, err := coll.CountDocuments(nil, filter)
+	if err != nil {
+		return err
+	}
+	if err = findPageItems(coll, filter, skip, result.Size, sort, projection, ret); err != nil {
+		return err
+	}
+	result.setTotal(total)
+	return nil
+}
+
+func findPageNoCount(coll *mongo.Collection, filter interface{}, skip int64, sort interface{}, projection interface{}, ret interface{}, result *Page) error {
+	if err := findPageItems(coll, filter, skip, result.Size+1, sort, projection, ret); err != nil {
+		return err
+	}
+	// 多取的一条仅用于判断是否有下一页
+	if rv := reflect.ValueOf(ret).Elem(); int64(rv.Len()) > result.Size {
+		rv.Set(rv.Slice(0, int(result.Size)))
+		result.HasNext = true
+	}
+	return nil
+}
+
+func findPageItems(coll *mongo.Collection, filter interface{}, skip int64, limit int64, sort interface{}, projection interface{}, ret interface{}) error {
+	opt := options.Find().SetSkip(skip).SetLimit(limit)
+	if sort != nil {
+		opt.SetSort(sort)
+	}
+	if projection != nil {
+		opt.SetProjection(projection)
+	}
+	cur, err := coll.Find(nil, filter, opt)
+	if err != nil {
+		return err
+	}
+	return cur.All(nil, ret)
+}
+
+func (p *Page) setTotal(total int64) {
+	p.Total = total
+	p.Pages = (total + p.Size - 1) / p.Size
+	p.HasNext = p.Page < p.Pages
+}
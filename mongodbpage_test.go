@@ -0,0 +1,92 @@
+package mongodb_test
+
+import (
+	"fmt"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+type pageItem struct {
+	Id   string `bson:"_id"`
+	Name string `bson:"name"`
+	Age  int    `bson:"age"`
+}
+
+func TestClient_FindPage(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cc := mdb.MaxPageSize(10)
+	defer cc.Close()
+
+	docs := make([]interface{}, 0, 25)
+	for i := 0; i < 25; i++ {
+		docs = append(docs, &pageItem{Id: fmt.Sprintf("p%02d", i), Name: fmt.Sprintf("name%v", i), Age: i})
+	}
+	if _, err := cc.InsertMany("item", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	var items []*pageItem
+	page, err := cc.FindPage("item", bson.M{"age": bson.M{"$gte": 3}}, 2, 5, bson.D{{Key: "age", Value: -1}}, &items)
+	if err != nil || page.Total != 22 || page.Pages != 5 || !page.HasNext || len(items) != 5 || items[0].Age != 19 {
+		t.Fatalf("unexpected: %+v %v %v", page, items, err)
+	}
+
+	// 超过最大条数时截断, 末页无下一页
+	page, err = cc.FindPage("item", nil, 3, 100, bson.M{"_id": 1}, &items, &mongodb.PageOptions{Projection: bson.M{"name": 0}})
+	if err != nil || page.Size != 10 || page.Pages != 3 || page.HasNext || len(items) != 5 || items[0].Id != "p20" || items[0].Name != "" {
+		t.Fatalf("unexpected: %+v %v %v", page, items, err)
+	}
+
+	page, err = cc.FindPage("item", nil, 1, 0, bson.M{"_id": 1}, &items, &mongodb.PageOptions{NoCount: true})
+	if err != nil || page.Total != -1 || page.Size != 10 || !page.HasNext || len(items) != 10 {
+		t.Fatalf("unexpected: %+v %v %v", page, items, err)
+	}
+	page, err = cc.FindPage("item", nil, 3, 10, bson.M{"_id": 1}, &items, &mongodb.PageOptions{NoCount: true})
+	if err != nil || page.HasNext || len(items) != 5 {
+		t.Fatalf("unexpected: %+v %v %v", page, items, err)
+	}
+
+	page, err = cc.FindPage("none", nil, 1, 10, nil, &items)
+	if err != nil || page.Total != 0 || page.Pages != 0 || len(items) != 0 {
+		t.Fatalf("unexpected: %+v %v %v", page, items, err)
+	}
+}
+
+func TestClient_FindPageSortBeforeFacet(t *testing.T) {
+	srv, err := mongodbtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	rec := mongodbtest.NewRecorder("")
+	cnf := srv.Config("test")
+	cnf.Monitor = rec.Monitor()
+	cc, err := mongodb.NewClient(cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	var items []*pageItem
+	if _, err := cc.FindPage("item", nil, 1, 10, bson.M{"age": -1}, &items); err != nil {
+		t.Fatal(err)
+	}
+	// $sort紧随$match, 以便使用索引
+	for _, r := range rec.Records() {
+		if r.Command[0].Key != "aggregate" {
+			continue
+		}
+		pipeline := r.Command.Map()["pipeline"].(bson.A)
+		if len(pipeline) != 3 || pipeline[1].(bson.D)[0].Key != "$sort" || pipeline[2].(bson.D)[0].Key != "$facet" {
+			t.Fatalf("unexpected pipeline: %v", pipeline)
+		}
+		return
+	}
+	t.Fatal("no aggregate command")
+}
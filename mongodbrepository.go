@@ -0,0 +1,144 @@
+package mongodb
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+)
+
+// 集合的类型化访问: 文档类型由model指定(如(*User)(nil)), 写入时校验文档类型, 读取时按该类型创建文档.
+// 各方法经helper方法执行, 同样调用文档钩子及集合策略
+//
+//	users := cc.Repository("user", (*User)(nil))
+//	u, not, err := users.FindId(1)
+//	name := u.(*User).Name
+type Repository struct {
+	cc  *Client
+	db  string
+	cl  string
+	typ reflect.Type // 文档的结构类型, 读取结果为其指针
+}
+
+func (cc *Client) Repository(cl string, model interface{}) *Repository {
+	return cc.DBRepository(cc.DB, cl, model)
+}
+
+// 同时以Model注册集合的文档类型, 删除前调用BeforeDelete
+func (cc *Client) DBRepository(db string, cl string, model interface{}) *Repository {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	cc.DBModel(db, cl, model)
+	return &Repository{cc: cc, db: db, cl: cl, typ: t}
+}
+
+// 新建文档, 返回类型为*T
+func (r *Repository) New() interface{} {
+	return reflect.New(r.typ).Interface()
+}
+
+func (r *Repository) Count(filter interface{}) (int64, error) {
+	return r.cc.DBCount(r.db, r.cl, filter)
+}
+
+// 返回*T, 不存在时not为true
+func (r *Repository) FindId(id interface{}, opts ...*options.FindOneOptions) (ret interface{}, not bool, err error) {
+	ret = r.New()
+	if not, err = r.cc.DBFindId(r.db, r.cl, id, ret, opts...); err != nil || not {
+		ret = nil
+	}
+	return
+}
+
+// 返回*T, 不存在时not为true
+func (r *Repository) FindOne(filter interface{}, opts ...*options.FindOneOptions) (ret interface{}, not bool, err error) {
+	ret = r.New()
+	if not, err = r.cc.DBFindOne(r.db, r.cl, filter, ret, opts...); err != nil || not {
+		ret = nil
+	}
+	return
+}
+
+// 返回[]*T
+func (r *Repository) Find(filter interface{}, opts ...*options.FindOptions) (interface{}, error) {
+	ret := reflect.New(reflect.SliceOf(reflect.PtrTo(r.typ)))
+	if err := r.cc.DBFind(r.db, r.cl, filter, ret.Interface(), opts...); err != nil {
+		return nil, err
+	}
+	return ret.Elem().Interface(), nil
+}
+
+// doc须为T或*T, 为T时钩子作用于副本
+func (r *Repository) Insert(doc interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := r.check(Op_InsertOne, doc)
+	if err != nil {
+		return nil, err
+	}
+	return r.cc.DBInsertOne(r.db, r.cl, doc, opts...)
+}
+
+// docs为T或*T的切片, 钩子作用于切片中的元素
+func (r *Repository) InsertMany(docs interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	rv := reflect.ValueOf(docs)
+	if rv.Kind() != reflect.Slice {
+		return nil, r.cc.wrapError(r.op(Op_InsertMany), fmt.Errorf("mongodb repository expects a slice of %v, got %T", r.typ, docs))
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		ev := rv.Index(i)
+		if ev.Type() == r.typ {
+			ev = ev.Addr() // 钩子作用于切片中的元素
+		}
+		doc, err := r.check(Op_InsertMany, ev.Interface())
+		if err != nil {
+			return nil, err
+		}
+		items[i] = doc
+	}
+	return r.cc.DBInsertMany(r.db, r.cl, items, opts...)
+}
+
+func (r *Repository) UpdateId(id interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return r.cc.DBUpdateId(r.db, r.cl, id, update, opts...)
+}
+
+func (r *Repository) UpdateMany(filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return r.cc.DBUpdateMany(r.db, r.cl, filter, update, opts...)
+}
+
+// doc须为T或*T, 为T时钩子作用于副本
+func (r *Repository) ReplaceId(id interface{}, doc interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	doc, err := r.check(Op_ReplaceId, doc)
+	if err != nil {
+		return nil, err
+	}
+	return r.cc.DBReplaceId(r.db, r.cl, id, doc, opts...)
+}
+
+func (r *Repository) DeleteId(id interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return r.cc.DBDeleteId(r.db, r.cl, id, opts...)
+}
+
+// 必须注意: empty filter会删除整个集合数据
+func (r *Repository) DeleteMany(filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return r.cc.DBDeleteMany(r.db, r.cl, filter, opts...)
+}
+
+func (r *Repository) op(name string) *Operation {
+	return &Operation{Database: r.db, Collection: r.cl, Name: name}
+}
+
+// 校验文档类型, T转为*T副本, 以便调用指针接收者实现的钩子
+func (r *Repository) check(name string, doc interface{}) (interface{}, error) {
+	switch reflect.TypeOf(doc) {
+	case reflect.PtrTo(r.typ):
+		return doc, nil
+	case r.typ:
+		ptr := reflect.New(r.typ)
+		ptr.Elem().Set(reflect.ValueOf(doc))
+		return ptr.Interface(), nil
+	}
+	return nil, r.cc.wrapError(r.op(name), fmt.Errorf("mongodb repository expects %v, got %T", r.typ, doc))
+}
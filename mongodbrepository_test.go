@@ -0,0 +1,60 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+func TestRepository(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	users := cc.Repository("user", (*hookedUser)(nil))
+	if _, err := users.Insert(&hookedUser{Id: 1, Name: "Tom"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.InsertMany([]hookedUser{{Id: 2, Name: "Jerry"}, {Id: 3, Name: "Spike", Readonly: true}}); err != nil {
+		t.Fatal(err)
+	}
+	// 类型不符时不写入
+	var me *mongodb.Error
+	if _, err := users.Insert(bson.M{"_id": 4}); !errors.As(err, &me) || me.Op != mongodb.Op_InsertOne {
+		t.Fatalf("expect type error, got %v", err)
+	}
+
+	u, not, err := users.FindId(1)
+	if err != nil || not || u.(*hookedUser).Name != "tom" || !u.(*hookedUser).found {
+		t.Fatalf("find id: %+v %v %v", u, not, err)
+	}
+	if u, not, err = users.FindId(4); err != nil || !not || u != nil {
+		t.Fatalf("find missing: %+v %v %v", u, not, err)
+	}
+	all, err := users.Find(mongodb.ALL)
+	if list, ok := all.([]*hookedUser); err != nil || !ok || len(list) != 3 || !list[2].found {
+		t.Fatalf("find: %+v %v", all, err)
+	}
+
+	if _, err := users.ReplaceId(1, hookedUser{Id: 1, Name: "TOMMY"}); err != nil {
+		t.Fatal(err)
+	}
+	if u, _, err = users.FindOne(bson.M{"name": "tommy"}); err != nil || u == nil {
+		t.Fatalf("replaced: %+v %v", u, err)
+	}
+	// 以Model注册了文档类型, 删除前调用BeforeDelete
+	if _, err := users.DeleteId(3); !errors.Is(err, errReadonly) {
+		t.Fatalf("expect readonly, got %v", err)
+	}
+	if ret, err := users.DeleteMany(bson.M{"readonly": false}); err != nil || ret.DeletedCount != 2 {
+		t.Fatalf("delete: %v %v", ret, err)
+	}
+	if n, err := users.Count(nil); err != nil || n != 1 {
+		t.Fatalf("count: %v %v", n, err)
+	}
+}
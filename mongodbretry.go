@@ -0,0 +1,225 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"github.com/obase/conf"
+	"go.mongodb.org/mongo-driver/bson"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryMinBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+// 重试策略, 作用于Client的helper方法. 与驱动的retryReads/retryWrites相互独立
+type RetryPolicy struct {
+	MaxAttempts        int           `json:"MaxAttempts" yaml:"MaxAttempts"`               // 最大尝试次数(含首次), 小于等于1表示不重试
+	MinBackoff         time.Duration `json:"MinBackoff" yaml:"MinBackoff"`                 // 首次退避时间, 默认100毫秒, 之后按指数增长
+	MaxBackoff         time.Duration `json:"MaxBackoff" yaml:"MaxBackoff"`                 // 最大退避时间, 默认5秒
+	Jitter             float64       `json:"Jitter" yaml:"Jitter"`                         // 抖动比例[0,1], 退避时间在(1±Jitter)范围内随机, 超出范围时截断
+	RetryNonIdempotent bool          `json:"RetryNonIdempotent" yaml:"RetryNonIdempotent"` // 是否重试非幂等写操作, 默认false
+
+	Retryable func(err error) bool                                            `json:"-" yaml:"-"` // 可重试判定, 默认IsRetryable
+	OnRetry   func(op *Operation, attempt int, wait time.Duration, err error) `json:"-" yaml:"-"` // 每次重试前回调
+}
+
+func (cc *Client) RetryPolicy(policy *RetryPolicy) *Client {
+	cc.retryPolicy = policy
+	return cc
+}
+
+func (cc *Client) retry(op *Operation, fn func() error) (err error) {
+	p := cc.retryPolicy
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || p == nil || attempt >= p.MaxAttempts || !p.retryable(err) || !p.idempotent(op) {
+			return
+		}
+		wait := p.backoff(attempt)
+		if p.OnRetry != nil {
+			p.OnRetry(op, attempt, wait, err)
+		}
+		if !sleepContext(op.Context, wait) {
+			return // 调用方已取消, 返回最后一次的错误
+		}
+	}
+}
+
+// 等待d, ctx取消时提前返回false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if ctx == nil {
+		time.Sleep(d)
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// 第attempt次失败后的退避时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = defaultRetryMinBackoff
+	}
+	if max <= 0 {
+		max = defaultRetryMaxBackoff
+	}
+	wait := min
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	if jitter := math.Min(p.Jitter, 1); jitter > 0 {
+		delta := time.Duration(float64(wait) * jitter)
+		if delta > 0 {
+			wait = wait - delta + time.Duration(rand.Int63n(int64(2*delta)+1))
+		}
+	}
+	return wait
+}
+
+// 判断重新执行操作是否安全, 非幂等写仅在RetryNonIdempotent时重试
+func (p *RetryPolicy) idempotent(op *Operation) bool {
+	if p.RetryNonIdempotent {
+		return true
+	}
+	switch op.Name {
+	case Op_ListDatabaseNames, Op_ListCollectionNames, Op_Count, Op_FindId, Op_FindOne, Op_Find, Op_FindPage, Op_FindKeyset, Op_FindWith, Op_FindIter, Op_Distinct, Op_Aggregate, Op_AggregateWith, Op_AggregateIter, Op_ParallelScan:
+		return true
+	case Op_DeleteId, Op_DeleteMany, Op_ReplaceId, Op_FindIdAndReplace:
+		return true
+	case Op_DeleteOne, Op_ReplaceOne, Op_FindOneAndReplace:
+		return idFilter(op.Filter) // 其他条件下首次已生效时, 重试会作用于另一个匹配的文档
+	case Op_UpdateId, Op_UpdateMany, Op_FindIdAndUpdate:
+		return idempotentUpdate(op.Update)
+	case Op_UpdateOne, Op_FindOneAndUpdate:
+		return idFilter(op.Filter) && idempotentUpdate(op.Update)
+	case Op_InsertOne:
+		return hasId(op.Document) // 未指定_id时每次插入都会生成新_id
+	}
+	return false
+}
+
+// 幂等的更新操作符, $inc/$mul/$push/$pop/$bit等重复执行结果不同
+var idempotentUpdateOperators = map[string]bool{
+	"$set":         true,
+	"$unset":       true,
+	"$setOnInsert": true,
+	"$addToSet":    true,
+	"$pull":        true,
+	"$pullAll":     true,
+	"$rename":      true,
+	"$min":         true,
+	"$max":         true,
+	"$currentDate": true,
+}
+
+func idempotentUpdate(update interface{}) bool {
+	raw, err := bson.Marshal(update) // 管道形式的更新无法编组为文档, 视为非幂等
+	if err != nil {
+		return false
+	}
+	elems, err := bson.Raw(raw).Elements()
+	if err != nil || len(elems) == 0 {
+		return false
+	}
+	for _, elem := range elems {
+		if !idempotentUpdateOperators[elem.Key()] {
+			return false
+		}
+	}
+	return true
+}
+
+// 是否仅以_id相等匹配, 即至多匹配一个确定的文档
+func idFilter(filter interface{}) bool {
+	raw, err := bson.Marshal(filter)
+	if err != nil {
+		return false
+	}
+	elems, err := bson.Raw(raw).Elements()
+	if err != nil || len(elems) != 1 || elems[0].Key() != "_id" {
+		return false
+	}
+	doc, ok := elems[0].Value().DocumentOK()
+	if !ok {
+		return true
+	}
+	if elems, err = doc.Elements(); err != nil || len(elems) == 0 {
+		return true // 空文档按值相等匹配
+	}
+	if strings.HasPrefix(elems[0].Key(), "$") {
+		return len(elems) == 1 && elems[0].Key() == "$eq"
+	}
+	return true
+}
+
+func hasId(doc interface{}) bool {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return false
+	}
+	_, err = bson.Raw(raw).LookupErr("_id")
+	return err == nil
+}
+
+func GetRetryPolicy(val interface{}, ok bool) (*RetryPolicy, bool) {
+	switch val := val.(type) {
+	case nil:
+		return nil, true
+	case string:
+		val = strings.TrimSpace(val)
+		if val == "" {
+			return nil, true
+		}
+		return &RetryPolicy{MaxAttempts: conf.ToInt(val)}, true
+	case int:
+		return &RetryPolicy{MaxAttempts: val}, true
+	case map[string]interface{}:
+		return &RetryPolicy{
+			MaxAttempts:        conf.ToInt(val["MaxAttempts"]),
+			MinBackoff:         conf.ToDuration(val["MinBackoff"]),
+			MaxBackoff:         conf.ToDuration(val["MaxBackoff"]),
+			Jitter:             conf.ToFloat64(val["Jitter"]),
+			RetryNonIdempotent: conf.ToBool(val["RetryNonIdempotent"]),
+		}, true
+	case map[interface{}]interface{}:
+		ret := new(RetryPolicy)
+		for k, v := range val {
+			switch conf.ToString(k) {
+			case "MaxAttempts":
+				ret.MaxAttempts = conf.ToInt(v)
+			case "MinBackoff":
+				ret.MinBackoff = conf.ToDuration(v)
+			case "MaxBackoff":
+				ret.MaxBackoff = conf.ToDuration(v)
+			case "Jitter":
+				ret.Jitter = conf.ToFloat64(v)
+			case "RetryNonIdempotent":
+				ret.RetryNonIdempotent = conf.ToBool(v)
+			}
+		}
+		return ret, true
+	default:
+		panic(fmt.Sprintf("invalid value for retry policy: %v", val))
+	}
+}
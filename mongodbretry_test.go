@@ -0,0 +1,99 @@
+package mongodb
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	"time"
+)
+
+func TestClient_RetryPolicy(t *testing.T) {
+	var retries int
+	cc := new(Client).RetryPolicy(&RetryPolicy{
+		MaxAttempts: 3,
+		MinBackoff:  time.Millisecond,
+		OnRetry: func(op *Operation, attempt int, wait time.Duration, err error) {
+			retries++
+		},
+	})
+	netErr := mongo.CommandError{Labels: []string{ErrorLabel_NetworkError}}
+
+	var calls int
+	err := cc.retry(&Operation{Name: Op_UpdateOne, Filter: bson.M{"_id": 1}, Update: bson.M{"$set": bson.M{"a": 1}}}, func() error {
+		calls++
+		return netErr
+	})
+	if err == nil || calls != 3 || retries != 2 {
+		t.Fatalf("expect 3 calls and 2 retries, got %v %v %v", calls, retries, err)
+	}
+
+	calls = 0
+	cc.retry(&Operation{Name: Op_UpdateOne, Update: bson.M{"$inc": bson.M{"a": 1}}}, func() error {
+		calls++
+		return netErr
+	})
+	if calls != 1 {
+		t.Fatalf("non-idempotent update should not be retried, got %v calls", calls)
+	}
+
+	calls = 0
+	cc.retry(&Operation{Name: Op_InsertOne, Document: bson.M{"_id": 1}}, func() error {
+		calls++
+		return netErr
+	})
+	if calls != 3 {
+		t.Fatalf("insert with _id should be retried, got %v calls", calls)
+	}
+
+	// 单文档操作仅在以_id相等匹配时重试
+	for filter, expect := range map[string]int{
+		`{"_id": 1}`:                3,
+		`{"_id": {"$eq": 1}}`:       3,
+		`{"_id": {"$in": [1, 2]}}`:  1,
+		`{"name": "tom"}`:           1,
+		`{"_id": 1, "name": "tom"}`: 1,
+	} {
+		var f bson.D
+		if err := bson.UnmarshalExtJSON([]byte(filter), false, &f); err != nil {
+			t.Fatal(err)
+		}
+		calls = 0
+		cc.retry(&Operation{Name: Op_DeleteOne, Filter: f}, func() error {
+			calls++
+			return netErr
+		})
+		if calls != expect {
+			t.Fatalf("delete one %v: expect %v calls, got %v", filter, expect, calls)
+		}
+	}
+
+	// 调用方取消时停止等待
+	ctx, cancel := context.WithCancel(context.Background())
+	cc.RetryPolicy(&RetryPolicy{MaxAttempts: 3, MinBackoff: time.Hour})
+	calls = 0
+	start := time.Now()
+	cc.retry(&Operation{Name: Op_Find, Context: ctx}, func() error {
+		calls++
+		cancel()
+		return netErr
+	})
+	if calls != 1 || time.Since(start) > time.Second {
+		t.Fatalf("expect canceled, got %v calls", calls)
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := &RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, expect := range []time.Duration{10, 20, 40, 50, 50} {
+		if wait := p.backoff(attempt + 1); wait != expect*time.Millisecond {
+			t.Fatalf("attempt %v: expect %v, got %v", attempt+1, expect*time.Millisecond, wait)
+		}
+	}
+	p.Jitter = 5
+	for i := 0; i < 100; i++ {
+		if wait := p.backoff(1); wait < 0 || wait > 20*time.Millisecond {
+			t.Fatalf("jitter out of range: %v", wait)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	ScanSplit_sample = "sample" // $sample取样_id作为分区边界, 适用任意_id类型
+	ScanSplit_minmax = "minmax" // 按最小及最大_id等分, 仅支持ObjectId及数值_id
+
+	defaultScanConcurrency = 4
+	defaultScanBatchSize   = 1000
+	scanSamplesPerRange    = 10 // 每个分区的取样数, 取样越多边界越均匀
+)
+
+// 并行扫描选项, Handle与HandleBatch二选一, 会被多个worker并发调用
+type ScanOptions struct {
+	Filter      interface{}                 // 过滤条件
+	Projection  interface{}                 // 投影
+	Partitions  int                         // 按_id划分的分区数, 默认为Concurrency的4倍
+	Concurrency int                         // 并发worker数, 默认4
+	BatchSize   int                         // HandleBatch每批条数, 同时作为游标批大小, 默认1000
+	Split       string                      // 分区方式, sample(默认) | minmax
+	StopOnError bool                        // 任一分区失败时取消其余分区, 默认继续执行并汇总错误
+	Handle      func(doc bson.Raw) error    // 逐条处理, doc在返回后会被游标复用, 需保留时须复制
+	HandleBatch func(docs []bson.Raw) error // 按批处理, docs已复制, 可直接保留
+}
+
+// 扫描统计
+type ScanStats struct {
+	Partitions int   // 实际分区数
+	Docs       int64 // 已处理文档数
+	Batches    int64 // 已处理批数
+}
+
+// 单个分区的扫描错误, Min/Max为分区的_id边界, nil表示不限
+type PartitionError struct {
+	Partition  int
+	Min        interface{}
+	Max        interface{}
+	OtherTypes bool // 与边界类型不同的_id所在的分区, 此时Min/Max为空
+	Err        error
+}
+
+func (e *PartitionError) Error() string {
+	if e.OtherTypes {
+		return fmt.Sprintf("partition %v (other _id types): %v", e.Partition, e.Err)
+	}
+	return fmt.Sprintf("partition %v [%v, %v): %v", e.Partition, e.Min, e.Max, e.Err)
+}
+
+func (e *PartitionError) Unwrap() error {
+	return e.Err
+}
+
+// 并行扫描的汇总错误
+type ScanError struct {
+	Errors []*PartitionError
+}
+
+func (e *ScanError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, pe := range e.Errors {
+		msgs[i] = pe.Error()
+	}
+	return fmt.Sprintf("%v partitions failed: %v", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+type scanRange struct {
+	idx      int
+	min, max interface{}
+	other    interface{} // 非空时扫描与该边界类型不同的_id
+}
+
+func (cc *Client) ParallelScan(ctx context.Context, cl string, so *ScanOptions) (*ScanStats, error) {
+	return cc.DBParallelScan(ctx, cc.DB, cl, so)
+}
+
+// 并行扫描: 按_id将集合划分为若干区间, 以有限并发分别扫描并调用处理函数.
+// 比较运算只匹配同类型的_id, 其他类型的_id由最后一个分区单独扫描. 处理函数的错误按分区汇总为*ScanError
+func (cc *Client) DBParallelScan(ctx context.Context, db string, cl string, so *ScanOptions) (stats *ScanStats, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	opt := *so
+	if opt.Handle == nil && opt.HandleBatch == nil {
+		return nil, fmt.Errorf("mongodb parallel scan requires Handle or HandleBatch")
+	}
+	if opt.Filter == nil {
+		opt.Filter = ALL
+	}
+	if opt.Concurrency <= 0 {
+		opt.Concurrency = defaultScanConcurrency
+	}
+	if opt.Partitions <= 0 {
+		opt.Partitions = opt.Concurrency * 4
+	}
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = defaultScanBatchSize
+	}
+
+	op := &Operation{Database: db, Collection: cl, Name: Op_ParallelScan, Filter: opt.Filter, Context: ctx}
+	var bounds []interface{}
+	if opt.Partitions > 1 {
+		if err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+			if opt.Split == ScanSplit_minmax {
+				bounds, err = minmaxBounds(ctx, coll, opt.Partitions)
+			} else {
+				bounds, err = sampleBounds(ctx, coll, opt.Partitions)
+			}
+			return
+		}); err != nil {
+			return
+		}
+	}
+	ranges := make([]*scanRange, 0, len(bounds)+1)
+	for i := 0; i <= len(bounds); i++ {
+		r := &scanRange{idx: i}
+		if i > 0 {
+			r.min = bounds[i-1]
+		}
+		if i < len(bounds) {
+			r.max = bounds[i]
+		}
+		ranges = append(ranges, r)
+	}
+	if len(bounds) > 0 {
+		ranges = append(ranges, &scanRange{idx: len(ranges), other: bounds[0]})
+	}
+	stats = &ScanStats{Partitions: len(ranges)}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan *scanRange)
+	var mux sync.Mutex
+	var errs []*PartitionError
+	var wg sync.WaitGroup
+	for i := 0; i < opt.Concurrency && i < len(ranges); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range ch {
+				if err := cc.scanRange(ctx, db, cl, &opt, r, stats); err != nil {
+					mux.Lock()
+					errs = append(errs, &PartitionError{Partition: r.idx, Min: r.min, Max: r.max, OtherTypes: r.other != nil, Err: err})
+					mux.Unlock()
+					if opt.StopOnError {
+						cancel()
+					}
+				}
+			}
+		}()
+	}
+loop:
+	for _, r := range ranges {
+		select {
+		case ch <- r:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return stats, cc.wrapError(op, &ScanError{Errors: errs})
+	}
+	if err = ctx.Err(); err != nil {
+		return stats, cc.wrapError(op, err)
+	}
+	return
+}
+
+// 扫描单个分区. 仅打开游标经由exec(重试/熔断), 处理函数不会被重复调用
+func (cc *Client) scanRange(ctx context.Context, db string, cl string, so *ScanOptions, r *scanRange, stats *ScanStats) error {
+	idRange := bson.D{}
+	if r.min != nil {
+		idRange = append(idRange, bson.E{Key: "$gte", Value: r.min})
+	}
+	if r.max != nil {
+		idRange = append(idRange, bson.E{Key: "$lt", Value: r.max})
+	}
+	filter := so.Filter
+	if r.other != nil {
+		// 既不大于等于也不小于边界, 即与边界类型不同
+		filter = bson.D{{Key: "$and", Value: bson.A{
+			so.Filter,
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gte", Value: r.other}}}}}},
+			bson.D{{Key: "_id", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$lt", Value: r.other}}}}}},
+		}}}
+	} else if len(idRange) > 0 {
+		filter = bson.D{{Key: "$and", Value: bson.A{so.Filter, bson.D{{Key: "_id", Value: idRange}}}}}
+	}
+	fopt := options.Find().SetBatchSize(int32(so.BatchSize))
+	if so.Projection != nil {
+		fopt.SetProjection(so.Projection)
+	}
+
+	var cur *mongo.Cursor
+	op := &Operation{Database: db, Collection: cl, Name: Op_ParallelScan, Filter: filter, Context: ctx}
+	if err := cc.exec(op, func(coll *mongo.Collection) (err error) {
+		cur, err = coll.Find(ctx, op.Filter, fopt)
+		return
+	}); err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+
+	batch := make([]bson.Raw, 0, so.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := so.HandleBatch(batch); err != nil {
+			return err
+		}
+		atomic.AddInt64(&stats.Docs, int64(len(batch)))
+		atomic.AddInt64(&stats.Batches, 1)
+		batch = make([]bson.Raw, 0, so.BatchSize)
+		return nil
+	}
+	for cur.Next(ctx) {
+		if so.Handle != nil {
+			if err := so.Handle(cur.Current); err != nil {
+				return err
+			}
+			atomic.AddInt64(&stats.Docs, 1)
+			continue
+		}
+		// Current在getMore后会被复用, 批处理须复制
+		batch = append(batch, append(bson.Raw(nil), cur.Current...))
+		if len(batch) >= so.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if so.HandleBatch != nil {
+		return flush()
+	}
+	return nil
+}
+
+// 取样_id后按分位数选取边界
+func sampleBounds(ctx context.Context, coll *mongo.Collection, partitions int) ([]interface{}, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: partitions * scanSamplesPerRange}}}},
+		bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+	cur, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var ids []bson.RawValue
+	for cur.Next(ctx) {
+		id := cur.Current.Lookup("_id")
+		id.Value = append([]byte(nil), id.Value...) // Current会被复用
+		ids = append(ids, id)
+	}
+	if err = cur.Err(); err != nil {
+		cur.Close(context.Background())
+		return nil, err
+	}
+	cur.Close(context.Background())
+
+	// 边界须为同类型, 取中位样本的类型, 其他类型由最后一个分区扫描
+	if len(ids) > 0 {
+		bracket := idBracket(ids[len(ids)/2].Type)
+		same := ids[:0]
+		for _, id := range ids {
+			if idBracket(id.Type) == bracket {
+				same = append(same, id)
+			}
+		}
+		ids = same
+	}
+	var ret []interface{}
+	for i := 1; i < partitions; i++ {
+		idx := len(ids) * i / partitions
+		if idx == 0 || idx >= len(ids) {
+			continue
+		}
+		id := ids[idx]
+		if n := len(ret); n > 0 {
+			if last := ret[n-1].(bson.RawValue); last.Type == id.Type && bytes.Equal(last.Value, id.Value) {
+				continue
+			}
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// 按最小及最大_id等分
+func minmaxBounds(ctx context.Context, coll *mongo.Collection, partitions int) ([]interface{}, error) {
+	var min, max bson.RawValue
+	for _, dir := range []int{1, -1} {
+		raw, err := coll.FindOne(ctx, ALL, options.FindOne().SetSort(bson.D{{Key: "_id", Value: dir}}).SetProjection(bson.D{{Key: "_id", Value: 1}})).DecodeBytes()
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dir > 0 {
+			min = raw.Lookup("_id")
+		} else {
+			max = raw.Lookup("_id")
+		}
+	}
+
+	var ret []interface{}
+	switch {
+	case min.Type == bsontype.ObjectID && max.Type == bsontype.ObjectID:
+		lo, hi := min.ObjectID(), max.ObjectID()
+		from, to := new(big.Int).SetBytes(lo[:]), new(big.Int).SetBytes(hi[:])
+		span := new(big.Int).Sub(to, from)
+		for i := 1; i < partitions; i++ {
+			v := new(big.Int).Mul(span, big.NewInt(int64(i)))
+			v.Div(v, big.NewInt(int64(partitions))).Add(v, from)
+			var id primitive.ObjectID
+			bs := v.Bytes()
+			copy(id[len(id)-len(bs):], bs)
+			ret = append(ret, id)
+		}
+	case isIntegral(min) && isIntegral(max):
+		lo, hi := toInt64(min), toInt64(max)
+		for i := 1; i < partitions; i++ {
+			ret = append(ret, lo+int64(float64(hi-lo)*float64(i)/float64(partitions)))
+		}
+	case isNumeric(min) && isNumeric(max):
+		lo, hi := toFloat64(min), toFloat64(max)
+		for i := 1; i < partitions; i++ {
+			ret = append(ret, lo+(hi-lo)*float64(i)/float64(partitions))
+		}
+	default:
+		return nil, fmt.Errorf("minmax split requires ObjectId or numeric _id, got %v and %v", min.Type, max.Type)
+	}
+	return dedupBounds(ret), nil
+}
+
+// 比较运算视为同类的类型
+func idBracket(t bsontype.Type) bsontype.Type {
+	switch t {
+	case bsontype.Int32, bsontype.Int64, bsontype.Double, bsontype.Decimal128:
+		return bsontype.Double
+	case bsontype.Symbol:
+		return bsontype.String
+	}
+	return t
+}
+
+func isIntegral(v bson.RawValue) bool {
+	return v.Type == bsontype.Int32 || v.Type == bsontype.Int64
+}
+
+func isNumeric(v bson.RawValue) bool {
+	return isIntegral(v) || v.Type == bsontype.Double
+}
+
+func toInt64(v bson.RawValue) int64 {
+	if v.Type == bsontype.Int32 {
+		return int64(v.Int32())
+	}
+	return v.Int64()
+}
+
+func toFloat64(v bson.RawValue) float64 {
+	if v.Type == bsontype.Double {
+		return v.Double()
+	}
+	return float64(toInt64(v))
+}
+
+// 范围过小时等分点可能重复
+func dedupBounds(bounds []interface{}) []interface{} {
+	var ret []interface{}
+	for _, b := range bounds {
+		if len(ret) == 0 || ret[len(ret)-1] != b {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,131 @@
+package mongodb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"sync"
+	"testing"
+)
+
+func TestClient_ParallelScan(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	oids := make([]interface{}, 0, 500)
+	ints := make([]interface{}, 0, 500)
+	for i := 0; i < 500; i++ {
+		oids = append(oids, bson.M{"_id": mongodbtest.NamedObjectId(fmt.Sprint(i)), "n": i})
+		ints = append(ints, bson.M{"_id": i, "n": i})
+	}
+	if _, err := cc.InsertMany("oid", oids); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.InsertMany("int", ints); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		cl    string
+		split string
+	}{
+		{"oid", mongodb.ScanSplit_sample},
+		{"oid", mongodb.ScanSplit_minmax},
+		{"int", mongodb.ScanSplit_sample},
+		{"int", mongodb.ScanSplit_minmax},
+	} {
+		var mux sync.Mutex
+		seen := make(map[int32]int)
+		stats, err := cc.ParallelScan(nil, c.cl, &mongodb.ScanOptions{
+			Filter:      bson.M{"n": bson.M{"$gte": 100}},
+			Partitions:  8,
+			Concurrency: 3,
+			BatchSize:   16,
+			Split:       c.split,
+			HandleBatch: func(docs []bson.Raw) error {
+				mux.Lock()
+				defer mux.Unlock()
+				for _, doc := range docs {
+					seen[doc.Lookup("n").Int32()]++
+				}
+				return nil
+			},
+		})
+		if err != nil || stats.Docs != 400 || stats.Partitions < 2 || len(seen) != 400 {
+			t.Fatalf("%v %v: unexpected %+v %v %v", c.cl, c.split, stats, len(seen), err)
+		}
+		for n, cnt := range seen {
+			if cnt != 1 {
+				t.Fatalf("%v %v: doc %v handled %v times", c.cl, c.split, n, cnt)
+			}
+		}
+	}
+
+	// 不同类型的_id由最后一个分区扫描, Handle保留的doc须复制
+	mixed := make([]interface{}, 0, 370)
+	for i := 0; i < 370; i++ {
+		var id interface{} = i
+		switch {
+		case i >= 350:
+			id = mongodbtest.NamedObjectId(fmt.Sprint(i))
+		case i >= 300:
+			id = fmt.Sprintf("s%03d", i)
+		}
+		mixed = append(mixed, bson.M{"_id": id, "n": i})
+	}
+	if _, err := cc.InsertMany("mixed", mixed); err != nil {
+		t.Fatal(err)
+	}
+	for _, split := range []string{mongodb.ScanSplit_sample, mongodb.ScanSplit_minmax} {
+		var mux sync.Mutex
+		var docs []bson.Raw
+		stats, err := cc.ParallelScan(nil, "mixed", &mongodb.ScanOptions{
+			Partitions: 6,
+			BatchSize:  7,
+			Split:      split,
+			Handle: func(doc bson.Raw) error {
+				mux.Lock()
+				defer mux.Unlock()
+				docs = append(docs, append(bson.Raw(nil), doc...))
+				return nil
+			},
+		})
+		if split == mongodb.ScanSplit_minmax {
+			if err == nil {
+				t.Fatal("minmax split should reject mixed _id types")
+			}
+			continue
+		}
+		seen := make(map[int32]int)
+		for _, doc := range docs {
+			seen[doc.Lookup("n").Int32()]++
+		}
+		if err != nil || stats.Docs != 370 || len(seen) != 370 {
+			t.Fatalf("mixed: unexpected %+v %v %v", stats, len(seen), err)
+		}
+	}
+
+	// 处理函数的错误按分区汇总
+	boom := errors.New("boom")
+	stats, err := cc.ParallelScan(context.Background(), "int", &mongodb.ScanOptions{
+		Partitions: 5,
+		Split:      mongodb.ScanSplit_minmax,
+		Handle: func(doc bson.Raw) error {
+			if n := doc.Lookup("n").Int32(); n == 10 || n == 490 {
+				return boom
+			}
+			return nil
+		},
+	})
+	var se *mongodb.ScanError
+	if !errors.As(err, &se) || len(se.Errors) != 2 || !errors.Is(se.Errors[0], boom) || stats.Docs >= 500 {
+		t.Fatalf("unexpected: %+v %v", stats, err)
+	}
+}
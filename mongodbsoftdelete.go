@@ -0,0 +1,232 @@
+package mongodb
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const (
+	defaultDeletedAtField = "deletedAt"
+	deleteBatchSize       = 1000 // DeleteMany调用BeforeDelete时每批读取及删除的文档数
+)
+
+// 集合的软删除策略: DeleteId/DeleteOne/DeleteMany/FindXXXAndDelete改为写入deletedAt, 操作名称不变, 读操作自动排除已删除文档.
+// 排除作用于FindId/FindOne/Find/FindPage/FindKeyset/FindWith/FindIter/Count/Distinct/Aggregate系列/ParallelScan, 不影响更新操作
+type SoftDelete struct {
+	Field string           // 删除时间字段, 默认deletedAt
+	Now   func() time.Time // 时钟, 默认time.Now
+}
+
+func (cc *Client) SoftDelete(cl string, sd *SoftDelete) *Client {
+	return cc.DBSoftDelete(cc.DB, cl, sd)
+}
+
+// 为集合启用软删除, sd为nil时取消
+func (cc *Client) DBSoftDelete(db string, cl string, sd *SoftDelete) *Client {
+	if sd == nil {
+		cc.softDeletes.Delete(db + "." + cl)
+		return cc
+	}
+	s := *sd
+	if s.Field == "" {
+		s.Field = defaultDeletedAtField
+	}
+	if s.Now == nil {
+		s.Now = time.Now
+	}
+	cc.softDeletes.Store(db+"."+cl, &s)
+	return cc
+}
+
+// 返回读操作包含已删除文档的句柄, 与原客户端共用连接及状态
+func (cc *Client) WithDeleted() *Client {
+	ret := cc.clone()
+	ret.withDeleted = true
+	return ret
+}
+
+func (cc *Client) softDeleteOf(db string, cl string) *SoftDelete {
+	if cc.softDeletes == nil {
+		return nil
+	}
+	if v, ok := cc.softDeletes.Load(db + "." + cl); ok {
+		return v.(*SoftDelete)
+	}
+	return nil
+}
+
+// 在execDatabase中调用, 为读操作加入排除已删除文档的条件
+func (cc *Client) excludeDeleted(op *Operation) {
+	if cc.withDeleted {
+		return
+	}
+	sd := cc.softDeleteOf(op.Database, op.Collection)
+	if sd == nil {
+		return
+	}
+	notDeleted := bson.D{{Key: sd.Field, Value: nil}}
+	switch op.Name {
+	case Op_FindId, Op_FindOne, Op_Find, Op_FindPage, Op_FindKeyset, Op_FindWith, Op_FindIter, Op_Count, Op_Distinct, Op_ParallelScan:
+		if op.Filter == nil {
+			op.Filter = notDeleted
+		} else {
+			op.Filter = bson.D{{Key: "$and", Value: bson.A{op.Filter, notDeleted}}}
+		}
+	case Op_Aggregate, Op_AggregateWith, Op_AggregateIter:
+		op.Pipeline = prependMatch(op.Pipeline, notDeleted)
+	}
+}
+
+// 在管道开头加入$match, 必须位于首位的阶段之后
+func prependMatch(pipeline interface{}, match bson.D) interface{} {
+	stages, ok := toPipeline(pipeline)
+	if !ok {
+		return pipeline
+	}
+	// 驱动要求各阶段为文档, 不接受RawValue
+	for i, stage := range stages {
+		if doc, ok := stage.(bson.RawValue).DocumentOK(); ok {
+			stages[i] = doc
+		}
+	}
+	at := 0
+	if len(stages) > 0 {
+		if stage, ok := stages[0].(bson.Raw); ok {
+			if elems, err := stage.Elements(); err == nil && len(elems) > 0 {
+				switch elems[0].Key() {
+				case "$geoNear", "$search", "$searchMeta":
+					at = 1
+				case "$collStats", "$indexStats", "$currentOp", "$listSessions", "$changeStream", "$planCacheStats":
+					return pipeline // 元数据阶段, 不涉及文档
+				}
+			}
+		}
+	}
+	ret := make(bson.A, 0, len(stages)+1)
+	ret = append(ret, stages[:at]...)
+	ret = append(ret, bson.D{{Key: "$match", Value: match}})
+	return append(ret, stages[at:]...)
+}
+
+// 在execDatabase中调用, 软删除集合的删除操作改为写入删除时间: 条件排除已删除的文档, op.Update为写入删除时间的更新
+func (cc *Client) markDeleted(op *Operation) {
+	switch op.Name {
+	case Op_DeleteId, Op_DeleteOne, Op_DeleteMany, Op_FindIdAndDelete, Op_FindOneAndDelete:
+	default:
+		return
+	}
+	sd := cc.softDeleteOf(op.Database, op.Collection)
+	if sd == nil || op.purge {
+		return
+	}
+	op.Filter = bson.D{{Key: "$and", Value: bson.A{allIfNil(op.Filter), bson.D{{Key: sd.Field, Value: nil}}}}}
+	op.Update = bson.D{{Key: "$set", Value: bson.D{{Key: sd.Field, Value: sd.Now()}}}}
+}
+
+// $and不接受null, 空条件以空文档代替
+func allIfNil(filter interface{}) interface{} {
+	if filter == nil {
+		return bson.D{}
+	}
+	return filter
+}
+
+// 执行DeleteXXX, 软删除时以更新写入删除时间, DeletedCount为本次标记的条数
+func deleteDocs(coll *mongo.Collection, op *Operation, many bool) (*mongo.DeleteResult, error) {
+	opts, _ := op.Options.([]*options.DeleteOptions)
+	if !many {
+		if op.Update == nil {
+			return coll.DeleteOne(nil, op.Filter, opts...)
+		}
+		o := options.MergeDeleteOptions(opts...)
+		ret, err := coll.UpdateOne(nil, op.Filter, op.Update, options.Update().SetCollation(o.Collation).SetHint(o.Hint))
+		if err != nil {
+			return nil, err
+		}
+		return &mongo.DeleteResult{DeletedCount: ret.ModifiedCount}, nil
+	}
+	ret := &mongo.DeleteResult{}
+	for _, filter := range deleteFilters(op) {
+		if op.Update == nil {
+			dr, err := coll.DeleteMany(nil, filter, opts...)
+			if err != nil {
+				return nil, err
+			}
+			ret.DeletedCount += dr.DeletedCount
+			continue
+		}
+		o := options.MergeDeleteOptions(opts...)
+		ur, err := coll.UpdateMany(nil, filter, op.Update, options.Update().SetCollation(o.Collation).SetHint(o.Hint))
+		if err != nil {
+			return nil, err
+		}
+		ret.DeletedCount += ur.ModifiedCount
+	}
+	return ret, nil
+}
+
+// DeleteMany的条件: 已调用BeforeDelete时按读取的_id分批限定, 否则为原条件
+func deleteFilters(op *Operation) []interface{} {
+	if op.ids == nil {
+		return []interface{}{op.Filter}
+	}
+	var ret []interface{}
+	for i := 0; i < len(op.ids); i += deleteBatchSize {
+		end := i + deleteBatchSize
+		if end > len(op.ids) {
+			end = len(op.ids)
+		}
+		ret = append(ret, bson.D{{Key: "$and", Value: bson.A{allIfNil(op.Filter), bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: op.ids[i:end]}}}}}}})
+	}
+	return ret
+}
+
+// 执行FindXXXAndDelete, 软删除时以更新写入删除时间, 返回写入前的文档
+func findAndDelete(coll *mongo.Collection, op *Operation) *mongo.SingleResult {
+	opts, _ := op.Options.([]*options.FindOneAndDeleteOptions)
+	if op.Update == nil {
+		return coll.FindOneAndDelete(nil, op.Filter, opts...)
+	}
+	o := options.MergeFindOneAndDeleteOptions(opts...)
+	uopt := options.FindOneAndUpdate()
+	uopt.Collation, uopt.MaxTime, uopt.Projection, uopt.Sort, uopt.Hint = o.Collation, o.MaxTime, o.Projection, o.Sort, o.Hint
+	return coll.FindOneAndUpdate(nil, op.Filter, op.Update, uopt)
+}
+
+func (cc *Client) RestoreId(cl string, id interface{}) (*mongo.UpdateResult, error) {
+	return cc.DBRestore(cc.DB, cl, bson.M{"_id": id})
+}
+
+func (cc *Client) Restore(cl string, filter interface{}) (*mongo.UpdateResult, error) {
+	return cc.DBRestore(cc.DB, cl, filter)
+}
+
+// 恢复匹配filter的已删除文档, 集合未启用软删除时按默认字段处理
+func (cc *Client) DBRestore(db string, cl string, filter interface{}) (*mongo.UpdateResult, error) {
+	field := defaultDeletedAtField
+	if sd := cc.softDeleteOf(db, cl); sd != nil {
+		field = sd.Field
+	}
+	filter = bson.D{{Key: "$and", Value: bson.A{allIfNil(filter), bson.D{{Key: field, Value: bson.D{{Key: "$ne", Value: nil}}}}}}}
+	return cc.DBUpdateMany(db, cl, filter, bson.D{{Key: "$unset", Value: bson.D{{Key: field, Value: ""}}}})
+}
+
+func (cc *Client) Purge(cl string, retention time.Duration) (*mongo.DeleteResult, error) {
+	return cc.DBPurge(cc.DB, cl, retention)
+}
+
+// 物理删除删除时间早于retention之前的文档, retention为0时删除全部已删除文档
+func (cc *Client) DBPurge(db string, cl string, retention time.Duration) (result *mongo.DeleteResult, err error) {
+	field, now := defaultDeletedAtField, time.Now
+	if sd := cc.softDeleteOf(db, cl); sd != nil {
+		field, now = sd.Field, sd.Now
+	}
+	op := &Operation{Database: db, Collection: cl, Name: Op_DeleteMany, Filter: bson.D{{Key: field, Value: bson.D{{Key: "$lte", Value: now().Add(-retention)}}}}, purge: true}
+	err = cc.exec(op, func(coll *mongo.Collection) (err error) {
+		result, err = coll.DeleteMany(nil, op.Filter)
+		return
+	})
+	return
+}
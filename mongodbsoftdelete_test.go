@@ -0,0 +1,106 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestClient_SoftDelete(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cc := mdb.SoftDelete("user", &mongodb.SoftDelete{
+		Now: func() time.Time { return now },
+	})
+	defer cc.Close()
+
+	if _, err := cc.InsertMany("user", []interface{}{bson.M{"_id": 1, "n": 1}, bson.M{"_id": 2, "n": 1}, bson.M{"_id": 3, "n": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := cc.DeleteId("user", 1); err != nil || ret.DeletedCount != 1 {
+		t.Fatalf("delete: %v %v", ret, err)
+	}
+	// 重复删除不再计数
+	if ret, err := cc.DeleteId("user", 1); err != nil || ret.DeletedCount != 0 {
+		t.Fatalf("delete again: %v %v", ret, err)
+	}
+	if n, err := cc.Count("user", nil); err != nil || n != 2 {
+		t.Fatalf("count: %v %v", n, err)
+	}
+	var m bson.M
+	if not, err := cc.FindId("user", 1, &m); err != nil || !not {
+		t.Fatalf("find deleted: %v %v", m, err)
+	}
+	var ret []bson.M
+	if err := cc.Find("user", bson.M{"n": 1}, &ret); err != nil || len(ret) != 1 {
+		t.Fatalf("find: %v %v", ret, err)
+	}
+	if err := cc.Aggregate("user", []bson.M{{"$match": bson.M{"n": 1}}}, &ret); err != nil || len(ret) != 1 {
+		t.Fatalf("aggregate: %v %v", ret, err)
+	}
+	if not, err := cc.WithDeleted().FindId("user", 1, &m); err != nil || not || m["deletedAt"] == nil {
+		t.Fatalf("with deleted: %v %v", m, err)
+	}
+	if n, err := cc.WithDeleted().Count("user", nil); err != nil || n != 3 {
+		t.Fatalf("count with deleted: %v %v", n, err)
+	}
+
+	if r, err := cc.RestoreId("user", 1); err != nil || r.ModifiedCount != 1 {
+		t.Fatalf("restore: %v %v", r, err)
+	}
+	m = nil
+	if not, err := cc.FindId("user", 1, &m); err != nil || not || m["deletedAt"] != nil {
+		t.Fatalf("restored: %v %v", m, err)
+	}
+
+	if ret, err := cc.DeleteMany("user", bson.M{"n": 1}); err != nil || ret.DeletedCount != 2 {
+		t.Fatalf("delete many: %v %v", ret, err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := cc.DeleteId("user", 3); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	// 仅清除一小时以前删除的文档
+	if ret, err := cc.Purge("user", 30*time.Minute); err != nil || ret.DeletedCount != 2 {
+		t.Fatalf("purge: %v %v", ret, err)
+	}
+	if n, err := cc.WithDeleted().Count("user", nil); err != nil || n != 1 {
+		t.Fatalf("after purge: %v %v", n, err)
+	}
+
+	if _, err := cc.InsertMany("user", []interface{}{bson.M{"_id": 4, "n": 4}, bson.M{"_id": 5, "n": 5}}); err != nil {
+		t.Fatal(err)
+	}
+	m = nil
+	if not, err := cc.FindOneAndDelete("user", bson.M{"n": 4}, &m); err != nil || not || m["_id"] != int32(4) {
+		t.Fatalf("find and delete: %v %v", m, err)
+	}
+	if not, err := cc.WithDeleted().FindId("user", 4, &m); err != nil || not || m["deletedAt"] == nil {
+		t.Fatalf("find and delete marked: %v %v", m, err)
+	}
+	// 空条件标记全部未删除的文档
+	if ret, err := cc.DeleteMany("user", nil); err != nil || ret.DeletedCount != 1 {
+		t.Fatalf("delete all: %v %v", ret, err)
+	}
+
+	// 中间件看到的仍是删除操作
+	var names []string
+	cc.Use(func(op *mongodb.Operation, next func() error) error {
+		names = append(names, op.Name)
+		if op.Name == mongodb.Op_DeleteMany {
+			return errors.New("delete many blocked")
+		}
+		return next()
+	})
+	if _, err := cc.DeleteMany("user", bson.M{"n": 5}); err == nil || len(names) != 1 || names[0] != mongodb.Op_DeleteMany {
+		t.Fatalf("middleware: %v %v", names, err)
+	}
+}
@@ -0,0 +1,670 @@
+package mongodbtest
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"math/rand"
+	"strings"
+)
+
+// 聚合管道执行, from用于$lookup读取同库的其他集合
+func aggregate(docs []bson.D, pipeline []bson.D, from func(cl string) ([]bson.D, error)) ([]bson.D, error) {
+	var err error
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("a pipeline stage specification object must contain exactly one field")
+		}
+		if docs, err = runStage(docs, stage[0], from); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func runStage(docs []bson.D, stage bson.E, from func(cl string) ([]bson.D, error)) ([]bson.D, error) {
+	switch stage.Key {
+	case "$match":
+		filter, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("the match filter must be an expression in an object")
+		}
+		ret := make([]bson.D, 0, len(docs))
+		for _, doc := range docs {
+			ok, err := match(doc, filter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				ret = append(ret, doc)
+			}
+		}
+		return ret, nil
+	case "$sort":
+		spec, ok := stage.Value.(bson.D)
+		if !ok || len(spec) == 0 {
+			return nil, fmt.Errorf("the $sort key specification must be an object")
+		}
+		ret := append([]bson.D{}, docs...)
+		sortDocs(ret, spec)
+		return ret, nil
+	case "$skip", "$limit":
+		n, ok := toInt64(stage.Value)
+		if !ok {
+			if f := toFloat(stage.Value); f == f {
+				n, ok = int64(f), true
+			}
+		}
+		if !ok || n < 0 || (stage.Key == "$limit" && n == 0) {
+			return nil, fmt.Errorf("invalid argument to %v stage: %v", stage.Key, stage.Value)
+		}
+		if stage.Key == "$skip" {
+			return page(docs, n, 0), nil
+		}
+		return page(docs, 0, n), nil
+	case "$sample":
+		spec, _ := stage.Value.(bson.D)
+		v, _ := lookup(spec, "size")
+		n, ok := toInt64(v)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("size argument to $sample must be a non-negative number")
+		}
+		ret := make([]bson.D, 0, len(docs))
+		for _, i := range rand.Perm(len(docs)) {
+			if int64(len(ret)) == n {
+				break
+			}
+			ret = append(ret, docs[i])
+		}
+		return ret, nil
+	case "$project":
+		spec, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("$project specification must be an object")
+		}
+		return mapDocs(docs, func(doc bson.D) (bson.D, error) { return projectStage(doc, spec) })
+	case "$addFields", "$set":
+		spec, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%v specification must be an object", stage.Key)
+		}
+		return mapDocs(docs, func(doc bson.D) (bson.D, error) {
+			var cur interface{} = doc
+			for _, e := range spec {
+				v, err := evalExpr(doc, e.Value)
+				if err != nil {
+					return nil, err
+				}
+				if cur, err = setPath(cur, splitPath(e.Key), v); err != nil {
+					return nil, err
+				}
+			}
+			return cur.(bson.D), nil
+		})
+	case "$unset":
+		var paths [][]string
+		switch v := stage.Value.(type) {
+		case string:
+			paths = append(paths, splitPath(v))
+		case bson.A:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("$unset specification must be a string or an array of strings")
+				}
+				paths = append(paths, splitPath(s))
+			}
+		default:
+			return nil, fmt.Errorf("$unset specification must be a string or an array of strings")
+		}
+		return mapDocs(docs, func(doc bson.D) (bson.D, error) { return projectExclude(doc, paths), nil })
+	case "$count":
+		field, ok := stage.Value.(string)
+		if !ok || field == "" || strings.HasPrefix(field, "$") {
+			return nil, fmt.Errorf("the count field must be a non-empty string")
+		}
+		if len(docs) == 0 {
+			return []bson.D{}, nil
+		}
+		return []bson.D{{{Key: field, Value: int32(len(docs))}}}, nil
+	case "$unwind":
+		return unwindStage(docs, stage.Value)
+	case "$group":
+		spec, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("a group's fields must be specified in an object")
+		}
+		return groupStage(docs, spec)
+	case "$replaceRoot", "$replaceWith":
+		expr := stage.Value
+		if stage.Key == "$replaceRoot" {
+			spec, _ := stage.Value.(bson.D)
+			var ok bool
+			if expr, ok = lookup(spec, "newRoot"); !ok {
+				return nil, fmt.Errorf("no newRoot specified for the $replaceRoot stage")
+			}
+		}
+		return mapDocs(docs, func(doc bson.D) (bson.D, error) {
+			v, err := evalExpr(doc, expr)
+			if err != nil {
+				return nil, err
+			}
+			d, ok := v.(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("'newRoot' expression must evaluate to an object, but resulting value was: %v", format(v))
+			}
+			return d, nil
+		})
+	case "$facet":
+		spec, ok := stage.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("argument to $facet stage must be an object")
+		}
+		ret := bson.D{}
+		for _, e := range spec {
+			sub, ok := e.Value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("arguments to $facet must be arrays")
+			}
+			stages := make([]bson.D, 0, len(sub))
+			for _, s := range sub {
+				d, ok := s.(bson.D)
+				if !ok {
+					return nil, fmt.Errorf("subpipeline stages must be objects")
+				}
+				stages = append(stages, d)
+			}
+			out, err := aggregate(docs, stages, from)
+			if err != nil {
+				return nil, err
+			}
+			arr := make(bson.A, 0, len(out))
+			for _, d := range out {
+				arr = append(arr, d)
+			}
+			ret = append(ret, bson.E{Key: e.Key, Value: arr})
+		}
+		return []bson.D{ret}, nil
+	case "$lookup":
+		spec, _ := stage.Value.(bson.D)
+		cl, _ := lookup(spec, "from")
+		local, _ := lookup(spec, "localField")
+		foreign, _ := lookup(spec, "foreignField")
+		as, _ := lookup(spec, "as")
+		if _, ok := cl.(string); !ok || from == nil {
+			return nil, fmt.Errorf("$lookup only supports the from/localField/foreignField/as form")
+		}
+		others, err := from(cl.(string))
+		if err != nil {
+			return nil, err
+		}
+		return mapDocs(docs, func(doc bson.D) (bson.D, error) {
+			vals, found := resolve(doc, splitPath(fmt.Sprint(local)))
+			if !found {
+				vals = []interface{}{nil}
+			}
+			arr := bson.A{}
+			for _, other := range others {
+				ovals, ofound := resolve(other, splitPath(fmt.Sprint(foreign)))
+				for _, v := range candidates(vals) {
+					if matchEq(ovals, ofound, v) {
+						arr = append(arr, other)
+						break
+					}
+				}
+			}
+			ret, err := setPath(doc, splitPath(fmt.Sprint(as)), arr)
+			if err != nil {
+				return nil, err
+			}
+			return ret.(bson.D), nil
+		})
+	}
+	return nil, fmt.Errorf("unrecognized pipeline stage name: '%v'", stage.Key)
+}
+
+func mapDocs(docs []bson.D, fn func(doc bson.D) (bson.D, error)) ([]bson.D, error) {
+	ret := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		d, err := fn(doc)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// $project: 0/1取值按find投影处理, 其余值按表达式计算(包含模式)
+func projectStage(doc bson.D, spec bson.D) (bson.D, error) {
+	plain := bson.D{}
+	var computed bson.D
+	for _, e := range spec {
+		switch v := e.Value.(type) {
+		case bool:
+			plain = append(plain, e)
+		default:
+			if isNumber(v) {
+				plain = append(plain, e)
+			} else {
+				computed = append(computed, e)
+			}
+		}
+	}
+	hasInclude := false
+	for _, e := range plain {
+		if e.Key != "_id" && truthy(e.Value) {
+			hasInclude = true
+		}
+	}
+	var ret bson.D
+	var err error
+	if len(computed) > 0 && !hasInclude {
+		// 仅含计算字段时为包含模式, 只保留_id
+		ret = bson.D{}
+		if v, ok := lookup(spec, "_id"); !ok || truthy(v) {
+			if id, ok := lookup(doc, "_id"); ok {
+				ret = append(ret, bson.E{Key: "_id", Value: id})
+			}
+		}
+	} else if ret, err = project(doc, plain); err != nil {
+		return nil, err
+	}
+	var cur interface{} = ret
+	for _, e := range computed {
+		v, err := evalExpr(doc, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		if cur, err = setPath(cur, splitPath(e.Key), v); err != nil {
+			return nil, err
+		}
+	}
+	return cur.(bson.D), nil
+}
+
+func unwindStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	var path, index string
+	var preserve bool
+	switch v := spec.(type) {
+	case string:
+		path = v
+	case bson.D:
+		p, _ := lookup(v, "path")
+		path, _ = p.(string)
+		if i, ok := lookup(v, "includeArrayIndex"); ok {
+			index, _ = i.(string)
+		}
+		if b, ok := lookup(v, "preserveNullAndEmptyArrays"); ok {
+			preserve = truthy(b)
+		}
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path option to $unwind stage should be prefixed with a '$': %v", path)
+	}
+	fields := splitPath(path[1:])
+	ret := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		v, ok := getPath(doc, fields)
+		arr, isArr := v.(bson.A)
+		switch {
+		case isArr && len(arr) > 0:
+			for i, item := range arr {
+				d, err := setPath(doc, fields, item)
+				if err != nil {
+					return nil, err
+				}
+				if index != "" {
+					d = append(d.(bson.D), bson.E{Key: index, Value: int64(i)})
+				}
+				ret = append(ret, d.(bson.D))
+			}
+		case ok && v != nil && !isArr:
+			d := doc
+			if index != "" {
+				d = append(append(bson.D{}, doc...), bson.E{Key: index, Value: nil})
+			}
+			ret = append(ret, d)
+		case preserve:
+			d := doc
+			if isArr {
+				d = projectExclude(doc, [][]string{fields})
+			}
+			if index != "" {
+				d = append(append(bson.D{}, d...), bson.E{Key: index, Value: nil})
+			}
+			ret = append(ret, d)
+		}
+	}
+	return ret, nil
+}
+
+type group struct {
+	id   interface{}
+	accs bson.D // 各累加器的当前值
+	avg  map[string][2]float64
+	seen map[string]bool
+}
+
+func groupStage(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	idExpr, ok := lookup(spec, "_id")
+	if !ok {
+		return nil, fmt.Errorf("a group specification must include an _id")
+	}
+	var groups []*group
+	for _, doc := range docs {
+		id, err := evalExpr(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		var g *group
+		for _, x := range groups {
+			if equal(x.id, id) {
+				g = x
+				break
+			}
+		}
+		if g == nil {
+			g = &group{id: id, avg: map[string][2]float64{}, seen: map[string]bool{}}
+			groups = append(groups, g)
+		}
+		for i, e := range spec {
+			if e.Key == "_id" {
+				continue
+			}
+			acc, ok := e.Value.(bson.D)
+			if !ok || len(acc) != 1 {
+				return nil, fmt.Errorf("the field '%v' must be an accumulator object", e.Key)
+			}
+			v, err := evalExpr(doc, acc[0].Value)
+			if err != nil {
+				return nil, err
+			}
+			for len(g.accs) <= i {
+				g.accs = append(g.accs, bson.E{Key: spec[len(g.accs)].Key})
+			}
+			cur := &g.accs[i]
+			first := !g.seen[e.Key]
+			g.seen[e.Key] = true
+			switch acc[0].Key {
+			case "$sum":
+				if first {
+					cur.Value = int32(0)
+				}
+				if arr, ok := v.(bson.A); ok {
+					for _, item := range arr {
+						if isNumber(item) {
+							cur.Value = arith(cur.Value, item, func(a, b int64) int64 { return a + b }, func(a, b float64) float64 { return a + b })
+						}
+					}
+				} else if isNumber(v) {
+					cur.Value = arith(cur.Value, v, func(a, b int64) int64 { return a + b }, func(a, b float64) float64 { return a + b })
+				}
+			case "$avg":
+				if isNumber(v) {
+					s := g.avg[e.Key]
+					g.avg[e.Key] = [2]float64{s[0] + toFloat(v), s[1] + 1}
+				}
+				if s := g.avg[e.Key]; s[1] > 0 {
+					cur.Value = s[0] / s[1]
+				}
+			case "$min", "$max":
+				if v == nil {
+					break
+				}
+				if cur.Value == nil || (acc[0].Key == "$min" && compare(v, cur.Value) < 0) || (acc[0].Key == "$max" && compare(v, cur.Value) > 0) {
+					cur.Value = v
+				}
+			case "$first":
+				if first {
+					cur.Value = v
+				}
+			case "$last":
+				cur.Value = v
+			case "$push", "$addToSet":
+				arr, _ := cur.Value.(bson.A)
+				if arr == nil {
+					arr = bson.A{}
+				}
+				if acc[0].Key == "$push" || !contains(arr, v) {
+					arr = append(arr, v)
+				}
+				cur.Value = arr
+			default:
+				return nil, fmt.Errorf("unknown group operator '%v'", acc[0].Key)
+			}
+		}
+	}
+	ret := make([]bson.D, 0, len(groups))
+	for _, g := range groups {
+		d := bson.D{{Key: "_id", Value: g.id}}
+		for _, e := range g.accs {
+			if e.Key != "_id" {
+				d = append(d, e)
+			}
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// 按字段路径取值, 数组中的文档逐个取值后组成数组(与聚合表达式语义一致)
+func fieldPath(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+	switch v := v.(type) {
+	case bson.D:
+		if child, ok := lookup(v, path[0]); ok {
+			return fieldPath(child, path[1:])
+		}
+	case bson.A:
+		ret := bson.A{}
+		for _, item := range v {
+			if r, ok := fieldPath(item, path); ok {
+				ret = append(ret, r)
+			}
+		}
+		return ret, true
+	}
+	return nil, false
+}
+
+// 计算聚合表达式: "$path", 对象, 数组, 常量及常用操作符
+func evalExpr(doc bson.D, expr interface{}) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$$") {
+			if e == "$$ROOT" || e == "$$CURRENT" {
+				return doc, nil
+			}
+			if strings.HasPrefix(e, "$$ROOT.") {
+				v, _ := fieldPath(doc, splitPath(e[len("$$ROOT."):]))
+				return v, nil
+			}
+			return nil, fmt.Errorf("use of undefined variable: %v", e)
+		}
+		if strings.HasPrefix(e, "$") {
+			v, _ := fieldPath(doc, splitPath(e[1:]))
+			return v, nil
+		}
+		return e, nil
+	case bson.A:
+		ret := make(bson.A, 0, len(e))
+		for _, item := range e {
+			v, err := evalExpr(doc, item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	case bson.D:
+		if len(e) == 1 && strings.HasPrefix(e[0].Key, "$") {
+			return evalOp(doc, e[0].Key, e[0].Value)
+		}
+		ret := make(bson.D, 0, len(e))
+		for _, item := range e {
+			v, err := evalExpr(doc, item.Value)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, bson.E{Key: item.Key, Value: v})
+		}
+		return ret, nil
+	}
+	return expr, nil
+}
+
+func evalArgs(doc bson.D, arg interface{}) (bson.A, error) {
+	if arr, ok := arg.(bson.A); ok {
+		v, err := evalExpr(doc, arr)
+		if err != nil {
+			return nil, err
+		}
+		return v.(bson.A), nil
+	}
+	v, err := evalExpr(doc, arg)
+	if err != nil {
+		return nil, err
+	}
+	return bson.A{v}, nil
+}
+
+func evalOp(doc bson.D, op string, arg interface{}) (interface{}, error) {
+	if op == "$literal" {
+		return arg, nil
+	}
+	args, err := evalArgs(doc, arg)
+	if err != nil {
+		return nil, err
+	}
+	want := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("expression %v takes exactly %v arguments. %v were passed in", op, n, len(args))
+		}
+		return nil
+	}
+	switch op {
+	case "$add", "$multiply":
+		var ret interface{} = int32(0)
+		if op == "$multiply" {
+			ret = int32(1)
+		}
+		for _, v := range args {
+			if v == nil {
+				return nil, nil
+			}
+			if !isNumber(v) {
+				return nil, fmt.Errorf("%v only supports numeric types, not %T", op, v)
+			}
+			if op == "$add" {
+				ret = arith(ret, v, func(a, b int64) int64 { return a + b }, func(a, b float64) float64 { return a + b })
+			} else {
+				ret = arith(ret, v, func(a, b int64) int64 { return a * b }, func(a, b float64) float64 { return a * b })
+			}
+		}
+		return ret, nil
+	case "$subtract", "$divide":
+		if err := want(2); err != nil {
+			return nil, err
+		}
+		if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		if !isNumber(args[0]) || !isNumber(args[1]) {
+			return nil, fmt.Errorf("%v only supports numeric types", op)
+		}
+		if op == "$subtract" {
+			return arith(args[0], args[1], func(a, b int64) int64 { return a - b }, func(a, b float64) float64 { return a - b }), nil
+		}
+		if toFloat(args[1]) == 0 {
+			return nil, fmt.Errorf("can't $divide by zero")
+		}
+		return toFloat(args[0]) / toFloat(args[1]), nil
+	case "$concat":
+		var sb strings.Builder
+		for _, v := range args {
+			if v == nil {
+				return nil, nil
+			}
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("$concat only supports strings, not %T", v)
+			}
+			sb.WriteString(s)
+		}
+		return sb.String(), nil
+	case "$ifNull":
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	case "$size":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		arr, ok := args[0].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("the argument to $size must be an array, but was of type: %T", args[0])
+		}
+		return int32(len(arr)), nil
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$cmp":
+		if err := want(2); err != nil {
+			return nil, err
+		}
+		c := compare(args[0], args[1])
+		switch op {
+		case "$eq":
+			return c == 0, nil
+		case "$ne":
+			return c != 0, nil
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		case "$lte":
+			return c <= 0, nil
+		}
+		return int32(c), nil
+	case "$and":
+		for _, v := range args {
+			if !truthy(v) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$or":
+		for _, v := range args {
+			if truthy(v) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$not":
+		if err := want(1); err != nil {
+			return nil, err
+		}
+		return !truthy(args[0]), nil
+	case "$cond":
+		if d, ok := arg.(bson.D); ok {
+			c, _ := lookup(d, "if")
+			t, _ := lookup(d, "then")
+			f, _ := lookup(d, "else")
+			if args, err = evalArgs(doc, bson.A{c, t, f}); err != nil {
+				return nil, err
+			}
+		}
+		if err := want(3); err != nil {
+			return nil, err
+		}
+		if truthy(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	}
+	return nil, fmt.Errorf("unrecognized expression '%v'", op)
+}
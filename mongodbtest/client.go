@@ -0,0 +1,714 @@
+package mongodbtest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/obase/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"strings"
+)
+
+// mongodb.Helper的内存实现, 用于无需mongod的单元测试. 文档以BSON保存, 错误与not语义同mongodb.Client
+type Client struct {
+	DB    string
+	store *store
+}
+
+var _ mongodb.Helper = (*Client)(nil)
+
+// 创建内存客户端, db为默认数据库
+func NewClient(db string) *Client {
+	return &Client{DB: db, store: newStore()}
+}
+
+func wrapError(db, cl, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &mongodb.Error{Database: db, Collection: cl, Op: op, Err: err}
+}
+
+// 读及findAndModify命令的错误
+func commandError(err error) error {
+	if ce, ok := err.(*codeError); ok {
+		return mongo.CommandError{Code: ce.Code, Name: ce.Name, Message: ce.Message}
+	}
+	return err
+}
+
+// 单文档写入的错误
+func writeException(err error) error {
+	if ce, ok := err.(*codeError); ok {
+		return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Index: 0, Code: int(ce.Code), Message: ce.Message}}}
+	}
+	return err
+}
+
+// 与驱动一致: 参数文档不能为nil
+func toDoc(v interface{}) (bson.D, error) {
+	if v == nil {
+		return nil, mongo.ErrNilDocument
+	}
+	return toD(v)
+}
+
+func toUpdate(v interface{}) (bson.D, error) {
+	d, err := toDoc(v)
+	if err == nil && !isUpdateDoc(d) {
+		err = errors.New("update document must contain key beginning with '$'")
+	}
+	return d, err
+}
+
+func toReplace(v interface{}) (bson.D, error) {
+	d, err := toDoc(v)
+	if err == nil && len(d) > 0 && strings.HasPrefix(d[0].Key, "$") {
+		err = errors.New("replacement document cannot contain keys beginning with '$'")
+	}
+	return d, err
+}
+
+// 可选参数(sort, projection), nil表示未设置
+func toOption(v interface{}) (bson.D, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return toD(v)
+}
+
+func decodeOne(doc bson.D, ret interface{}) error {
+	if ret == nil {
+		return nil
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, ret)
+}
+
+// 与Cursor.All一致: ret必须为切片指针
+func decodeAll(docs []bson.D, ret interface{}) error {
+	rv := reflect.ValueOf(ret)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("results argument must be a pointer to a slice")
+	}
+	sv := rv.Elem()
+	et := sv.Type().Elem()
+	out := reflect.MakeSlice(sv.Type(), 0, len(docs))
+	for _, doc := range docs {
+		ev := reflect.New(et)
+		if err := decodeOne(doc, ev.Interface()); err != nil {
+			return err
+		}
+		out = reflect.Append(out, ev.Elem())
+	}
+	sv.Set(out)
+	return nil
+}
+
+// 删除全部数据, 用于测试用例之间的隔离
+func (cc *Client) Reset() {
+	for _, db := range cc.store.databaseNames() {
+		cc.store.drop(db, "")
+	}
+}
+
+func (cc *Client) ListCollectionNames(filters ...interface{}) ([]string, error) {
+	return cc.DBListCollectionNames(cc.DB, filters...)
+}
+
+func (cc *Client) Count(cl string, filters ...interface{}) (ret int64, err error) {
+	return cc.DBCount(cc.DB, cl, filters...)
+}
+
+func (cc *Client) FindId(cl string, id interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
+	return cc.DBFindId(cc.DB, cl, id, ret, opts...)
+}
+
+func (cc *Client) FindOne(cl string, filter interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
+	return cc.DBFindOne(cc.DB, cl, filter, ret, opts...)
+}
+
+func (cc *Client) Find(cl string, filter interface{}, ret interface{}, opts ...*options.FindOptions) (err error) {
+	return cc.DBFind(cc.DB, cl, filter, ret, opts...)
+}
+
+func (cc *Client) FindPage(cl string, filter interface{}, page int64, size int64, sort interface{}, ret interface{}, opts ...*mongodb.PageOptions) (*mongodb.Page, error) {
+	return cc.DBFindPage(cc.DB, cl, filter, page, size, sort, ret, opts...)
+}
+
+func (cc *Client) Distinct(cl string, fieldName string, filter interface{}, opts ...*options.DistinctOptions) (ret []interface{}, err error) {
+	return cc.DBDistinct(cc.DB, cl, fieldName, filter, opts...)
+}
+
+func (cc *Client) FindIdAndUpdate(cl string, id interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
+	return cc.DBFindIdAndUpdate(cc.DB, cl, id, update, ret, opts...)
+}
+
+func (cc *Client) FindIdAndReplace(cl string, id interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
+	return cc.DBFindIdAndReplace(cc.DB, cl, id, replace, ret, opts...)
+}
+
+func (cc *Client) FindIdAndDelete(cl string, id interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
+	return cc.DBFindIdAndDelete(cc.DB, cl, id, ret, opts...)
+}
+
+func (cc *Client) FindOneAndUpdate(cl string, filter interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
+	return cc.DBFindOneAndUpdate(cc.DB, cl, filter, update, ret, opts...)
+}
+
+func (cc *Client) FindOneAndReplace(cl string, filter interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
+	return cc.DBFindOneAndReplace(cc.DB, cl, filter, replace, ret, opts...)
+}
+
+func (cc *Client) FindOneAndDelete(cl string, filter interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
+	return cc.DBFindOneAndDelete(cc.DB, cl, filter, ret, opts...)
+}
+
+func (cc *Client) InsertOne(cl string, doc interface{}, opts ...*options.InsertOneOptions) (result *mongo.InsertOneResult, err error) {
+	return cc.DBInsertOne(cc.DB, cl, doc, opts...)
+}
+
+func (cc *Client) InsertMany(cl string, docs []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error) {
+	return cc.DBInsertMany(cc.DB, cl, docs, opts...)
+}
+
+func (cc *Client) ReplaceId(cl string, id interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
+	return cc.DBReplaceId(cc.DB, cl, id, replace, opts...)
+}
+
+func (cc *Client) ReplaceOne(cl string, filter interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
+	return cc.DBReplaceOne(cc.DB, cl, filter, replace, opts...)
+}
+
+func (cc *Client) UpdateId(cl string, id interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	return cc.DBUpdateId(cc.DB, cl, id, update, opts...)
+}
+
+func (cc *Client) UpdateOne(cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	return cc.DBUpdateOne(cc.DB, cl, filter, update, opts...)
+}
+
+func (cc *Client) UpdateMany(cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	return cc.DBUpdateMany(cc.DB, cl, filter, update, opts...)
+}
+
+func (cc *Client) DeleteId(cl string, id interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	return cc.DBDeleteId(cc.DB, cl, id, opts...)
+}
+
+func (cc *Client) DeleteOne(cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	return cc.DBDeleteOne(cc.DB, cl, filter, opts...)
+}
+
+func (cc *Client) DeleteMany(cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	return cc.DBDeleteMany(cc.DB, cl, filter, opts...)
+}
+
+func (cc *Client) Aggregate(cl string, pipeline interface{}, ret interface{}, opts ...*options.AggregateOptions) (err error) {
+	return cc.DBAggregate(cc.DB, cl, pipeline, ret, opts...)
+}
+
+func (cc *Client) BulkWrite(cl string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error) {
+	return cc.DBBulkWrite(cc.DB, cl, models, opts...)
+}
+
+func (cc *Client) DBListCollectionNames(db string, filters ...interface{}) (ret []string, err error) {
+	var filter interface{} = mongodb.ALL
+	if len(filters) > 0 {
+		filter = filters[0]
+	}
+	f, err := toDoc(filter)
+	if err == nil {
+		for _, cl := range cc.store.collectionNames(db) {
+			var ok bool
+			if ok, err = match(bson.D{{Key: "name", Value: cl}, {Key: "type", Value: "collection"}}, f); err != nil {
+				break
+			} else if ok {
+				ret = append(ret, cl)
+			}
+		}
+	}
+	return ret, wrapError(db, "", mongodb.Op_ListCollectionNames, err)
+}
+
+func (cc *Client) DBCount(db string, cl string, filters ...interface{}) (ret int64, err error) {
+	var filter bson.D
+	if len(filters) > 0 && filters[0] != nil {
+		if filter, err = toD(filters[0]); err != nil {
+			return 0, wrapError(db, cl, mongodb.Op_Count, err)
+		}
+	}
+	docs, err := cc.store.find(db, cl, &query{Filter: filter})
+	return int64(len(docs)), wrapError(db, cl, mongodb.Op_Count, commandError(err))
+}
+
+func (cc *Client) findOne(db string, cl string, filter interface{}, ret interface{}, opts []*options.FindOneOptions) (not bool, err error) {
+	q := &query{Limit: 1}
+	if q.Filter, err = toDoc(filter); err != nil {
+		return
+	}
+	opt := options.MergeFindOneOptions(opts...)
+	if q.Sort, err = toOption(opt.Sort); err != nil {
+		return
+	}
+	if q.Projection, err = toOption(opt.Projection); err != nil {
+		return
+	}
+	if opt.Skip != nil {
+		q.Skip = *opt.Skip
+	}
+	docs, err := cc.store.find(db, cl, q)
+	if err != nil {
+		return false, commandError(err)
+	}
+	if len(docs) == 0 {
+		return true, nil
+	}
+	return false, decodeOne(docs[0], ret)
+}
+
+func (cc *Client) DBFindId(db string, cl string, id interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
+	not, err = cc.findOne(db, cl, bson.M{"_id": id}, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindId, err)
+}
+
+func (cc *Client) DBFindOne(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOneOptions) (not bool, err error) {
+	not, err = cc.findOne(db, cl, filter, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindOne, err)
+}
+
+func (cc *Client) find(db string, cl string, filter interface{}, ret interface{}, opts []*options.FindOptions) (err error) {
+	q := &query{}
+	if q.Filter, err = toDoc(filter); err != nil {
+		return
+	}
+	opt := options.MergeFindOptions(opts...)
+	if q.Sort, err = toOption(opt.Sort); err != nil {
+		return
+	}
+	if q.Projection, err = toOption(opt.Projection); err != nil {
+		return
+	}
+	if opt.Skip != nil {
+		q.Skip = *opt.Skip
+	}
+	if opt.Limit != nil {
+		if q.Limit = *opt.Limit; q.Limit < 0 {
+			q.Limit = -q.Limit
+		}
+	}
+	docs, err := cc.store.find(db, cl, q)
+	if err != nil {
+		return commandError(err)
+	}
+	return decodeAll(docs, ret)
+}
+
+func (cc *Client) DBFind(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOptions) (err error) {
+	return wrapError(db, cl, mongodb.Op_Find, cc.find(db, cl, filter, ret, opts))
+}
+
+// 与mongodb.Client一致, 每页最大条数为mongodb.DefaultMaxPageSize
+func (cc *Client) DBFindPage(db string, cl string, filter interface{}, page int64, size int64, sort interface{}, ret interface{}, opts ...*mongodb.PageOptions) (*mongodb.Page, error) {
+	result := &mongodb.Page{Page: page, Size: size, Total: -1, Pages: -1}
+	if result.Page < 1 {
+		result.Page = 1
+	}
+	if result.Size <= 0 {
+		result.Size = mongodb.DefaultPageSize
+	}
+	if result.Size > mongodb.DefaultMaxPageSize {
+		result.Size = mongodb.DefaultMaxPageSize
+	}
+	opt := options.Find().SetSkip((result.Page - 1) * result.Size).SetLimit(result.Size)
+	noCount := false
+	for _, o := range opts {
+		if o != nil {
+			noCount = noCount || o.NoCount
+			if o.Projection != nil {
+				opt.SetProjection(o.Projection)
+			}
+		}
+	}
+	if sort != nil {
+		opt.SetSort(sort)
+	}
+	if filter == nil {
+		filter = mongodb.ALL
+	}
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, wrapError(db, cl, mongodb.Op_FindPage, err)
+	}
+	docs, err := cc.store.find(db, cl, &query{Filter: f})
+	if err != nil {
+		return nil, wrapError(db, cl, mongodb.Op_FindPage, commandError(err))
+	}
+	if err = cc.find(db, cl, f, ret, []*options.FindOptions{opt}); err != nil {
+		return nil, wrapError(db, cl, mongodb.Op_FindPage, err)
+	}
+	total := int64(len(docs))
+	if noCount {
+		result.HasNext = result.Page*result.Size < total
+	} else {
+		result.Total = total
+		result.Pages = (total + result.Size - 1) / result.Size
+		result.HasNext = result.Page < result.Pages
+	}
+	return result, nil
+}
+
+func (cc *Client) DBDistinct(db string, cl string, fieldName string, filter interface{}, opts ...*options.DistinctOptions) (ret []interface{}, err error) {
+	f, err := toDoc(filter)
+	if err == nil {
+		var vals bson.A
+		if vals, err = cc.store.distinct(db, cl, fieldName, f); err == nil {
+			ret = []interface{}(vals)
+		}
+	}
+	return ret, wrapError(db, cl, mongodb.Op_Distinct, commandError(err))
+}
+
+// findAndModify的公共实现, not表示未找到文档(upsert且返回修改前文档时同样为not)
+func (cc *Client) findAndModify(db string, cl string, filter interface{}, f *findAndModify, ret interface{}) (not bool, err error) {
+	if f.Query, err = toDoc(filter); err != nil {
+		return
+	}
+	value, _, err := cc.store.findAndModify(db, cl, f)
+	if err != nil {
+		return false, commandError(err)
+	}
+	if value == nil {
+		return true, nil
+	}
+	return false, decodeOne(value, ret)
+}
+
+func (cc *Client) findOneAndUpdate(db string, cl string, filter interface{}, update interface{}, ret interface{}, opts []*options.FindOneAndUpdateOptions) (not bool, err error) {
+	f := &findAndModify{}
+	if f.Update, err = toUpdate(update); err != nil {
+		return
+	}
+	opt := options.MergeFindOneAndUpdateOptions(opts...)
+	if f.Sort, err = toOption(opt.Sort); err != nil {
+		return
+	}
+	if f.Fields, err = toOption(opt.Projection); err != nil {
+		return
+	}
+	f.New = opt.ReturnDocument != nil && *opt.ReturnDocument == options.After
+	f.Upsert = opt.Upsert != nil && *opt.Upsert
+	return cc.findAndModify(db, cl, filter, f, ret)
+}
+
+func (cc *Client) findOneAndReplace(db string, cl string, filter interface{}, replace interface{}, ret interface{}, opts []*options.FindOneAndReplaceOptions) (not bool, err error) {
+	f := &findAndModify{}
+	if f.Update, err = toReplace(replace); err != nil {
+		return
+	}
+	opt := options.MergeFindOneAndReplaceOptions(opts...)
+	if f.Sort, err = toOption(opt.Sort); err != nil {
+		return
+	}
+	if f.Fields, err = toOption(opt.Projection); err != nil {
+		return
+	}
+	f.New = opt.ReturnDocument != nil && *opt.ReturnDocument == options.After
+	f.Upsert = opt.Upsert != nil && *opt.Upsert
+	return cc.findAndModify(db, cl, filter, f, ret)
+}
+
+func (cc *Client) findOneAndDelete(db string, cl string, filter interface{}, ret interface{}, opts []*options.FindOneAndDeleteOptions) (not bool, err error) {
+	f := &findAndModify{Remove: true}
+	opt := options.MergeFindOneAndDeleteOptions(opts...)
+	if f.Sort, err = toOption(opt.Sort); err != nil {
+		return
+	}
+	if f.Fields, err = toOption(opt.Projection); err != nil {
+		return
+	}
+	return cc.findAndModify(db, cl, filter, f, ret)
+}
+
+func (cc *Client) DBFindIdAndUpdate(db string, cl string, id interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
+	not, err = cc.findOneAndUpdate(db, cl, bson.M{"_id": id}, update, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindIdAndUpdate, err)
+}
+
+func (cc *Client) DBFindIdAndReplace(db string, cl string, id interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
+	not, err = cc.findOneAndReplace(db, cl, bson.M{"_id": id}, replace, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindIdAndReplace, err)
+}
+
+func (cc *Client) DBFindIdAndDelete(db string, cl string, id interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
+	not, err = cc.findOneAndDelete(db, cl, bson.M{"_id": id}, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindIdAndDelete, err)
+}
+
+func (cc *Client) DBFindOneAndUpdate(db string, cl string, filter interface{}, update interface{}, ret interface{}, opts ...*options.FindOneAndUpdateOptions) (not bool, err error) {
+	not, err = cc.findOneAndUpdate(db, cl, filter, update, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindOneAndUpdate, err)
+}
+
+func (cc *Client) DBFindOneAndReplace(db string, cl string, filter interface{}, replace interface{}, ret interface{}, opts ...*options.FindOneAndReplaceOptions) (not bool, err error) {
+	not, err = cc.findOneAndReplace(db, cl, filter, replace, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindOneAndReplace, err)
+}
+
+func (cc *Client) DBFindOneAndDelete(db string, cl string, filter interface{}, ret interface{}, opts ...*options.FindOneAndDeleteOptions) (not bool, err error) {
+	not, err = cc.findOneAndDelete(db, cl, filter, ret, opts)
+	return not, wrapError(db, cl, mongodb.Op_FindOneAndDelete, err)
+}
+
+func (cc *Client) DBInsertOne(db string, cl string, doc interface{}, opts ...*options.InsertOneOptions) (result *mongo.InsertOneResult, err error) {
+	d, err := toDoc(doc)
+	if err != nil {
+		return nil, wrapError(db, cl, mongodb.Op_InsertOne, err)
+	}
+	ids, errs := cc.store.insert(db, cl, []bson.D{d}, true)
+	if len(errs) > 0 {
+		return nil, wrapError(db, cl, mongodb.Op_InsertOne, writeException(errs[0].codeError))
+	}
+	return &mongo.InsertOneResult{InsertedID: ids[0]}, nil
+}
+
+func bulkWriteException(errs []*writeError, models []mongo.WriteModel) error {
+	ret := mongo.BulkWriteException{}
+	for _, we := range errs {
+		bwe := mongo.BulkWriteError{WriteError: mongo.WriteError{Index: we.Index, Code: int(we.Code), Message: we.Message}}
+		if we.Index < len(models) {
+			bwe.Request = models[we.Index]
+		}
+		ret.WriteErrors = append(ret.WriteErrors, bwe)
+	}
+	return ret
+}
+
+func (cc *Client) DBInsertMany(db string, cl string, docs []interface{}, opts ...*options.InsertManyOptions) (result *mongo.InsertManyResult, err error) {
+	if len(docs) == 0 {
+		return nil, wrapError(db, cl, mongodb.Op_InsertMany, mongo.ErrEmptySlice)
+	}
+	ds := make([]bson.D, 0, len(docs))
+	ids := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		d, err := toDoc(doc)
+		if err != nil {
+			return nil, wrapError(db, cl, mongodb.Op_InsertMany, err)
+		}
+		d, id := ensureId(d) // 与驱动一致, 先生成全部_id
+		ds = append(ds, d)
+		ids = append(ids, id)
+	}
+	opt := options.MergeInsertManyOptions(opts...)
+	_, errs := cc.store.insert(db, cl, ds, opt.Ordered == nil || *opt.Ordered)
+	result = &mongo.InsertManyResult{InsertedIDs: ids}
+	if len(errs) > 0 {
+		models := make([]mongo.WriteModel, 0, len(docs))
+		for _, doc := range docs {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		}
+		err = wrapError(db, cl, mongodb.Op_InsertMany, bulkWriteException(errs, models))
+	}
+	return
+}
+
+func (cc *Client) update(db string, cl string, filter interface{}, u *updateSpec) (result *mongo.UpdateResult, err error) {
+	if u.Filter, err = toDoc(filter); err != nil {
+		return
+	}
+	r, err := cc.store.update(db, cl, u)
+	if err != nil {
+		return nil, writeException(err)
+	}
+	result = &mongo.UpdateResult{MatchedCount: r.Matched, ModifiedCount: r.Modified, UpsertedID: r.UpsertedId}
+	if r.UpsertedId != nil {
+		result.UpsertedCount = 1
+	}
+	return
+}
+
+func (cc *Client) replaceOne(db string, cl string, filter interface{}, replace interface{}, opts []*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
+	u := &updateSpec{}
+	if u.Update, err = toReplace(replace); err != nil {
+		return
+	}
+	opt := options.MergeReplaceOptions(opts...)
+	u.Upsert = opt.Upsert != nil && *opt.Upsert
+	return cc.update(db, cl, filter, u)
+}
+
+func (cc *Client) updateOne(db string, cl string, filter interface{}, update interface{}, multi bool, opts []*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	u := &updateSpec{Multi: multi}
+	if u.Update, err = toUpdate(update); err != nil {
+		return
+	}
+	opt := options.MergeUpdateOptions(opts...)
+	u.Upsert = opt.Upsert != nil && *opt.Upsert
+	return cc.update(db, cl, filter, u)
+}
+
+func (cc *Client) DBReplaceId(db string, cl string, id interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
+	result, err = cc.replaceOne(db, cl, bson.M{"_id": id}, replace, opts)
+	return result, wrapError(db, cl, mongodb.Op_ReplaceId, err)
+}
+
+func (cc *Client) DBReplaceOne(db string, cl string, filter interface{}, replace interface{}, opts ...*options.ReplaceOptions) (result *mongo.UpdateResult, err error) {
+	result, err = cc.replaceOne(db, cl, filter, replace, opts)
+	return result, wrapError(db, cl, mongodb.Op_ReplaceOne, err)
+}
+
+func (cc *Client) DBUpdateId(db string, cl string, id interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	result, err = cc.updateOne(db, cl, bson.M{"_id": id}, update, false, opts)
+	return result, wrapError(db, cl, mongodb.Op_UpdateId, err)
+}
+
+func (cc *Client) DBUpdateOne(db string, cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	result, err = cc.updateOne(db, cl, filter, update, false, opts)
+	return result, wrapError(db, cl, mongodb.Op_UpdateOne, err)
+}
+
+func (cc *Client) DBUpdateMany(db string, cl string, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (result *mongo.UpdateResult, err error) {
+	result, err = cc.updateOne(db, cl, filter, update, true, opts)
+	return result, wrapError(db, cl, mongodb.Op_UpdateMany, err)
+}
+
+func (cc *Client) delete(db string, cl string, filter interface{}, multi bool) (result *mongo.DeleteResult, err error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return
+	}
+	n, err := cc.store.delete(db, cl, f, multi)
+	if err != nil {
+		return nil, writeException(err)
+	}
+	return &mongo.DeleteResult{DeletedCount: n}, nil
+}
+
+func (cc *Client) DBDeleteId(db string, cl string, id interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	result, err = cc.delete(db, cl, bson.M{"_id": id}, false)
+	return result, wrapError(db, cl, mongodb.Op_DeleteId, err)
+}
+
+func (cc *Client) DBDeleteOne(db string, cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	result, err = cc.delete(db, cl, filter, false)
+	return result, wrapError(db, cl, mongodb.Op_DeleteOne, err)
+}
+
+// 必须注意: empty filter会删除整个集合数据
+func (cc *Client) DBDeleteMany(db string, cl string, filter interface{}, opts ...*options.DeleteOptions) (result *mongo.DeleteResult, err error) {
+	result, err = cc.delete(db, cl, filter, true)
+	return result, wrapError(db, cl, mongodb.Op_DeleteMany, err)
+}
+
+func (cc *Client) DBAggregate(db string, cl string, pipeline interface{}, ret interface{}, opts ...*options.AggregateOptions) (err error) {
+	stages, err := toDs(pipeline)
+	if err == nil {
+		var docs []bson.D
+		if docs, err = cc.store.aggregate(db, cl, stages); err == nil {
+			err = decodeAll(docs, ret)
+		}
+	}
+	return wrapError(db, cl, mongodb.Op_Aggregate, commandError(err))
+}
+
+// 与mongodb.Client一致, models为空时返回空结果
+func (cc *Client) DBBulkWrite(db string, cl string, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (result *mongo.BulkWriteResult, err error) {
+	if len(models) == 0 {
+		return &mongo.BulkWriteResult{UpsertedIDs: make(map[int64]interface{})}, nil
+	}
+	opt := options.MergeBulkWriteOptions(opts...)
+	ordered := opt.Ordered == nil || *opt.Ordered
+	result = &mongo.BulkWriteResult{UpsertedIDs: make(map[int64]interface{})}
+	var errs []*writeError
+	for i, model := range models {
+		if err = cc.bulkWriteOne(db, cl, model, result, int64(i)); err != nil {
+			if ce, ok := err.(*codeError); ok {
+				errs = append(errs, &writeError{Index: i, codeError: ce})
+				if ordered {
+					break
+				}
+				continue
+			}
+			return nil, wrapError(db, cl, mongodb.Op_BulkWrite, err)
+		}
+	}
+	if len(errs) > 0 {
+		return result, wrapError(db, cl, mongodb.Op_BulkWrite, bulkWriteException(errs, models))
+	}
+	return result, nil
+}
+
+func upsert(v *bool) bool {
+	return v != nil && *v
+}
+
+// 执行单个WriteModel并累计结果, 服务端错误以*codeError返回
+func (cc *Client) bulkWriteOne(db string, cl string, model mongo.WriteModel, result *mongo.BulkWriteResult, idx int64) (err error) {
+	var u *updateSpec
+	var filter interface{}
+	switch m := model.(type) {
+	case *mongo.InsertOneModel:
+		d, err := toDoc(m.Document)
+		if err != nil {
+			return err
+		}
+		if _, errs := cc.store.insert(db, cl, []bson.D{d}, true); len(errs) > 0 {
+			return errs[0].codeError
+		}
+		result.InsertedCount++
+		return nil
+	case *mongo.DeleteOneModel, *mongo.DeleteManyModel:
+		multi := false
+		if dm, ok := m.(*mongo.DeleteManyModel); ok {
+			filter, multi = dm.Filter, true
+		} else {
+			filter = m.(*mongo.DeleteOneModel).Filter
+		}
+		f, err := toDoc(filter)
+		if err != nil {
+			return err
+		}
+		n, err := cc.store.delete(db, cl, f, multi)
+		result.DeletedCount += n
+		return err
+	case *mongo.ReplaceOneModel:
+		u = &updateSpec{Upsert: upsert(m.Upsert)}
+		filter = m.Filter
+		if u.Update, err = toReplace(m.Replacement); err != nil {
+			return
+		}
+	case *mongo.UpdateOneModel:
+		u = &updateSpec{Upsert: upsert(m.Upsert)}
+		filter = m.Filter
+		if u.Update, err = toUpdate(m.Update); err != nil {
+			return
+		}
+	case *mongo.UpdateManyModel:
+		u = &updateSpec{Upsert: upsert(m.Upsert), Multi: true}
+		filter = m.Filter
+		if u.Update, err = toUpdate(m.Update); err != nil {
+			return
+		}
+	default:
+		return fmt.Errorf("unsupported write model %T", model)
+	}
+	if u.Filter, err = toDoc(filter); err != nil {
+		return
+	}
+	r, err := cc.store.update(db, cl, u)
+	if err != nil {
+		return err
+	}
+	result.MatchedCount += r.Matched
+	result.ModifiedCount += r.Modified
+	if r.UpsertedId != nil {
+		result.UpsertedCount++
+		result.UpsertedIDs[idx] = r.UpsertedId
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package mongodbtest
+
+import (
+	"github.com/obase/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+type user struct {
+	Id   string   `bson:"_id"`
+	Name string   `bson:"name"`
+	Age  int      `bson:"age"`
+	Tags []string `bson:"tags,omitempty"`
+	Addr *addr    `bson:"addr,omitempty"`
+}
+
+type addr struct {
+	City string `bson:"city"`
+}
+
+func seed(t *testing.T) *Client {
+	cc := NewClient("test")
+	_, err := cc.InsertMany("user", []interface{}{
+		&user{Id: "u1", Name: "tom", Age: 20, Tags: []string{"a", "b"}, Addr: &addr{City: "gz"}},
+		&user{Id: "u2", Name: "jack", Age: 30, Tags: []string{"b"}, Addr: &addr{City: "sz"}},
+		&user{Id: "u3", Name: "lucy", Age: 25},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestFind(t *testing.T) {
+	cc := seed(t)
+	cases := []struct {
+		filter interface{}
+		ids    []string
+	}{
+		{bson.M{"age": bson.M{"$gt": 20}}, []string{"u2", "u3"}},
+		{bson.M{"_id": bson.M{"$in": []string{"u1", "u3"}}}, []string{"u1", "u3"}},
+		{bson.M{"tags": "b"}, []string{"u1", "u2"}},
+		{bson.M{"addr.city": "sz"}, []string{"u2"}},
+		{bson.M{"addr": bson.M{"$exists": false}}, []string{"u3"}},
+		{bson.M{"$or": []bson.M{{"name": "tom"}, {"age": 25}}}, []string{"u1", "u3"}},
+		{bson.M{"$and": []bson.M{{"age": bson.M{"$gte": 20}}, {"age": bson.M{"$lt": 30}}}}, []string{"u1", "u3"}},
+		{bson.M{"name": bson.M{"$eq": "nobody"}}, nil},
+	}
+	for _, c := range cases {
+		var ret []*user
+		if err := cc.Find("user", c.filter, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if len(ret) != len(c.ids) {
+			t.Fatalf("%v: expect %v but %v", c.filter, c.ids, len(ret))
+		}
+		for i, u := range ret {
+			if u.Id != c.ids[i] {
+				t.Fatalf("%v: expect %v but %v", c.filter, c.ids, u.Id)
+			}
+		}
+	}
+}
+
+func TestFindOptions(t *testing.T) {
+	cc := seed(t)
+	var ret []bson.M
+	opt := options.Find().SetSort(bson.D{{Key: "age", Value: -1}}).SetSkip(1).SetLimit(1).SetProjection(bson.M{"name": 1})
+	if err := cc.Find("user", mongodb.ALL, &ret, opt); err != nil {
+		t.Fatal(err)
+	}
+	if len(ret) != 1 || ret[0]["name"] != "lucy" || ret[0]["age"] != nil || ret[0]["_id"] != "u3" {
+		t.Fatalf("unexpected: %v", ret)
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	cc := seed(t)
+	var ret []*user
+	page, err := cc.FindPage("user", nil, 2, 2, bson.M{"age": 1}, &ret)
+	if err != nil || page.Total != 3 || page.Pages != 2 || page.HasNext || len(ret) != 1 || ret[0].Id != "u2" {
+		t.Fatalf("unexpected: %+v %v %v", page, ret, err)
+	}
+	page, err = cc.FindPage("user", nil, 1, 2, bson.M{"age": 1}, &ret, &mongodb.PageOptions{NoCount: true})
+	if err != nil || page.Total != -1 || !page.HasNext || len(ret) != 2 {
+		t.Fatalf("unexpected: %+v %v %v", page, ret, err)
+	}
+}
+
+func TestFindIdNotFound(t *testing.T) {
+	cc := seed(t)
+	u := &user{}
+	not, err := cc.FindId("user", "u1", u)
+	if err != nil || not || u.Name != "tom" {
+		t.Fatalf("unexpected: %v %v %v", not, err, u)
+	}
+	not, err = cc.FindId("user", "none", u)
+	if err != nil || !not {
+		t.Fatalf("unexpected: %v %v", not, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	cc := seed(t)
+	ret, err := cc.UpdateId("user", "u1", bson.M{
+		"$set":   bson.M{"addr.city": "bj"},
+		"$inc":   bson.M{"age": 1},
+		"$push":  bson.M{"tags": "c"},
+		"$unset": bson.M{"name": ""},
+	})
+	if err != nil || ret.MatchedCount != 1 || ret.ModifiedCount != 1 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	u := &user{}
+	cc.FindId("user", "u1", u)
+	if u.Age != 21 || u.Addr.City != "bj" || len(u.Tags) != 3 || u.Tags[2] != "c" || u.Name != "" {
+		t.Fatalf("unexpected: %+v", u)
+	}
+
+	ret, err = cc.UpdateOne("user", bson.M{"name": "lily"}, bson.M{"$set": bson.M{"age": 18}}, options.Update().SetUpsert(true))
+	if err != nil || ret.UpsertedCount != 1 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	if not, _ := cc.FindOne("user", bson.M{"name": "lily", "age": 18}, nil); not {
+		t.Fatal("upsert document not found")
+	}
+
+	ret, err = cc.UpdateMany("user", bson.M{"age": bson.M{"$gte": 25}}, bson.M{"$set": bson.M{"old": true}})
+	if err != nil || ret.MatchedCount != 2 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+}
+
+func TestFindOneAndUpdate(t *testing.T) {
+	cc := seed(t)
+	u := &user{}
+	not, err := cc.FindIdAndUpdate("user", "u2", bson.M{"$inc": bson.M{"age": 5}}, u, options.FindOneAndUpdate().SetReturnDocument(options.After))
+	if err != nil || not || u.Age != 35 {
+		t.Fatalf("unexpected: %v %v %v", not, err, u)
+	}
+	not, err = cc.FindIdAndUpdate("user", "none", bson.M{"$inc": bson.M{"age": 5}}, u)
+	if err != nil || !not {
+		t.Fatalf("unexpected: %v %v", not, err)
+	}
+	not, err = cc.FindIdAndDelete("user", "u2", u)
+	if err != nil || not {
+		t.Fatalf("unexpected: %v %v", not, err)
+	}
+	if n, _ := cc.Count("user"); n != 2 {
+		t.Fatalf("unexpected count: %v", n)
+	}
+}
+
+func TestDuplicateKey(t *testing.T) {
+	cc := seed(t)
+	_, err := cc.InsertOne("user", &user{Id: "u1"})
+	if !mongodb.IsDuplicateKey(err) {
+		t.Fatalf("expect duplicate key but %v", err)
+	}
+	if dk, ok := mongodb.GetDuplicateKey(err); !ok || dk.Index != "_id_" || dk.Key != `{ _id: "u1" }` {
+		t.Fatalf("unexpected: %+v", dk)
+	}
+	ret, err := cc.InsertMany("user", []interface{}{&user{Id: "u4"}, &user{Id: "u2"}, &user{Id: "u5"}}, options.InsertMany().SetOrdered(false))
+	if dks := mongodb.GetDuplicateKeys(err); len(dks) != 1 || dks[0].Pos != 1 || len(ret.InsertedIDs) != 3 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	if n, _ := cc.Count("user"); n != 5 {
+		t.Fatalf("unexpected count: %v", n)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	cc := seed(t)
+	var ret []bson.M
+	err := cc.Aggregate("user", mongo.Pipeline{
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$tags"}, {Key: "n", Value: bson.M{"$sum": 1}}, {Key: "age", Value: bson.M{"$max": "$age"}}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}, &ret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ret) != 2 || ret[0]["_id"] != "a" || ret[0]["n"] != int32(1) || ret[1]["n"] != int32(2) || ret[1]["age"] != int32(30) {
+		t.Fatalf("unexpected: %v", ret)
+	}
+}
+
+func TestBulkWrite(t *testing.T) {
+	cc := seed(t)
+	ret, err := cc.BulkWrite("user", []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(&user{Id: "u4"}),
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": "u1"}).SetUpdate(bson.M{"$set": bson.M{"age": 1}}),
+		mongo.NewDeleteManyModel().SetFilter(bson.M{"age": bson.M{"$gte": 25}}),
+	})
+	if err != nil || ret.InsertedCount != 1 || ret.ModifiedCount != 1 || ret.DeletedCount != 2 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+}
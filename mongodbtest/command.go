@@ -0,0 +1,477 @@
+package mongodbtest
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"strings"
+	"sync"
+)
+
+const (
+	code_NamespaceNotFound = 26
+	code_CursorNotFound    = 43
+	code_CommandNotFound   = 59
+
+	defaultBatchSize = 101
+)
+
+// 服务端游标
+type cursor struct {
+	ns   string
+	docs []bson.D
+}
+
+// 基于内存存储的命令处理, 供测试服务使用
+type backend struct {
+	store   *store
+	mux     sync.Mutex
+	cursors map[int64]*cursor
+	nextId  int64
+}
+
+func newBackend(s *store) *backend {
+	return &backend{store: s, cursors: make(map[int64]*cursor)}
+}
+
+func (b *backend) handle(db string, cmd bson.D) bson.D {
+	reply, err := b.run(db, cmd)
+	if err != nil {
+		return errorReply(err)
+	}
+	return append(reply, bson.E{Key: "ok", Value: 1.0})
+}
+
+func (b *backend) run(db string, cmd bson.D) (bson.D, error) {
+	name := cmd[0].Key
+	cl, _ := cmd[0].Value.(string)
+	switch name {
+	case "find":
+		return b.find(db, cl, cmd)
+	case "getMore":
+		return b.getMore(cmd)
+	case "killCursors":
+		return b.killCursors(cmd)
+	case "insert":
+		return b.insert(db, cl, cmd)
+	case "update":
+		return b.update(db, cl, cmd)
+	case "delete":
+		return b.delete(db, cl, cmd)
+	case "findAndModify", "findandmodify":
+		return b.findAndModify(db, cl, cmd)
+	case "aggregate":
+		if cl == "" {
+			return nil, &codeError{Code: code_BadValue, Name: "BadValue", Message: "database level aggregate is not supported"}
+		}
+		return b.aggregate(db, cl, cmd)
+	case "count":
+		q := &query{}
+		var err error
+		if q.Filter, err = docArg(cmd, "query"); err != nil {
+			return nil, err
+		}
+		q.Skip, q.Limit = intArg(cmd, "skip"), intArg(cmd, "limit")
+		if q.Limit < 0 {
+			q.Limit = -q.Limit
+		}
+		docs, err := b.store.find(db, cl, q)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "n", Value: int32(len(docs))}}, nil
+	case "distinct":
+		filter, err := docArg(cmd, "query")
+		if err != nil {
+			return nil, err
+		}
+		key, _ := lookup(cmd, "key")
+		field, ok := key.(string)
+		if !ok {
+			return nil, &codeError{Code: code_FailedToParse, Name: "FailedToParse", Message: "'key' must be of type String"}
+		}
+		vals, err := b.store.distinct(db, cl, field, filter)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "values", Value: vals}}, nil
+	case "listCollections":
+		return b.listCollections(db, cmd)
+	case "listDatabases":
+		return b.listDatabases(cmd)
+	case "create":
+		b.store.create(db, cl)
+		return bson.D{}, nil
+	case "drop":
+		if !b.store.exists(db, cl) {
+			return nil, &codeError{Code: code_NamespaceNotFound, Name: "NamespaceNotFound", Message: "ns not found"}
+		}
+		b.store.drop(db, cl)
+		return bson.D{{Key: "ns", Value: db + "." + cl}}, nil
+	case "dropDatabase":
+		b.store.drop(db, "")
+		return bson.D{{Key: "dropped", Value: db}}, nil
+	}
+	return nil, &codeError{Code: code_CommandNotFound, Name: "CommandNotFound", Message: fmt.Sprintf("no such command: '%v'", name)}
+}
+
+// 取文档参数, 缺失时返回nil
+func docArg(cmd bson.D, key string) (bson.D, error) {
+	v, ok := lookup(cmd, key)
+	if !ok || v == nil {
+		return nil, nil
+	}
+	d, ok := v.(bson.D)
+	if !ok {
+		return nil, &codeError{Code: code_FailedToParse, Name: "FailedToParse", Message: fmt.Sprintf("'%v' must be an object", key)}
+	}
+	return d, nil
+}
+
+func intArg(cmd bson.D, key string) int64 {
+	v, _ := lookup(cmd, key)
+	if n, ok := toInt64(v); ok {
+		return n
+	}
+	if f, ok := v.(float64); ok {
+		return int64(f)
+	}
+	return 0
+}
+
+func boolArg(cmd bson.D, key string, def bool) bool {
+	if v, ok := lookup(cmd, key); ok {
+		return truthy(v)
+	}
+	return def
+}
+
+func docsArg(cmd bson.D, key string) ([]bson.D, error) {
+	v, _ := lookup(cmd, key)
+	arr, ok := v.(bson.A)
+	if !ok {
+		return nil, &codeError{Code: code_FailedToParse, Name: "FailedToParse", Message: fmt.Sprintf("'%v' must be an array", key)}
+	}
+	ret := make([]bson.D, 0, len(arr))
+	for _, item := range arr {
+		d, ok := item.(bson.D)
+		if !ok {
+			return nil, &codeError{Code: code_FailedToParse, Name: "FailedToParse", Message: fmt.Sprintf("'%v' entries must be objects", key)}
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// 返回首批结果, 余下的结果登记为游标
+func (b *backend) cursorReply(ns string, docs []bson.D, batchSize int64, single bool) bson.D {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	var id int64
+	if int64(len(docs)) > batchSize && !single {
+		b.mux.Lock()
+		b.nextId++
+		id = b.nextId
+		b.cursors[id] = &cursor{ns: ns, docs: docs[batchSize:]}
+		b.mux.Unlock()
+		docs = docs[:batchSize]
+	} else if int64(len(docs)) > batchSize {
+		docs = docs[:batchSize]
+	}
+	return bson.D{{Key: "cursor", Value: bson.D{
+		{Key: "firstBatch", Value: toArray(docs)},
+		{Key: "id", Value: id},
+		{Key: "ns", Value: ns},
+	}}}
+}
+
+func toArray(docs []bson.D) bson.A {
+	ret := make(bson.A, 0, len(docs))
+	for _, doc := range docs {
+		ret = append(ret, doc)
+	}
+	return ret
+}
+
+func (b *backend) find(db, cl string, cmd bson.D) (bson.D, error) {
+	q := &query{}
+	var err error
+	if q.Filter, err = docArg(cmd, "filter"); err != nil {
+		return nil, err
+	}
+	if q.Sort, err = docArg(cmd, "sort"); err != nil {
+		return nil, err
+	}
+	if q.Projection, err = docArg(cmd, "projection"); err != nil {
+		return nil, err
+	}
+	q.Skip, q.Limit = intArg(cmd, "skip"), intArg(cmd, "limit")
+	single := boolArg(cmd, "singleBatch", false)
+	if q.Limit < 0 {
+		q.Limit, single = -q.Limit, true
+	}
+	docs, err := b.store.find(db, cl, q)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := intArg(cmd, "batchSize")
+	if single && batchSize <= 0 {
+		batchSize = int64(len(docs))
+	}
+	return b.cursorReply(db+"."+cl, docs, batchSize, single), nil
+}
+
+func (b *backend) aggregate(db, cl string, cmd bson.D) (bson.D, error) {
+	pipeline, err := docsArg(cmd, "pipeline")
+	if err != nil {
+		return nil, err
+	}
+	for _, stage := range pipeline {
+		if len(stage) > 0 && (stage[0].Key == "$out" || stage[0].Key == "$merge") {
+			return nil, &codeError{Code: code_BadValue, Name: "BadValue", Message: stage[0].Key + " is not supported"}
+		}
+	}
+	docs, err := b.store.aggregate(db, cl, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := docArg(cmd, "cursor")
+	if err != nil {
+		return nil, err
+	}
+	return b.cursorReply(db+"."+cl, docs, intArg(opt, "batchSize"), false), nil
+}
+
+func (b *backend) getMore(cmd bson.D) (bson.D, error) {
+	id := intArg(cmd, "getMore")
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	cur, ok := b.cursors[id]
+	if !ok {
+		return nil, &codeError{Code: code_CursorNotFound, Name: "CursorNotFound", Message: fmt.Sprintf("cursor id %v not found", id)}
+	}
+	docs := cur.docs
+	if n := intArg(cmd, "batchSize"); n > 0 && n < int64(len(docs)) {
+		cur.docs = docs[n:]
+		docs = docs[:n]
+	} else {
+		delete(b.cursors, id)
+		id = 0
+	}
+	return bson.D{{Key: "cursor", Value: bson.D{
+		{Key: "nextBatch", Value: toArray(docs)},
+		{Key: "id", Value: id},
+		{Key: "ns", Value: cur.ns},
+	}}}, nil
+}
+
+func (b *backend) killCursors(cmd bson.D) (bson.D, error) {
+	v, _ := lookup(cmd, "cursors")
+	ids, _ := v.(bson.A)
+	killed, missing := bson.A{}, bson.A{}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for _, item := range ids {
+		id, _ := toInt64(item)
+		if _, ok := b.cursors[id]; ok {
+			delete(b.cursors, id)
+			killed = append(killed, id)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return bson.D{
+		{Key: "cursorsKilled", Value: killed},
+		{Key: "cursorsNotFound", Value: missing},
+		{Key: "cursorsAlive", Value: bson.A{}},
+		{Key: "cursorsUnknown", Value: bson.A{}},
+	}, nil
+}
+
+func writeErrorsReply(errs []*writeError) bson.A {
+	ret := make(bson.A, 0, len(errs))
+	for _, we := range errs {
+		ret = append(ret, bson.D{
+			{Key: "index", Value: int32(we.Index)},
+			{Key: "code", Value: we.Code},
+			{Key: "errmsg", Value: we.Message},
+		})
+	}
+	return ret
+}
+
+func withWriteErrors(reply bson.D, errs []*writeError) bson.D {
+	if len(errs) > 0 {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: writeErrorsReply(errs)})
+	}
+	return reply
+}
+
+func (b *backend) insert(db, cl string, cmd bson.D) (bson.D, error) {
+	docs, err := docsArg(cmd, "documents")
+	if err != nil {
+		return nil, err
+	}
+	ids, errs := b.store.insert(db, cl, docs, boolArg(cmd, "ordered", true))
+	return withWriteErrors(bson.D{{Key: "n", Value: int32(len(ids))}}, errs), nil
+}
+
+func (b *backend) update(db, cl string, cmd bson.D) (bson.D, error) {
+	stmts, err := docsArg(cmd, "updates")
+	if err != nil {
+		return nil, err
+	}
+	ordered := boolArg(cmd, "ordered", true)
+	var n, modified int64
+	var upserted bson.A
+	var errs []*writeError
+	for i, stmt := range stmts {
+		u := &updateSpec{Multi: boolArg(stmt, "multi", false), Upsert: boolArg(stmt, "upsert", false)}
+		if u.Filter, err = docArg(stmt, "q"); err == nil {
+			if u.Update, err = docArg(stmt, "u"); err != nil {
+				err = &codeError{Code: code_FailedToParse, Name: "FailedToParse", Message: "pipeline style updates are not supported"}
+			}
+		}
+		var r *updateResult
+		if err == nil {
+			r, err = b.store.update(db, cl, u)
+		}
+		if err != nil {
+			errs = append(errs, &writeError{Index: i, codeError: toCodeError(err)})
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += r.Matched
+		modified += r.Modified
+		if r.UpsertedId != nil {
+			n++
+			upserted = append(upserted, bson.D{{Key: "index", Value: int32(i)}, {Key: "_id", Value: r.UpsertedId}})
+		}
+	}
+	reply := bson.D{{Key: "n", Value: int32(n)}, {Key: "nModified", Value: int32(modified)}}
+	if len(upserted) > 0 {
+		reply = append(reply, bson.E{Key: "upserted", Value: upserted})
+	}
+	return withWriteErrors(reply, errs), nil
+}
+
+func (b *backend) delete(db, cl string, cmd bson.D) (bson.D, error) {
+	stmts, err := docsArg(cmd, "deletes")
+	if err != nil {
+		return nil, err
+	}
+	ordered := boolArg(cmd, "ordered", true)
+	var n int64
+	var errs []*writeError
+	for i, stmt := range stmts {
+		var filter bson.D
+		var deleted int64
+		if filter, err = docArg(stmt, "q"); err == nil {
+			deleted, err = b.store.delete(db, cl, filter, intArg(stmt, "limit") == 0)
+		}
+		if err != nil {
+			errs = append(errs, &writeError{Index: i, codeError: toCodeError(err)})
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += deleted
+	}
+	return withWriteErrors(bson.D{{Key: "n", Value: int32(n)}}, errs), nil
+}
+
+func (b *backend) findAndModify(db, cl string, cmd bson.D) (bson.D, error) {
+	f := &findAndModify{
+		Remove: boolArg(cmd, "remove", false),
+		New:    boolArg(cmd, "new", false),
+		Upsert: boolArg(cmd, "upsert", false),
+	}
+	var err error
+	if f.Query, err = docArg(cmd, "query"); err != nil {
+		return nil, err
+	}
+	if f.Sort, err = docArg(cmd, "sort"); err != nil {
+		return nil, err
+	}
+	if f.Fields, err = docArg(cmd, "fields"); err != nil {
+		return nil, err
+	}
+	if !f.Remove {
+		if f.Update, err = docArg(cmd, "update"); err != nil || f.Update == nil {
+			return nil, &codeError{Code: code_FailedToParse, Name: "FailedToParse", Message: "either an update or remove=true must be specified"}
+		}
+	}
+	value, upsertedId, err := b.store.findAndModify(db, cl, f)
+	if err != nil {
+		return nil, err
+	}
+	found := value != nil && upsertedId == nil
+	last := bson.D{{Key: "n", Value: int32(0)}}
+	if found || upsertedId != nil {
+		last[0].Value = int32(1)
+	}
+	if !f.Remove {
+		last = append(last, bson.E{Key: "updatedExisting", Value: found})
+	}
+	if upsertedId != nil {
+		last = append(last, bson.E{Key: "upserted", Value: upsertedId})
+	}
+	var v interface{}
+	if value != nil {
+		v = value
+	}
+	return bson.D{{Key: "lastErrorObject", Value: last}, {Key: "value", Value: v}}, nil
+}
+
+func (b *backend) listCollections(db string, cmd bson.D) (bson.D, error) {
+	filter, err := docArg(cmd, "filter")
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.D
+	for _, cl := range b.store.collectionNames(db) {
+		doc := bson.D{
+			{Key: "name", Value: cl},
+			{Key: "type", Value: "collection"},
+			{Key: "options", Value: bson.D{}},
+			{Key: "info", Value: bson.D{{Key: "readOnly", Value: false}}},
+		}
+		ok, err := match(doc, filter)
+		if err != nil {
+			return nil, toCodeError(err)
+		}
+		if ok {
+			if boolArg(cmd, "nameOnly", false) {
+				doc = doc[:2]
+			}
+			docs = append(docs, doc)
+		}
+	}
+	opt, _ := docArg(cmd, "cursor")
+	return b.cursorReply(db+".$cmd.listCollections", docs, intArg(opt, "batchSize"), false), nil
+}
+
+func (b *backend) listDatabases(cmd bson.D) (bson.D, error) {
+	filter, err := docArg(cmd, "filter")
+	if err != nil {
+		return nil, err
+	}
+	dbs := bson.A{}
+	for _, db := range b.store.databaseNames() {
+		if strings.HasPrefix(db, "$") {
+			continue
+		}
+		doc := bson.D{{Key: "name", Value: db}, {Key: "sizeOnDisk", Value: int64(0)}, {Key: "empty", Value: false}}
+		ok, err := match(doc, filter)
+		if err != nil {
+			return nil, toCodeError(err)
+		}
+		if ok {
+			dbs = append(dbs, doc)
+		}
+	}
+	return bson.D{{Key: "databases", Value: dbs}, {Key: "totalSize", Value: int64(0)}}, nil
+}
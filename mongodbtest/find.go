@@ -0,0 +1,167 @@
+package mongodbtest
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"sort"
+)
+
+// 排序键取值: 数组字段升序取最小元素, 降序取最大元素, 缺失视为null
+func sortKey(doc bson.D, path string, desc bool) interface{} {
+	vals, found := resolve(doc, splitPath(path))
+	if !found {
+		return nil
+	}
+	var ret interface{}
+	first := true
+	for _, v := range vals {
+		items := []interface{}{v}
+		if arr, ok := v.(bson.A); ok && len(arr) > 0 {
+			items = arr
+		}
+		for _, item := range items {
+			if first || (desc && compare(item, ret) > 0) || (!desc && compare(item, ret) < 0) {
+				ret, first = item, false
+			}
+		}
+	}
+	return ret
+}
+
+func compareBySort(x, y bson.D, spec bson.D) int {
+	for _, e := range spec {
+		desc := toFloat(e.Value) < 0
+		c := compare(sortKey(x, e.Key, desc), sortKey(y, e.Key, desc))
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func sortDocs(docs []bson.D, spec bson.D) {
+	if len(spec) == 0 {
+		return
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return compareBySort(docs[i], docs[j], spec) < 0
+	})
+}
+
+// 跳过与截取, limit<=0表示不限制
+func page(docs []bson.D, skip, limit int64) []bson.D {
+	if skip > 0 {
+		if skip >= int64(len(docs)) {
+			return nil
+		}
+		docs = docs[skip:]
+	}
+	if limit > 0 && limit < int64(len(docs)) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+// 投影: 支持包含与排除两种模式(不可混用, _id除外)及点路径
+func project(doc bson.D, spec bson.D) (bson.D, error) {
+	if len(spec) == 0 {
+		return doc, nil
+	}
+	include := -1
+	keepId := true
+	var paths [][]string
+	for _, e := range spec {
+		if _, ok := e.Value.(bson.D); ok {
+			return nil, fmt.Errorf("unsupported projection operator on field: %v", e.Key)
+		}
+		on := truthy(e.Value)
+		if e.Key == "_id" {
+			keepId = on
+			continue
+		}
+		mode := 0
+		if on {
+			mode = 1
+		}
+		if include >= 0 && include != mode {
+			return nil, fmt.Errorf("cannot do inclusion and exclusion in the same projection: %v", e.Key)
+		}
+		include = mode
+		paths = append(paths, splitPath(e.Key))
+	}
+	if include == 1 {
+		if keepId {
+			paths = append(paths, []string{"_id"})
+		}
+		return projectInclude(doc, paths), nil
+	}
+	if !keepId {
+		paths = append(paths, []string{"_id"})
+	}
+	return projectExclude(doc, paths), nil
+}
+
+// 按路径首段分组, 返回子路径; 有路径恰好终止于该字段时whole为true
+func subPaths(paths [][]string, key string) (subs [][]string, hit bool, whole bool) {
+	for _, p := range paths {
+		if p[0] == key {
+			hit = true
+			if len(p) == 1 {
+				whole = true
+			} else {
+				subs = append(subs, p[1:])
+			}
+		}
+	}
+	return
+}
+
+func projectInclude(doc bson.D, paths [][]string) bson.D {
+	return projectDoc(doc, paths, true)
+}
+
+func projectExclude(doc bson.D, paths [][]string) bson.D {
+	return projectDoc(doc, paths, false)
+}
+
+func projectDoc(doc bson.D, paths [][]string, include bool) bson.D {
+	ret := bson.D{}
+	for _, e := range doc {
+		subs, hit, whole := subPaths(paths, e.Key)
+		switch {
+		case !hit:
+			if !include {
+				ret = append(ret, e)
+			}
+		case whole:
+			if include {
+				ret = append(ret, e)
+			}
+		default:
+			if v, ok := projectValue(e.Value, subs, include); ok {
+				ret = append(ret, bson.E{Key: e.Key, Value: v})
+			}
+		}
+	}
+	return ret
+}
+
+// 子路径投影: 文档递归, 数组对其中元素逐个递归. 非文档值在包含模式下视为不存在, 排除模式下原样保留
+func projectValue(v interface{}, subs [][]string, include bool) (interface{}, bool) {
+	switch v := v.(type) {
+	case bson.D:
+		return projectDoc(v, subs, include), true
+	case bson.A:
+		ret := bson.A{}
+		for _, item := range v {
+			if r, ok := projectValue(item, subs, include); ok {
+				ret = append(ret, r)
+			}
+		}
+		return ret, true
+	}
+	return v, !include
+}
@@ -0,0 +1,322 @@
+package mongodbtest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"github.com/obase/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 设置该环境变量时AssertGolden重写golden文件
+const EnvUpdateGolden = "MONGODBTEST_UPDATE_GOLDEN"
+
+// 模板值, 须占满整个字符串, 如"{{oid}}", "{{oid \"tom\"}}", "{{now \"-2d\"}}"
+var templateRegexp = regexp.MustCompile(`^\{\{\s*(\w+)(?:\s+"([^"]*)")?\s*\}\}$`)
+
+// 按名称生成固定的ObjectId, 同名在不同文件及多次运行间保持一致, 便于跨集合引用及golden比较
+func NamedObjectId(name string) primitive.ObjectID {
+	var ret primitive.ObjectID
+	sum := md5.Sum([]byte(name))
+	copy(ret[:], sum[:])
+	return ret
+}
+
+// 测试数据加载. 文件为extended JSON或YAML, 顶层为集合名到文档数组的映射:
+//
+//	user:
+//	  - _id: "{{oid \"tom\"}}"
+//	    name: tom
+//	    createdAt: "{{now \"-24h\"}}"
+//
+// 支持的模板: oid(无参数为新ObjectId, 有参数同NamedObjectId), now(可带time.ParseDuration格式的偏移, 另支持d表示天)
+type Fixtures struct {
+	Now time.Time // now模板的基准时间, 默认为加载时的当前时间
+	DB  string    // 目标数据库, 默认为Client的默认数据库
+}
+
+// 以默认设置加载测试数据
+func LoadFixtures(cc mongodb.Helper, paths ...string) error {
+	return (&Fixtures{}).Load(cc, paths...)
+}
+
+// 依次加载文件, 每个集合先清空再插入
+func (f *Fixtures) Load(cc mongodb.Helper, paths ...string) error {
+	now := f.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	for _, path := range paths {
+		data, err := readFixture(path)
+		if err != nil {
+			return err
+		}
+		for _, e := range data {
+			arr, ok := e.Value.(bson.A)
+			if !ok {
+				return fmt.Errorf("%v: collection %v must be an array of documents", path, e.Key)
+			}
+			docs := make([]interface{}, 0, len(arr))
+			for _, item := range arr {
+				v, err := expand(item, now)
+				if err != nil {
+					return fmt.Errorf("%v: %v", path, err)
+				}
+				if _, ok := v.(bson.D); !ok {
+					return fmt.Errorf("%v: collection %v must be an array of documents", path, e.Key)
+				}
+				docs = append(docs, v)
+			}
+			if err = f.truncate(cc, e.Key); err != nil {
+				return err
+			}
+			if len(docs) > 0 {
+				if err = f.insert(cc, e.Key, docs); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Fixtures) truncate(cc mongodb.Helper, cl string) (err error) {
+	if f.DB != "" {
+		_, err = cc.DBDeleteMany(f.DB, cl, mongodb.ALL)
+	} else {
+		_, err = cc.DeleteMany(cl, mongodb.ALL)
+	}
+	return
+}
+
+func (f *Fixtures) insert(cc mongodb.Helper, cl string, docs []interface{}) (err error) {
+	if f.DB != "" {
+		_, err = cc.DBInsertMany(f.DB, cl, docs)
+	} else {
+		_, err = cc.InsertMany(cl, docs)
+	}
+	return
+}
+
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func readFixture(path string) (bson.D, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isYAML(path) {
+		var v yaml.MapSlice
+		if err = yaml.Unmarshal(bs, &v); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		var buf bytes.Buffer
+		if err = yamlToJSON(&buf, v); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		bs = buf.Bytes()
+	}
+	var ret bson.D
+	if err = bson.UnmarshalExtJSON(bs, false, &ret); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return ret, nil
+}
+
+// YAML转为JSON, 保留字段顺序, 以便按extended JSON解析
+func yamlToJSON(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		buf.WriteByte('{')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(fmt.Sprint(item.Key))
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := yamlToJSON(buf, item.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := yamlToJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(bs)
+	}
+	return nil
+}
+
+// 展开模板值
+func expand(v interface{}, now time.Time) (interface{}, error) {
+	switch v := v.(type) {
+	case bson.D:
+		ret := make(bson.D, 0, len(v))
+		for _, e := range v {
+			x, err := expand(e.Value, now)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, bson.E{Key: e.Key, Value: x})
+		}
+		return ret, nil
+	case bson.A:
+		ret := make(bson.A, 0, len(v))
+		for _, item := range v {
+			x, err := expand(item, now)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, x)
+		}
+		return ret, nil
+	case string:
+		m := templateRegexp.FindStringSubmatch(v)
+		if m == nil {
+			return v, nil
+		}
+		switch m[1] {
+		case "oid":
+			if m[2] == "" {
+				return primitive.NewObjectID(), nil
+			}
+			return NamedObjectId(m[2]), nil
+		case "now":
+			offset, err := parseOffset(m[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid template %v: %v", v, err)
+			}
+			return primitive.NewDateTimeFromTime(now.Add(offset)), nil
+		}
+		return nil, fmt.Errorf("unknown template %v", v)
+	}
+	return v, nil
+}
+
+// 解析时间偏移, 在time.ParseDuration基础上支持d(天)
+func parseOffset(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// 导出集合内容, 文档按_id排序. cls为空时导出全部集合. 格式按扩展名为relaxed extended JSON或YAML
+func Snapshot(cc mongodb.Helper, path string, cls ...string) error {
+	bs, err := snapshot(cc, isYAML(path), cls)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bs, 0644)
+}
+
+func snapshot(cc mongodb.Helper, asYAML bool, cls []string) ([]byte, error) {
+	if len(cls) == 0 {
+		names, err := cc.ListCollectionNames()
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+		cls = names
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, cl := range cls {
+		var docs []bson.D
+		if err := cc.Find(cl, mongodb.ALL, &docs, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})); err != nil {
+			return nil, err
+		}
+		key, _ := json.Marshal(cl)
+		fmt.Fprintf(&buf, "  %s: [", key)
+		for j, doc := range docs {
+			bs, err := bson.MarshalExtJSON(doc, false, false)
+			if err != nil {
+				return nil, err
+			}
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString("\n    ")
+			buf.Write(bs)
+		}
+		if len(docs) > 0 {
+			buf.WriteString("\n  ")
+		}
+		buf.WriteByte(']')
+		if i < len(cls)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("}\n")
+	if !asYAML {
+		return buf.Bytes(), nil
+	}
+	// JSON即合法的YAML, 经MapSlice转换保留字段顺序
+	var v yaml.MapSlice
+	if err := yaml.Unmarshal(buf.Bytes(), &v); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// 比较集合内容与golden文件, 文件不存在或设置了MONGODBTEST_UPDATE_GOLDEN时重写文件
+func AssertGolden(t testing.TB, cc mongodb.Helper, path string, cls ...string) {
+	t.Helper()
+	actual, err := snapshot(cc, isYAML(path), cls)
+	if err != nil {
+		t.Fatalf("mongodbtest: snapshot %v: %v", path, err)
+		return
+	}
+	expect, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) || os.Getenv(EnvUpdateGolden) != "" {
+		if err = ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatalf("mongodbtest: write golden %v: %v", path, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("mongodbtest: read golden %v: %v", path, err)
+		return
+	}
+	if !bytes.Equal(expect, actual) {
+		t.Errorf("mongodbtest: collections differ from golden file %v (set %v=1 to update)\nexpected:\n%s\nactual:\n%s", path, EnvUpdateGolden, expect, actual)
+	}
+}
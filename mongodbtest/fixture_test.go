@@ -0,0 +1,78 @@
+package mongodbtest
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFixtures(t *testing.T) {
+	cc := NewClient("test")
+	cc.InsertOne("user", bson.M{"_id": "old"})
+
+	now := time.Date(2020, 8, 18, 0, 0, 0, 0, time.UTC)
+	if err := (&Fixtures{Now: now}).Load(cc, "testdata/fixture.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	var tom bson.M
+	if not, err := cc.FindId("user", NamedObjectId("tom"), &tom); err != nil || not {
+		t.Fatalf("unexpected: %v %v", not, err)
+	}
+	if tom["createdAt"] != primitive.NewDateTimeFromTime(now.Add(-24*time.Hour)) || tom["age"] != int32(20) {
+		t.Fatalf("unexpected: %v", tom)
+	}
+	if n, _ := cc.Count("user"); n != 2 {
+		t.Fatalf("collection not truncated: %v", n)
+	}
+	var order bson.M
+	if not, _ := cc.FindOne("order", bson.M{"user": NamedObjectId("tom")}, &order); not {
+		t.Fatal("reference by named ObjectId not found")
+	}
+	if _, ok := order["amount"].(primitive.Decimal128); !ok {
+		t.Fatalf("unexpected: %T", order["amount"])
+	}
+
+	if err := LoadFixtures(cc, "testdata/fixture.json"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := cc.Count("user"); n != 1 {
+		t.Fatalf("unexpected count: %v", n)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mongodbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cc := NewClient("test")
+	f := &Fixtures{Now: time.Date(2020, 8, 18, 0, 0, 0, 0, time.UTC)}
+	if err := f.Load(cc, "testdata/fixture.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"golden.json", "golden.yaml"} {
+		path := filepath.Join(dir, name)
+		if err := Snapshot(cc, path); err != nil {
+			t.Fatal(err)
+		}
+		// 导出的文件可重新加载, 且内容一致
+		other := NewClient("test")
+		if err := LoadFixtures(other, path); err != nil {
+			t.Fatal(err)
+		}
+		AssertGolden(t, other, path)
+	}
+
+	rt := &recordT{TB: t}
+	cc.UpdateId("user", NamedObjectId("tom"), bson.M{"$set": bson.M{"age": 21}})
+	AssertGolden(rt, cc, filepath.Join(dir, "golden.json"))
+	if len(rt.errors) != 1 {
+		t.Fatalf("expect golden mismatch but %v", rt.errors)
+	}
+}
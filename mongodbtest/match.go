@@ -0,0 +1,263 @@
+package mongodbtest
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"strings"
+)
+
+// 判断文档是否满足查询条件
+func match(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		ok, err := matchElem(doc, e)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchElem(doc bson.D, e bson.E) (bool, error) {
+	switch e.Key {
+	case "$and", "$or", "$nor":
+		arr, ok := e.Value.(bson.A)
+		if !ok || len(arr) == 0 {
+			return false, fmt.Errorf("%v must be a nonempty array", e.Key)
+		}
+		for _, item := range arr {
+			sub, ok := item.(bson.D)
+			if !ok {
+				return false, fmt.Errorf("%v entries must be objects", e.Key)
+			}
+			ok, err := match(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case e.Key == "$and" && !ok:
+				return false, nil
+			case e.Key == "$or" && ok:
+				return true, nil
+			case e.Key == "$nor" && ok:
+				return false, nil
+			}
+		}
+		return e.Key != "$or", nil
+	case "$comment":
+		return true, nil
+	}
+	if strings.HasPrefix(e.Key, "$") {
+		return false, fmt.Errorf("unknown top level operator: %v", e.Key)
+	}
+	vals, found := resolve(doc, splitPath(e.Key))
+	return matchCond(vals, found, e.Value)
+}
+
+func isOperatorDoc(v interface{}) (bson.D, bool) {
+	d, ok := v.(bson.D)
+	if !ok || len(d) == 0 || !strings.HasPrefix(d[0].Key, "$") {
+		return nil, false
+	}
+	return d, true
+}
+
+// 匹配字段条件: 操作符文档, 正则或等值
+func matchCond(vals []interface{}, found bool, cond interface{}) (bool, error) {
+	ops, ok := isOperatorDoc(cond)
+	if !ok {
+		if re, ok := cond.(primitive.Regex); ok {
+			return matchRegex(vals, re.Pattern, re.Options)
+		}
+		return matchEq(vals, found, cond), nil
+	}
+	var options string
+	if v, ok := lookup(ops, "$options"); ok {
+		options = fmt.Sprint(v)
+	}
+	for _, op := range ops {
+		ok, err := matchOp(vals, found, op, options)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// 候选值: 数组字段本身及其元素
+func candidates(vals []interface{}) []interface{} {
+	ret := make([]interface{}, 0, len(vals))
+	for _, v := range vals {
+		ret = append(ret, v)
+		if arr, ok := v.(bson.A); ok {
+			ret = append(ret, arr...)
+		}
+	}
+	return ret
+}
+
+func matchEq(vals []interface{}, found bool, target interface{}) bool {
+	if target == nil && !found {
+		return true
+	}
+	for _, v := range candidates(vals) {
+		if equal(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchCmp(vals []interface{}, target interface{}, pred func(c int) bool) bool {
+	for _, v := range candidates(vals) {
+		if typeOrder(v) == typeOrder(target) && pred(compare(v, target)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchIn(vals []interface{}, found bool, arr interface{}) (bool, error) {
+	items, ok := arr.(bson.A)
+	if !ok {
+		return false, fmt.Errorf("$in/$nin needs an array")
+	}
+	for _, item := range items {
+		if re, ok := item.(primitive.Regex); ok {
+			if ok, err := matchRegex(vals, re.Pattern, re.Options); err != nil || ok {
+				return ok, err
+			}
+		} else if matchEq(vals, found, item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchRegex(vals []interface{}, pattern string, options string) (bool, error) {
+	flags := ""
+	for _, c := range options {
+		switch c {
+		case 'i', 'm', 's':
+			flags += string(c)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range candidates(vals) {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchOp(vals []interface{}, found bool, op bson.E, options string) (bool, error) {
+	switch op.Key {
+	case "$eq":
+		return matchEq(vals, found, op.Value), nil
+	case "$ne":
+		return !matchEq(vals, found, op.Value), nil
+	case "$gt":
+		return matchCmp(vals, op.Value, func(c int) bool { return c > 0 }), nil
+	case "$gte":
+		return matchCmp(vals, op.Value, func(c int) bool { return c >= 0 }), nil
+	case "$lt":
+		return matchCmp(vals, op.Value, func(c int) bool { return c < 0 }), nil
+	case "$lte":
+		return matchCmp(vals, op.Value, func(c int) bool { return c <= 0 }), nil
+	case "$in":
+		return matchIn(vals, found, op.Value)
+	case "$nin":
+		ok, err := matchIn(vals, found, op.Value)
+		return !ok, err
+	case "$exists":
+		return found == truthy(op.Value), nil
+	case "$not":
+		ok, err := matchCond(vals, found, op.Value)
+		return !ok, err
+	case "$regex":
+		switch re := op.Value.(type) {
+		case string:
+			return matchRegex(vals, re, options)
+		case primitive.Regex:
+			return matchRegex(vals, re.Pattern, re.Options+options)
+		}
+		return false, fmt.Errorf("$regex has to be a string")
+	case "$options":
+		return true, nil
+	case "$size":
+		n, ok := toInt64(op.Value)
+		if !ok {
+			return false, fmt.Errorf("$size needs a number")
+		}
+		for _, v := range vals {
+			if arr, ok := v.(bson.A); ok && int64(len(arr)) == n {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$all":
+		items, ok := op.Value.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("$all needs an array")
+		}
+		if len(items) == 0 {
+			return false, nil
+		}
+		for _, item := range items {
+			if !matchEq(vals, found, item) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$elemMatch":
+		cond, ok := op.Value.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("$elemMatch needs an Object")
+		}
+		_, isOps := isOperatorDoc(cond)
+		for _, v := range vals {
+			arr, ok := v.(bson.A)
+			if !ok {
+				continue
+			}
+			for _, item := range arr {
+				var ok bool
+				var err error
+				if isOps {
+					ok, err = matchCond([]interface{}{item}, true, cond)
+				} else if doc, isDoc := item.(bson.D); isDoc {
+					ok, err = match(doc, cond)
+				}
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown operator: %v", op.Key)
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	if isNumber(v) {
+		return toFloat(v) != 0
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package mongodbtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/obase/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// 录制及回放时忽略的会话, 集群时间等易变字段
+var volatileFields = map[string]bool{
+	"lsid":            true,
+	"txnNumber":       true,
+	"$clusterTime":    true,
+	"$readPreference": true,
+	"$db":             true,
+	"operationTime":   true,
+	"signature":       true,
+}
+
+// 一次命令及其回复
+type Record struct {
+	Database string
+	Command  bson.D
+	Reply    bson.D
+}
+
+type recordJSON struct {
+	Database string          `json:"database"`
+	Command  json.RawMessage `json:"command"`
+	Reply    json.RawMessage `json:"reply"`
+}
+
+func clean(d bson.D) bson.D {
+	ret := make(bson.D, 0, len(d))
+	for _, e := range d {
+		if !volatileFields[e.Key] {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func extJSON(d bson.D) string {
+	bs, err := bson.MarshalExtJSON(d, true, false)
+	if err != nil {
+		return fmt.Sprint(d)
+	}
+	return string(bs)
+}
+
+// 读取录制文件
+func LoadRecords(path string) ([]*Record, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []*recordJSON
+	if err = json.Unmarshal(bs, &items); err != nil {
+		return nil, err
+	}
+	ret := make([]*Record, 0, len(items))
+	for _, item := range items {
+		r := &Record{Database: item.Database}
+		if err = bson.UnmarshalExtJSON(item.Command, true, &r.Command); err != nil {
+			return nil, err
+		}
+		if err = bson.UnmarshalExtJSON(item.Reply, true, &r.Reply); err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// 保存录制文件, 命令与回复以canonical extended JSON保存以保留BSON类型
+func SaveRecords(path string, records []*Record) error {
+	items := make([]*recordJSON, 0, len(records))
+	for _, r := range records {
+		item := &recordJSON{Database: r.Database}
+		var err error
+		if item.Command, err = bson.MarshalExtJSON(r.Command, true, false); err != nil {
+			return err
+		}
+		if item.Reply, err = bson.MarshalExtJSON(r.Reply, true, false); err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	bs, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bs, 0644)
+}
+
+// 命令录制: 将Monitor()设置到Config.Monitor, 连接真实集群执行用例后Save()生成回放文件
+type Recorder struct {
+	path    string
+	mux     sync.Mutex
+	records []*Record
+	pending map[int64]*Record
+}
+
+func NewRecorder(path string) *Recorder {
+	return &Recorder{path: path, pending: make(map[int64]*Record)}
+}
+
+func (r *Recorder) Monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			if isLocalCommand(e.CommandName) {
+				return
+			}
+			cmd, err := rawToD(e.Command)
+			if err != nil {
+				return
+			}
+			rec := &Record{Database: e.DatabaseName, Command: clean(cmd)}
+			r.mux.Lock()
+			r.records = append(r.records, rec)
+			r.pending[e.RequestID] = rec
+			r.mux.Unlock()
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			reply, _ := rawToD(e.Reply)
+			r.finish(e.RequestID, clean(reply))
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			// 失败事件不含回复文档, 仅能保留错误信息
+			r.finish(e.RequestID, bson.D{{Key: "ok", Value: 0.0}, {Key: "errmsg", Value: e.Failure}})
+		},
+	}
+}
+
+func (r *Recorder) finish(requestId int64, reply bson.D) {
+	r.mux.Lock()
+	if rec, ok := r.pending[requestId]; ok {
+		rec.Reply = reply
+		delete(r.pending, requestId)
+	}
+	r.mux.Unlock()
+}
+
+// 已完成的录制
+func (r *Recorder) Records() []*Record {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	ret := make([]*Record, 0, len(r.records))
+	for _, rec := range r.records {
+		if rec.Reply != nil {
+			ret = append(ret, rec)
+		}
+	}
+	return ret
+}
+
+func (r *Recorder) Save() error {
+	return SaveRecords(r.path, r.Records())
+}
+
+// 命令回放: 本地测试服务按录制顺序应答, 命令不符或多余时令测试失败
+type Replayer struct {
+	// 比较录制命令与实际命令, 默认忽略文档字段顺序(bson.M的编码顺序不固定)
+	Match func(recorded, actual bson.D) bool
+
+	t       testing.TB
+	server  *Server
+	mux     sync.Mutex
+	records []*Record
+	pos     int
+}
+
+// 加载录制文件并启动回放服务
+func NewReplayer(t testing.TB, path string) (*Replayer, error) {
+	records, err := LoadRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replayer{Match: sameCommand, t: t, records: records}
+	if r.server, err = newServer(r.handle); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Replayer) Addr() string {
+	return r.server.Addr()
+}
+
+func (r *Replayer) Config(db string) *mongodb.Config {
+	return r.server.Config(db)
+}
+
+// 关闭回放服务, 尚有录制命令未被执行时令测试失败
+func (r *Replayer) Close() error {
+	err := r.server.Close()
+	r.mux.Lock()
+	if left := len(r.records) - r.pos; left > 0 {
+		r.t.Errorf("mongodbtest: %v recorded commands not replayed, next: %v", left, extJSON(r.records[r.pos].Command))
+	}
+	r.mux.Unlock()
+	return err
+}
+
+func (r *Replayer) handle(db string, cmd bson.D) bson.D {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	actual := clean(cmd)
+	if r.pos >= len(r.records) {
+		r.t.Errorf("mongodbtest: unexpected command: %v", extJSON(actual))
+		return errorReply(fmt.Errorf("unexpected command %v", actual[0].Key))
+	}
+	rec := r.records[r.pos]
+	if rec.Database != db || !r.Match(rec.Command, actual) {
+		r.t.Errorf("mongodbtest: command #%v mismatch\nexpected: %v.%v\nactual:   %v.%v", r.pos, rec.Database, extJSON(rec.Command), db, extJSON(actual))
+		return errorReply(fmt.Errorf("command #%v mismatch", r.pos))
+	}
+	r.pos++
+	return rec.Reply
+}
+
+func sameCommand(recorded, actual bson.D) bool {
+	return sameValue(recorded, actual)
+}
+
+// 深比较, 文档忽略字段顺序
+func sameValue(a, b interface{}) bool {
+	switch x := a.(type) {
+	case bson.D:
+		y, ok := b.(bson.D)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for _, e := range x {
+			v, ok := lookup(y, e.Key)
+			if !ok || !sameValue(e.Value, v) {
+				return false
+			}
+		}
+		return true
+	case bson.A:
+		y, ok := b.(bson.A)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !sameValue(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return typeOrder(a) == typeOrder(b) && equal(a, b) && fmt.Sprintf("%T", a) == fmt.Sprintf("%T", b)
+}
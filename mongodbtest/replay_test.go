@@ -0,0 +1,104 @@
+package mongodbtest
+
+import (
+	"fmt"
+	"github.com/obase/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 录制与回放执行同一组操作
+func session(cc *mongodb.Client) (ret []*user, err error) {
+	if _, err = cc.InsertOne("user", &user{Id: "u1", Name: "tom", Age: 20}); err != nil {
+		return
+	}
+	if _, err = cc.UpdateId("user", "u1", bson.M{"$inc": bson.M{"age": 1}, "$set": bson.M{"name": "jack"}}); err != nil {
+		return
+	}
+	err = cc.Find("user", bson.M{"age": bson.M{"$gt": 0}, "name": "jack"}, &ret, options.Find().SetLimit(10))
+	return
+}
+
+type recordT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mongodbtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.json")
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecorder(path)
+	cnf := srv.Config("test")
+	cnf.Monitor = rec.Monitor()
+	cc, err := mongodb.NewClient(cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := session(cc); err != nil || len(ret) != 1 || ret[0].Age != 21 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	cc.Close()
+	srv.Close()
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rec.Records()); n != 3 {
+		t.Fatalf("expect 3 records but %v", n)
+	}
+
+	rt := &recordT{TB: t}
+	rp, err := NewReplayer(rt, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc, err = mongodb.NewClient(rp.Config("test")); err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := session(cc); err != nil || len(ret) != 1 || ret[0].Age != 21 || ret[0].Name != "jack" {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	// 多余的命令
+	if _, err := cc.DeleteId("user", "u1"); err == nil {
+		t.Fatal("expect error on unexpected command")
+	}
+	cc.Close()
+	rp.Close()
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "unexpected command") {
+		t.Fatalf("unexpected: %v", rt.errors)
+	}
+
+	// 顺序不符及未回放
+	rt = &recordT{TB: t}
+	if rp, err = NewReplayer(rt, path); err != nil {
+		t.Fatal(err)
+	}
+	if cc, err = mongodb.NewClient(rp.Config("test")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Find("user", mongodb.ALL, &[]*user{}); err == nil {
+		t.Fatal("expect error on out-of-order command")
+	}
+	cc.Close()
+	rp.Close()
+	if len(rt.errors) != 2 || !strings.Contains(rt.errors[0], "mismatch") || !strings.Contains(rt.errors[1], "not replayed") {
+		t.Fatalf("unexpected: %v", rt.errors)
+	}
+}
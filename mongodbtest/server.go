@@ -0,0 +1,352 @@
+package mongodbtest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/obase/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxWireVersion    = 8 // 4.2
+	maxBsonObjectSize = 16 * 1024 * 1024
+	maxMessageSize    = 48000000
+	maxWriteBatchSize = 100000
+)
+
+// 本地TCP测试服务, 讲OP_MSG(及握手用的OP_QUERY)协议, 可直接以mongodb.NewClient连接
+type Server struct {
+	store   *store
+	ln      net.Listener
+	handler func(db string, cmd bson.D) bson.D
+	connId  int32
+	mux     sync.Mutex
+	conns   map[net.Conn]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// 启动基于内存存储的测试服务, 监听127.0.0.1的随机端口
+func NewServer() (*Server, error) {
+	b := newBackend(newStore())
+	s, err := newServer(b.handle)
+	if err != nil {
+		return nil, err
+	}
+	s.store = b.store
+	return s, nil
+}
+
+// 启动测试服务并以mongodb.NewClient连接, 客户端不注册到全局, 可重复运行. 测试结束时依次关闭客户端及服务
+func Open(db string) (*Server, *mongodb.Client, error) {
+	srv, err := NewServer()
+	if err != nil {
+		return nil, nil, err
+	}
+	cc, err := mongodb.NewClient(srv.Config(db))
+	if err != nil {
+		srv.Close()
+		return nil, nil, err
+	}
+	return srv, cc, nil
+}
+
+func newServer(handler func(db string, cmd bson.D) bson.D) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ln:      ln,
+		handler: handler,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// 监听地址, 格式为host:port
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// 连接本服务的客户端配置, db为默认数据库
+func (s *Server) Config(db string) *mongodb.Config {
+	return &mongodb.Config{
+		Address:                []string{s.Addr()},
+		Database:               db,
+		Direct:                 true,
+		ServerSelectionTimeout: 5 * time.Second,
+	}
+}
+
+// 与服务共用内存存储的Client, 用于准备数据及断言
+func (s *Server) Client(db string) *Client {
+	return &Client{DB: db, store: s.store}
+}
+
+// 关闭监听及全部连接
+func (s *Server) Close() error {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.ln.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mux.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mux.Lock()
+		if s.closed {
+			s.mux.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mux.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn, atomic.AddInt32(&s.connId, 1))
+			s.mux.Lock()
+			delete(s.conns, conn)
+			s.mux.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn, connId int32) {
+	r := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		length := int32(binary.LittleEndian.Uint32(size[:]))
+		if length < 16 || length > maxMessageSize {
+			return
+		}
+		wm := make([]byte, length)
+		copy(wm, size[:])
+		if _, err := io.ReadFull(r, wm[4:]); err != nil {
+			return
+		}
+		reply, err := s.handleMessage(wm, connId)
+		if err != nil {
+			return
+		}
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 处理单条消息, 返回nil表示无需回复(moreToCome)
+func (s *Server) handleMessage(wm []byte, connId int32) ([]byte, error) {
+	_, reqId, _, opcode, rem, ok := wiremessage.ReadHeader(wm)
+	if !ok {
+		return nil, fmt.Errorf("malformed header")
+	}
+	switch opcode {
+	case wiremessage.OpMsg:
+		db, cmd, moreToCome, err := readMsg(rem)
+		if err != nil {
+			return nil, err
+		}
+		reply := s.dispatch(db, cmd, connId)
+		if moreToCome {
+			return nil, nil
+		}
+		return appendMsg(reqId, reply)
+	case wiremessage.OpQuery:
+		db, cmd, err := readQuery(rem)
+		if err != nil {
+			return nil, err
+		}
+		return appendReply(reqId, s.dispatch(db, cmd, connId))
+	}
+	return nil, fmt.Errorf("unsupported opcode %v", opcode)
+}
+
+func readMsg(src []byte) (db string, cmd bson.D, moreToCome bool, err error) {
+	flags, src, ok := wiremessage.ReadMsgFlags(src)
+	if !ok {
+		return "", nil, false, fmt.Errorf("malformed OP_MSG flags")
+	}
+	if flags&wiremessage.ChecksumPresent != 0 {
+		src = src[:len(src)-4]
+	}
+	var body bsoncore.Document
+	var seqs bson.D
+	for len(src) > 0 {
+		var stype wiremessage.SectionType
+		if stype, src, ok = wiremessage.ReadMsgSectionType(src); !ok {
+			return "", nil, false, fmt.Errorf("malformed OP_MSG section")
+		}
+		switch stype {
+		case wiremessage.SingleDocument:
+			if body, src, ok = wiremessage.ReadMsgSectionSingleDocument(src); !ok {
+				return "", nil, false, fmt.Errorf("malformed OP_MSG body")
+			}
+		case wiremessage.DocumentSequence:
+			var id string
+			var docs []bsoncore.Document
+			if id, docs, src, ok = wiremessage.ReadMsgSectionDocumentSequence(src); !ok {
+				return "", nil, false, fmt.Errorf("malformed OP_MSG document sequence")
+			}
+			arr := make(bson.A, 0, len(docs))
+			for _, doc := range docs {
+				d, err := rawToD(doc)
+				if err != nil {
+					return "", nil, false, err
+				}
+				arr = append(arr, d)
+			}
+			seqs = append(seqs, bson.E{Key: id, Value: arr})
+		default:
+			return "", nil, false, fmt.Errorf("unknown OP_MSG section type %v", stype)
+		}
+	}
+	if cmd, err = rawToD(body); err != nil {
+		return "", nil, false, err
+	}
+	cmd = append(cmd, seqs...)
+	if v, ok := lookup(cmd, "$db"); ok {
+		db, _ = v.(string)
+	}
+	return db, cmd, flags&wiremessage.MoreToCome != 0, nil
+}
+
+func readQuery(src []byte) (db string, cmd bson.D, err error) {
+	_, src, ok := wiremessage.ReadQueryFlags(src)
+	var ns string
+	if ok {
+		ns, src, ok = wiremessage.ReadQueryFullCollectionName(src)
+	}
+	if ok {
+		_, src, ok = wiremessage.ReadQueryNumberToSkip(src)
+	}
+	if ok {
+		_, src, ok = wiremessage.ReadQueryNumberToReturn(src)
+	}
+	var query bsoncore.Document
+	if ok {
+		query, _, ok = wiremessage.ReadQueryQuery(src)
+	}
+	if !ok || !strings.HasSuffix(ns, ".$cmd") {
+		return "", nil, fmt.Errorf("only commands are supported with OP_QUERY: %v", ns)
+	}
+	if cmd, err = rawToD(query); err != nil {
+		return "", nil, err
+	}
+	if len(cmd) > 0 && cmd[0].Key == "$query" {
+		cmd, _ = cmd[0].Value.(bson.D)
+	}
+	return strings.TrimSuffix(ns, ".$cmd"), cmd, nil
+}
+
+func appendMsg(respTo int32, reply bson.D) ([]byte, error) {
+	doc, err := bson.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	idx, wm := wiremessage.AppendHeaderStart(nil, wiremessage.NextRequestID(), respTo, wiremessage.OpMsg)
+	wm = wiremessage.AppendMsgFlags(wm, 0)
+	wm = wiremessage.AppendMsgSectionType(wm, wiremessage.SingleDocument)
+	wm = append(wm, doc...)
+	return bsoncore.UpdateLength(wm, idx, int32(len(wm[idx:]))), nil
+}
+
+func appendReply(respTo int32, reply bson.D) ([]byte, error) {
+	doc, err := bson.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	idx, wm := wiremessage.AppendHeaderStart(nil, wiremessage.NextRequestID(), respTo, wiremessage.OpReply)
+	wm = wiremessage.AppendReplyFlags(wm, wiremessage.AwaitCapable)
+	wm = wiremessage.AppendReplyCursorID(wm, 0)
+	wm = wiremessage.AppendReplyStartingFrom(wm, 0)
+	wm = wiremessage.AppendReplyNumberReturned(wm, 1)
+	wm = append(wm, doc...)
+	return bsoncore.UpdateLength(wm, idx, int32(len(wm[idx:]))), nil
+}
+
+// 握手, 认证及连接管理命令, 不参与录制回放
+func isLocalCommand(name string) bool {
+	switch name {
+	case "hello", "isMaster", "ismaster", "ping", "endSessions", "buildInfo", "buildinfo", "saslStart", "saslContinue":
+		return true
+	}
+	return false
+}
+
+// 握手及连接管理命令由服务本身应答, 其余交给handler
+func (s *Server) dispatch(db string, cmd bson.D, connId int32) bson.D {
+	if len(cmd) == 0 {
+		return errorReply(&codeError{Code: code_FailedToParse, Name: "FailedToParse", Message: "empty command"})
+	}
+	switch cmd[0].Key {
+	case "hello", "isMaster", "ismaster":
+		return bson.D{
+			{Key: "ismaster", Value: true},
+			{Key: "maxBsonObjectSize", Value: int32(maxBsonObjectSize)},
+			{Key: "maxMessageSizeBytes", Value: int32(maxMessageSize)},
+			{Key: "maxWriteBatchSize", Value: int32(maxWriteBatchSize)},
+			{Key: "localTime", Value: primitive.NewDateTimeFromTime(time.Now())},
+			{Key: "logicalSessionTimeoutMinutes", Value: int32(30)},
+			{Key: "connectionId", Value: connId},
+			{Key: "minWireVersion", Value: int32(0)},
+			{Key: "maxWireVersion", Value: int32(maxWireVersion)},
+			{Key: "readOnly", Value: false},
+			{Key: "ok", Value: 1.0},
+		}
+	case "ping", "endSessions":
+		return bson.D{{Key: "ok", Value: 1.0}}
+	case "buildInfo", "buildinfo":
+		return bson.D{
+			{Key: "version", Value: "4.2.0"},
+			{Key: "versionArray", Value: bson.A{int32(4), int32(2), int32(0), int32(0)}},
+			{Key: "maxBsonObjectSize", Value: int32(maxBsonObjectSize)},
+			{Key: "ok", Value: 1.0},
+		}
+	}
+	return s.handler(db, cmd)
+}
+
+func errorReply(err error) bson.D {
+	ce := toCodeError(err)
+	return bson.D{
+		{Key: "ok", Value: 0.0},
+		{Key: "errmsg", Value: ce.Message},
+		{Key: "code", Value: ce.Code},
+		{Key: "codeName", Value: ce.Name},
+	}
+}
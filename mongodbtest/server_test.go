@@ -0,0 +1,109 @@
+package mongodbtest
+
+import (
+	"fmt"
+	"github.com/obase/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if err := mongodb.Setup("mongodbtest.server", srv.Config("test")); err != nil {
+		t.Fatal(err)
+	}
+	cc := mongodb.Must("mongodbtest.server")
+	defer func() {
+		// Close注销主键, 可以相同主键重新Setup
+		if err := cc.Close(); err != nil || mongodb.Get("mongodbtest.server") != nil {
+			t.Fatalf("expect unregistered after close: %v", err)
+		}
+		if err := mongodb.Setup("mongodbtest.server", srv.Config("test")); err != nil {
+			t.Fatal(err)
+		}
+		mongodb.Must("mongodbtest.server").Close()
+	}()
+
+	docs := make([]interface{}, 0, 250)
+	for i := 0; i < 250; i++ {
+		docs = append(docs, &user{Id: fmt.Sprintf("u%03d", i), Name: fmt.Sprintf("name%v", i%10), Age: i})
+	}
+	if _, err := cc.InsertMany("user", docs); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过首批101条, 经由getMore取回
+	var all []*user
+	if err := cc.Find("user", mongodb.ALL, &all, options.Find().SetBatchSize(50)); err != nil || len(all) != 250 {
+		t.Fatalf("unexpected: %v %v", len(all), err)
+	}
+	var top []*user
+	opt := options.Find().SetSort(bson.M{"age": -1}).SetSkip(1).SetLimit(3).SetProjection(bson.M{"name": 0})
+	if err := cc.Find("user", bson.M{"age": bson.M{"$gte": 100}}, &top, opt); err != nil || len(top) != 3 || top[0].Age != 248 || top[0].Name != "" {
+		t.Fatalf("unexpected: %v %v", top, err)
+	}
+
+	u := &user{}
+	if not, err := cc.FindId("user", "u010", u); err != nil || not || u.Age != 10 {
+		t.Fatalf("unexpected: %v %v %v", not, err, u)
+	}
+	if not, err := cc.FindId("user", "none", u); err != nil || !not {
+		t.Fatalf("unexpected: %v %v", not, err)
+	}
+
+	// 游标未读完即关闭, 触发killCursors
+	err = cc.FindWith("user", mongodb.ALL, func(cur *mongo.Cursor) error {
+		cur.Next(nil)
+		return nil
+	}, options.Find().SetBatchSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ret, err := cc.UpdateMany("user", bson.M{"name": "name1"}, bson.M{"$inc": bson.M{"age": 1000}}); err != nil || ret.MatchedCount != 25 || ret.ModifiedCount != 25 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	if ret, err := cc.UpdateOne("user", bson.M{"_id": "new"}, bson.M{"$set": bson.M{"age": -1}}, options.Update().SetUpsert(true)); err != nil || ret.UpsertedID != "new" {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	if not, err := cc.FindIdAndUpdate("user", "u001", bson.M{"$set": bson.M{"name": "tom"}}, u, options.FindOneAndUpdate().SetReturnDocument(options.After)); err != nil || not || u.Name != "tom" || u.Age != 1001 {
+		t.Fatalf("unexpected: %v %v %v", not, err, u)
+	}
+	if ret, err := cc.DeleteMany("user", bson.M{"age": bson.M{"$lt": 100}}); err != nil || ret.DeletedCount != 91 {
+		t.Fatalf("unexpected: %v %v", ret, err)
+	}
+	if n, err := cc.Count("user", bson.M{"age": bson.M{"$gte": 1000}}); err != nil || n != 25 {
+		t.Fatalf("unexpected: %v %v", n, err)
+	}
+	if n, err := cc.Count("user"); err != nil || n != 160 {
+		t.Fatalf("unexpected: %v %v", n, err)
+	}
+
+	var groups []bson.M
+	err = cc.Aggregate("user", mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"age": bson.M{"$gte": 1000}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$name", "n": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}, &groups)
+	if err != nil || len(groups) != 2 || groups[0]["_id"] != "name1" || groups[0]["n"] != int32(24) {
+		t.Fatalf("unexpected: %v %v", groups, err)
+	}
+
+	_, err = cc.InsertOne("user", &user{Id: "u200"})
+	if !mongodb.IsDuplicateKey(err) {
+		t.Fatalf("expect duplicate key but %v", err)
+	}
+
+	if names, err := cc.ListCollectionNames(); err != nil || len(names) != 1 || names[0] != "user" {
+		t.Fatalf("unexpected: %v %v", names, err)
+	}
+	if n, _ := srv.Client("test").Count("user"); n != 160 {
+		t.Fatalf("unexpected count: %v", n)
+	}
+}
@@ -0,0 +1,458 @@
+package mongodbtest
+
+import (
+	"bytes"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"sort"
+	"sync"
+)
+
+// 服务端错误码, 与mongod一致
+const (
+	code_BadValue       = 2
+	code_FailedToParse  = 9
+	code_ImmutableField = 66
+	code_DuplicateKey   = 11000
+)
+
+// 带错误码的错误, 由调用方转换为驱动的CommandError/WriteException或wire协议回复
+type codeError struct {
+	Code    int32
+	Name    string
+	Message string
+}
+
+func (e *codeError) Error() string {
+	return e.Message
+}
+
+func toCodeError(err error) *codeError {
+	if ce, ok := err.(*codeError); ok {
+		return ce
+	}
+	return &codeError{Code: code_BadValue, Name: "BadValue", Message: err.Error()}
+}
+
+// 单条写入的错误, Index为批量写入中的下标
+type writeError struct {
+	Index int
+	*codeError
+}
+
+func duplicateKey(db, cl string, id interface{}) *codeError {
+	return &codeError{
+		Code:    code_DuplicateKey,
+		Name:    "DuplicateKey",
+		Message: fmt.Sprintf("E11000 duplicate key error collection: %v.%v index: _id_ dup key: { _id: %v }", db, cl, format(id)),
+	}
+}
+
+// 内存存储: 文档以BSON保存, 读写时转换为bson.D, 仅维护_id唯一索引
+type store struct {
+	sync.Mutex
+	dbs map[string]map[string][]bson.Raw
+}
+
+func newStore() *store {
+	return &store{dbs: make(map[string]map[string][]bson.Raw)}
+}
+
+func (s *store) load(db, cl string) ([]bson.D, error) {
+	raws := s.dbs[db][cl]
+	docs := make([]bson.D, 0, len(raws))
+	for _, raw := range raws {
+		doc, err := rawToD(raw)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (s *store) save(db, cl string, docs []bson.D) error {
+	raws := make([]bson.Raw, 0, len(docs))
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		raws = append(raws, raw)
+	}
+	if s.dbs[db] == nil {
+		s.dbs[db] = make(map[string][]bson.Raw)
+	}
+	s.dbs[db][cl] = raws
+	return nil
+}
+
+func (s *store) databaseNames() []string {
+	s.Lock()
+	defer s.Unlock()
+	ret := make([]string, 0, len(s.dbs))
+	for db := range s.dbs {
+		ret = append(ret, db)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *store) collectionNames(db string) []string {
+	s.Lock()
+	defer s.Unlock()
+	ret := make([]string, 0, len(s.dbs[db]))
+	for cl := range s.dbs[db] {
+		ret = append(ret, cl)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *store) exists(db, cl string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.dbs[db][cl]
+	return ok
+}
+
+func (s *store) create(db, cl string) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.dbs[db][cl]; !ok {
+		s.save(db, cl, nil)
+	}
+}
+
+func (s *store) drop(db, cl string) {
+	s.Lock()
+	defer s.Unlock()
+	if cl == "" {
+		delete(s.dbs, db)
+	} else {
+		delete(s.dbs[db], cl)
+	}
+}
+
+func filterDocs(docs []bson.D, filter bson.D) ([]bson.D, error) {
+	ret := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		ok, err := match(doc, filter)
+		if err != nil {
+			return nil, &codeError{Code: code_BadValue, Name: "BadValue", Message: err.Error()}
+		}
+		if ok {
+			ret = append(ret, doc)
+		}
+	}
+	return ret, nil
+}
+
+// 查询参数
+type query struct {
+	Filter     bson.D
+	Sort       bson.D
+	Projection bson.D
+	Skip       int64
+	Limit      int64
+}
+
+func (s *store) find(db, cl string, q *query) ([]bson.D, error) {
+	s.Lock()
+	docs, err := s.load(db, cl)
+	s.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if docs, err = filterDocs(docs, q.Filter); err != nil {
+		return nil, err
+	}
+	sortDocs(docs, q.Sort)
+	docs = page(docs, q.Skip, q.Limit)
+	if len(q.Projection) > 0 {
+		for i, doc := range docs {
+			if docs[i], err = project(doc, q.Projection); err != nil {
+				return nil, toCodeError(err)
+			}
+		}
+	}
+	return docs, nil
+}
+
+func (s *store) distinct(db, cl string, key string, filter bson.D) (bson.A, error) {
+	docs, err := s.find(db, cl, &query{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	ret := bson.A{}
+	for _, doc := range docs {
+		vals, _ := resolve(doc, splitPath(key))
+		for _, v := range vals {
+			items := bson.A{v}
+			if arr, ok := v.(bson.A); ok {
+				items = arr
+			}
+			for _, item := range items {
+				if !contains(ret, item) {
+					ret = append(ret, item)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (s *store) aggregate(db, cl string, pipeline []bson.D) ([]bson.D, error) {
+	s.Lock()
+	docs, err := s.load(db, cl)
+	s.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	docs, err = aggregate(docs, pipeline, func(from string) ([]bson.D, error) {
+		s.Lock()
+		defer s.Unlock()
+		return s.load(db, from)
+	})
+	if err != nil {
+		return nil, toCodeError(err)
+	}
+	return docs, nil
+}
+
+func indexOfId(docs []bson.D, id interface{}) int {
+	for i, doc := range docs {
+		if v, ok := lookup(doc, "_id"); ok && equal(v, id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// 插入文档, 缺失_id时自动生成. ordered为true时遇错即停
+func (s *store) insert(db, cl string, docs []bson.D, ordered bool) (ids []interface{}, errs []*writeError) {
+	s.Lock()
+	defer s.Unlock()
+	all, err := s.load(db, cl)
+	if err != nil {
+		return nil, []*writeError{{Index: 0, codeError: toCodeError(err)}}
+	}
+	for i, doc := range docs {
+		doc, id := ensureId(doc)
+		if indexOfId(all, id) >= 0 {
+			errs = append(errs, &writeError{Index: i, codeError: duplicateKey(db, cl, id)})
+			if ordered {
+				break
+			}
+			continue
+		}
+		all = append(all, doc)
+		ids = append(ids, id)
+	}
+	if err := s.save(db, cl, all); err != nil {
+		errs = append(errs, &writeError{Index: len(docs) - 1, codeError: toCodeError(err)})
+	}
+	return
+}
+
+// 更新参数, Update为操作符文档或replace文档
+type updateSpec struct {
+	Filter bson.D
+	Update bson.D
+	Multi  bool
+	Upsert bool
+}
+
+type updateResult struct {
+	Matched    int64
+	Modified   int64
+	UpsertedId interface{}
+}
+
+// 计算更新后的文档
+func applyTo(doc bson.D, u bson.D, insert bool) (bson.D, error) {
+	var ret bson.D
+	var err error
+	if isUpdateDoc(u) {
+		ret, err = applyUpdate(doc, u, insert)
+	} else {
+		ret, err = applyReplace(doc, u)
+	}
+	if err != nil {
+		return nil, toCodeError(err)
+	}
+	return ret, nil
+}
+
+// 生成upsert文档
+func upsertDoc(u *updateSpec) (bson.D, error) {
+	seed := bson.D{}
+	if isUpdateDoc(u.Update) {
+		var err error
+		if seed, err = upsertSeed(u.Filter); err != nil {
+			return nil, toCodeError(err)
+		}
+	} else if id, ok := lookup(u.Filter, "_id"); ok {
+		if _, isOps := isOperatorDoc(id); !isOps {
+			seed = bson.D{{Key: "_id", Value: id}}
+		}
+	}
+	doc, err := applyTo(seed, u.Update, true)
+	if err != nil {
+		return nil, err
+	}
+	doc, _ = ensureId(doc)
+	return doc, nil
+}
+
+func sameDoc(a, b bson.D) bool {
+	x, err1 := bson.Marshal(a)
+	y, err2 := bson.Marshal(b)
+	return err1 == nil && err2 == nil && bytes.Equal(x, y)
+}
+
+func (s *store) update(db, cl string, u *updateSpec) (*updateResult, error) {
+	s.Lock()
+	defer s.Unlock()
+	all, err := s.load(db, cl)
+	if err != nil {
+		return nil, err
+	}
+	ret := &updateResult{}
+	for i, doc := range all {
+		ok, err := match(doc, u.Filter)
+		if err != nil {
+			return nil, toCodeError(err)
+		}
+		if !ok {
+			continue
+		}
+		ret.Matched++
+		next, err := applyTo(doc, u.Update, false)
+		if err != nil {
+			return nil, err
+		}
+		if !sameDoc(doc, next) {
+			all[i] = next
+			ret.Modified++
+		}
+		if !u.Multi {
+			break
+		}
+	}
+	if ret.Matched == 0 && u.Upsert {
+		doc, err := upsertDoc(u)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := lookup(doc, "_id")
+		if indexOfId(all, id) >= 0 {
+			return nil, duplicateKey(db, cl, id)
+		}
+		all = append(all, doc)
+		ret.UpsertedId = id
+	}
+	if ret.Modified > 0 || ret.UpsertedId != nil {
+		if err := s.save(db, cl, all); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// 删除匹配文档, multi为false时只删除首个
+func (s *store) delete(db, cl string, filter bson.D, multi bool) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	all, err := s.load(db, cl)
+	if err != nil {
+		return 0, err
+	}
+	keep := make([]bson.D, 0, len(all))
+	var n int64
+	for _, doc := range all {
+		ok, err := match(doc, filter)
+		if err != nil {
+			return 0, toCodeError(err)
+		}
+		if ok && (multi || n == 0) {
+			n++
+			continue
+		}
+		keep = append(keep, doc)
+	}
+	if n > 0 {
+		if err := s.save(db, cl, keep); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// findAndModify参数, Update为空且Remove为true时删除
+type findAndModify struct {
+	Query  bson.D
+	Sort   bson.D
+	Update bson.D
+	Fields bson.D
+	Remove bool
+	New    bool
+	Upsert bool
+}
+
+// 返回修改前或修改后的文档(未找到为nil), 以及upsert生成的_id
+func (s *store) findAndModify(db, cl string, f *findAndModify) (value bson.D, upsertedId interface{}, err error) {
+	s.Lock()
+	defer s.Unlock()
+	all, err := s.load(db, cl)
+	if err != nil {
+		return nil, nil, err
+	}
+	matched, err := filterDocs(all, f.Query)
+	if err != nil {
+		return nil, nil, err
+	}
+	sortDocs(matched, f.Sort)
+	if len(matched) > 0 {
+		old := matched[0]
+		id, _ := lookup(old, "_id")
+		i := indexOfId(all, id)
+		value = old
+		if f.Remove {
+			all = append(all[:i], all[i+1:]...)
+		} else {
+			next, err := applyTo(old, f.Update, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			all[i] = next
+			if f.New {
+				value = next
+			}
+		}
+	} else if f.Upsert && !f.Remove {
+		doc, err := upsertDoc(&updateSpec{Filter: f.Query, Update: f.Update})
+		if err != nil {
+			return nil, nil, err
+		}
+		upsertedId, _ = lookup(doc, "_id")
+		if indexOfId(all, upsertedId) >= 0 {
+			return nil, nil, duplicateKey(db, cl, upsertedId)
+		}
+		all = append(all, doc)
+		if f.New {
+			value = doc
+		}
+	} else {
+		return nil, nil, nil
+	}
+	if err = s.save(db, cl, all); err != nil {
+		return nil, nil, err
+	}
+	if value != nil && len(f.Fields) > 0 {
+		if value, err = project(value, f.Fields); err != nil {
+			return nil, nil, toCodeError(err)
+		}
+	}
+	return
+}
@@ -0,0 +1,318 @@
+package mongodbtest
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 是否为操作符形式的更新(否则为replace文档)
+func isUpdateDoc(update bson.D) bool {
+	return len(update) > 0 && strings.HasPrefix(update[0].Key, "$")
+}
+
+// 应用replace文档, 保留原_id
+func applyReplace(doc bson.D, replace bson.D) (bson.D, error) {
+	id, hasId := lookup(doc, "_id")
+	if rid, ok := lookup(replace, "_id"); ok {
+		if hasId && !equal(id, rid) {
+			return nil, &codeError{Code: code_ImmutableField, Name: "ImmutableField", Message: fmt.Sprintf("the (immutable) field '_id' was found to have been altered to _id: %v", format(rid))}
+		}
+		return replace, nil
+	}
+	ret := append(bson.D{}, replace...)
+	if hasId {
+		ret = append(bson.D{{Key: "_id", Value: id}}, ret...)
+	}
+	return ret, nil
+}
+
+// 应用操作符更新, insert表示upsert插入($setOnInsert生效)
+func applyUpdate(doc bson.D, update bson.D, insert bool) (bson.D, error) {
+	var cur interface{} = doc
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("modifiers operate on fields but we found type %T instead", op.Value)
+		}
+		for _, f := range fields {
+			path := splitPath(f.Key)
+			if path[0] == "_id" && op.Key != "$setOnInsert" && !insert {
+				if old, ok := getPath(cur, path); !ok || op.Key != "$set" || !equal(old, f.Value) {
+					return nil, &codeError{Code: code_ImmutableField, Name: "ImmutableField", Message: "performing an update on the path '_id' would modify the immutable field '_id'"}
+				}
+			}
+			for _, p := range path {
+				if p == "$" || strings.HasPrefix(p, "$[") {
+					return nil, fmt.Errorf("positional operator is not supported: %v", f.Key)
+				}
+			}
+			old, exists := getPath(cur, path)
+			var err error
+			switch op.Key {
+			case "$set":
+				cur, err = setPath(cur, path, f.Value)
+			case "$setOnInsert":
+				if insert {
+					cur, err = setPath(cur, path, f.Value)
+				}
+			case "$unset":
+				cur = unsetPath(cur, path)
+			case "$inc", "$mul":
+				if exists && !isNumber(old) {
+					return nil, fmt.Errorf("cannot apply %v to a value of non-numeric type", op.Key)
+				}
+				if !isNumber(f.Value) {
+					return nil, fmt.Errorf("cannot %v with non-numeric argument", op.Key)
+				}
+				var val interface{}
+				if op.Key == "$inc" {
+					if !exists {
+						old = int32(0)
+					}
+					val = arith(old, f.Value, func(a, b int64) int64 { return a + b }, func(a, b float64) float64 { return a + b })
+				} else {
+					if !exists {
+						old = int32(0)
+					}
+					val = arith(old, f.Value, func(a, b int64) int64 { return a * b }, func(a, b float64) float64 { return a * b })
+				}
+				cur, err = setPath(cur, path, val)
+			case "$min", "$max":
+				if !exists || (op.Key == "$min" && compare(f.Value, old) < 0) || (op.Key == "$max" && compare(f.Value, old) > 0) {
+					cur, err = setPath(cur, path, f.Value)
+				}
+			case "$currentDate":
+				var val interface{} = primitive.NewDateTimeFromTime(time.Now())
+				if spec, ok := f.Value.(bson.D); ok {
+					if t, _ := lookup(spec, "$type"); t == "timestamp" {
+						val = primitive.Timestamp{T: uint32(time.Now().Unix()), I: 1}
+					}
+				}
+				cur, err = setPath(cur, path, val)
+			case "$rename":
+				to, ok := f.Value.(string)
+				if !ok {
+					return nil, fmt.Errorf("the 'to' field for $rename must be a string")
+				}
+				if exists {
+					cur = unsetPath(cur, path)
+					cur, err = setPath(cur, splitPath(to), old)
+				}
+			case "$push", "$addToSet", "$pull", "$pullAll", "$pop":
+				var arr bson.A
+				if exists {
+					if arr, ok = old.(bson.A); !ok {
+						return nil, fmt.Errorf("the field '%v' must be an array but is of type %T", f.Key, old)
+					}
+				}
+				if arr, err = applyArray(op.Key, arr, f.Value); err == nil {
+					if exists || op.Key == "$push" || op.Key == "$addToSet" {
+						cur, err = setPath(cur, path, arr)
+					}
+				}
+			default:
+				return nil, fmt.Errorf("unknown modifier: %v", op.Key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cur.(bson.D), nil
+}
+
+// 数值运算, 整数溢出或含浮点时转为float64, 与服务端的类型提升一致
+func arith(a, b interface{}, iop func(a, b int64) int64, fop func(a, b float64) float64) interface{} {
+	x, xok := toInt64(a)
+	y, yok := toInt64(b)
+	if xok && yok {
+		r := iop(x, y)
+		_, a32 := a.(int32)
+		_, b32 := b.(int32)
+		if a32 && b32 && r >= math.MinInt32 && r <= math.MaxInt32 {
+			return int32(r)
+		}
+		return r
+	}
+	return fop(toFloat(a), toFloat(b))
+}
+
+func applyArray(op string, arr bson.A, arg interface{}) (bson.A, error) {
+	ret := append(bson.A{}, arr...)
+	switch op {
+	case "$push", "$addToSet":
+		items := bson.A{arg}
+		var spec bson.D
+		if d, ok := arg.(bson.D); ok {
+			if each, ok := lookup(d, "$each"); ok {
+				if items, ok = each.(bson.A); !ok {
+					return nil, fmt.Errorf("the argument to $each must be an array")
+				}
+				spec = d
+			}
+		}
+		if op == "$addToSet" {
+			for _, item := range items {
+				if !contains(ret, item) {
+					ret = append(ret, item)
+				}
+			}
+			return ret, nil
+		}
+		pos := len(ret)
+		if v, ok := lookup(spec, "$position"); ok {
+			if n, ok := toInt64(v); ok {
+				if n < 0 {
+					n += int64(len(ret))
+				}
+				if n < 0 {
+					n = 0
+				}
+				if n < int64(len(ret)) {
+					pos = int(n)
+				}
+			}
+		}
+		ret = append(ret[:pos], append(append(bson.A{}, items...), ret[pos:]...)...)
+		if v, ok := lookup(spec, "$sort"); ok {
+			sortArray(ret, v)
+		}
+		if v, ok := lookup(spec, "$slice"); ok {
+			if n, ok := toInt64(v); ok {
+				if n >= 0 && n < int64(len(ret)) {
+					ret = ret[:n]
+				} else if n < 0 && -n < int64(len(ret)) {
+					ret = ret[int64(len(ret))+n:]
+				}
+			}
+		}
+		return ret, nil
+	case "$pull":
+		out := bson.A{}
+		for _, item := range ret {
+			var ok bool
+			var err error
+			if cond, isDoc := arg.(bson.D); isDoc {
+				if _, isOps := isOperatorDoc(cond); isOps {
+					ok, err = matchCond([]interface{}{item}, true, cond)
+				} else if doc, isDoc := item.(bson.D); isDoc {
+					ok, err = match(doc, cond)
+				}
+			} else {
+				ok = equal(item, arg)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	case "$pullAll":
+		items, ok := arg.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("$pullAll requires an array argument")
+		}
+		out := bson.A{}
+		for _, item := range ret {
+			if !contains(items, item) {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	case "$pop":
+		if len(ret) > 0 {
+			if toFloat(arg) < 0 {
+				return ret[1:], nil
+			}
+			return ret[:len(ret)-1], nil
+		}
+		return ret, nil
+	}
+	return ret, nil
+}
+
+func contains(arr bson.A, v interface{}) bool {
+	for _, item := range arr {
+		if equal(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortArray(arr bson.A, spec interface{}) {
+	if d, ok := spec.(bson.D); ok {
+		sort.SliceStable(arr, func(i, j int) bool {
+			x, _ := arr[i].(bson.D)
+			y, _ := arr[j].(bson.D)
+			return compareBySort(x, y, d) < 0
+		})
+		return
+	}
+	desc := toFloat(spec) < 0
+	sort.SliceStable(arr, func(i, j int) bool {
+		if desc {
+			return compare(arr[i], arr[j]) > 0
+		}
+		return compare(arr[i], arr[j]) < 0
+	})
+}
+
+// 由查询条件生成upsert的初始文档: 取其中的等值条件
+func upsertSeed(filter bson.D) (bson.D, error) {
+	var cur interface{} = bson.D{}
+	var err error
+	for _, e := range filter {
+		switch {
+		case e.Key == "$and":
+			arr, _ := e.Value.(bson.A)
+			for _, item := range arr {
+				sub, _ := item.(bson.D)
+				seed, err := upsertSeed(sub)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range seed {
+					if cur, err = setPath(cur, splitPath(f.Key), f.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case strings.HasPrefix(e.Key, "$"):
+		default:
+			if ops, ok := isOperatorDoc(e.Value); ok {
+				if v, ok := lookup(ops, "$eq"); ok {
+					cur, err = setPath(cur, splitPath(e.Key), v)
+				}
+			} else if _, ok := e.Value.(primitive.Regex); !ok {
+				cur, err = setPath(cur, splitPath(e.Key), e.Value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return cur.(bson.D), nil
+}
+
+// 确保_id存在且位于首位, 返回_id
+func ensureId(doc bson.D) (bson.D, interface{}) {
+	for i, e := range doc {
+		if e.Key == "_id" {
+			if i == 0 {
+				return doc, e.Value
+			}
+			ret := append(bson.D{e}, doc[:i]...)
+			return append(ret, doc[i+1:]...), e.Value
+		}
+	}
+	id := primitive.NewObjectID()
+	return append(bson.D{{Key: "_id", Value: id}}, doc...), id
+}
@@ -0,0 +1,409 @@
+package mongodbtest
+
+import (
+	"bytes"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 将任意文档(bson.M, bson.D, struct, bson.Raw)转为bson.D, 嵌套文档与数组分别为bson.D与bson.A
+func toD(v interface{}) (bson.D, error) {
+	switch v := v.(type) {
+	case nil:
+		return bson.D{}, nil
+	case bson.D:
+		return normalizeD(v)
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return rawToD(raw)
+}
+
+func rawToD(raw []byte) (bson.D, error) {
+	var ret bson.D
+	if err := bson.Unmarshal(raw, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func normalizeD(d bson.D) (bson.D, error) {
+	raw, err := bson.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return rawToD(raw)
+}
+
+// 将数组形式的参数(管道, 文档列表)转为[]bson.D
+func toDs(v interface{}) ([]bson.D, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+	d, err := rawToD(raw)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := d[0].Value.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("expect array but %T", d[0].Value)
+	}
+	ret := make([]bson.D, 0, len(arr))
+	for _, item := range arr {
+		doc, ok := item.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("expect document but %T", item)
+		}
+		ret = append(ret, doc)
+	}
+	return ret, nil
+}
+
+// 单个值的规范化, 例如int转为int32/int64, time.Time转为primitive.DateTime
+func normalize(v interface{}) (interface{}, error) {
+	d, err := toD(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+	return d[0].Value, nil
+}
+
+func lookup(d bson.D, key string) (interface{}, bool) {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// 按路径解析全部候选值, 数组字段展开(与查询语义一致)
+func resolve(v interface{}, path []string) (vals []interface{}, found bool) {
+	if len(path) == 0 {
+		return []interface{}{v}, true
+	}
+	switch v := v.(type) {
+	case bson.D:
+		child, ok := lookup(v, path[0])
+		if !ok {
+			return nil, false
+		}
+		return resolve(child, path[1:])
+	case bson.A:
+		if idx, err := strconv.Atoi(path[0]); err == nil {
+			if idx >= 0 && idx < len(v) {
+				if vs, ok := resolve(v[idx], path[1:]); ok {
+					vals, found = append(vals, vs...), true
+				}
+			}
+		}
+		for _, item := range v {
+			if doc, ok := item.(bson.D); ok {
+				if vs, ok := resolve(doc, path); ok {
+					vals, found = append(vals, vs...), true
+				}
+			}
+		}
+	}
+	return
+}
+
+// 按路径精确取值, 数组仅支持数字下标
+func getPath(v interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, true
+	}
+	switch v := v.(type) {
+	case bson.D:
+		if child, ok := lookup(v, path[0]); ok {
+			return getPath(child, path[1:])
+		}
+	case bson.A:
+		if idx, err := strconv.Atoi(path[0]); err == nil && idx >= 0 && idx < len(v) {
+			return getPath(v[idx], path[1:])
+		}
+	}
+	return nil, false
+}
+
+// 按路径设值, 自动创建中间文档
+func setPath(v interface{}, path []string, val interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+	switch c := v.(type) {
+	case nil:
+		child, err := setPath(nil, path[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: path[0], Value: child}}, nil
+	case bson.D:
+		for i, e := range c {
+			if e.Key == path[0] {
+				child, err := setPath(e.Value, path[1:], val)
+				if err != nil {
+					return nil, err
+				}
+				ret := append(bson.D{}, c...)
+				ret[i].Value = child
+				return ret, nil
+			}
+		}
+		child, err := setPath(nil, path[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		return append(append(bson.D{}, c...), bson.E{Key: path[0], Value: child}), nil
+	case bson.A:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 {
+			return nil, fmt.Errorf("cannot create field '%v' in array", path[0])
+		}
+		ret := append(bson.A{}, c...)
+		for len(ret) <= idx {
+			ret = append(ret, nil)
+		}
+		if ret[idx], err = setPath(ret[idx], path[1:], val); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("cannot create field '%v' in element of type %T", path[0], v)
+	}
+}
+
+func unsetPath(v interface{}, path []string) interface{} {
+	switch c := v.(type) {
+	case bson.D:
+		for i, e := range c {
+			if e.Key == path[0] {
+				ret := append(bson.D{}, c[:i]...)
+				if len(path) == 1 {
+					return append(ret, c[i+1:]...)
+				}
+				ret = append(ret, bson.E{Key: e.Key, Value: unsetPath(e.Value, path[1:])})
+				return append(ret, c[i+1:]...)
+			}
+		}
+	case bson.A:
+		if idx, err := strconv.Atoi(path[0]); err == nil && idx >= 0 && idx < len(c) {
+			ret := append(bson.A{}, c...)
+			if len(path) == 1 {
+				ret[idx] = nil // 与服务端一致, 数组元素置为null
+			} else {
+				ret[idx] = unsetPath(c[idx], path[1:])
+			}
+			return ret
+		}
+	}
+	return v
+}
+
+// BSON类型排序, 见https://docs.mongodb.com/manual/reference/bson-type-comparison-order/
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 1
+	case nil, primitive.Null, primitive.Undefined:
+		return 2
+	case int32, int64, float64, int, primitive.Decimal128:
+		return 3
+	case string, primitive.Symbol:
+		return 4
+	case bson.D:
+		return 5
+	case bson.A:
+		return 6
+	case primitive.Binary:
+		return 7
+	case primitive.ObjectID:
+		return 8
+	case bool:
+		return 9
+	case primitive.DateTime:
+		return 10
+	case primitive.Timestamp:
+		return 11
+	case primitive.Regex:
+		return 12
+	case primitive.MaxKey:
+		return 14
+	}
+	return 13
+}
+
+func isNumber(v interface{}) bool {
+	return typeOrder(v) == 3
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	case primitive.Decimal128:
+		f, _ := strconv.ParseFloat(v.String(), 64)
+		return f
+	}
+	return math.NaN()
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+	if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+// 按BSON排序规则比较两个值
+func compare(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return sign(ta - tb)
+	}
+	switch a := a.(type) {
+	case int32, int64, int, float64, primitive.Decimal128:
+		if x, ok := toInt64(a); ok {
+			if y, ok := toInt64(b); ok {
+				switch {
+				case x < y:
+					return -1
+				case x > y:
+					return 1
+				}
+				return 0
+			}
+		}
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, fmt.Sprint(b))
+	case primitive.Symbol:
+		return strings.Compare(string(a), fmt.Sprint(b))
+	case bson.D:
+		d := b.(bson.D)
+		for i := 0; i < len(a) && i < len(d); i++ {
+			if c := strings.Compare(a[i].Key, d[i].Key); c != 0 {
+				return c
+			}
+			if c := compare(a[i].Value, d[i].Value); c != 0 {
+				return c
+			}
+		}
+		return sign(len(a) - len(d))
+	case bson.A:
+		d := b.(bson.A)
+		for i := 0; i < len(a) && i < len(d); i++ {
+			if c := compare(a[i], d[i]); c != 0 {
+				return c
+			}
+		}
+		return sign(len(a) - len(d))
+	case primitive.Binary:
+		return bytes.Compare(a.Data, b.(primitive.Binary).Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(a[:], y[:])
+	case bool:
+		y := b.(bool)
+		if a == y {
+			return 0
+		}
+		if !a {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return sign64(int64(a) - int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		y := b.(primitive.Timestamp)
+		if a.T != y.T {
+			return sign64(int64(a.T) - int64(y.T))
+		}
+		return sign64(int64(a.I) - int64(y.I))
+	case primitive.Regex:
+		y := b.(primitive.Regex)
+		return strings.Compare(a.Pattern+"/"+a.Options, y.Pattern+"/"+y.Options)
+	}
+	return 0
+}
+
+func sign64(n int64) int {
+	if n < 0 {
+		return -1
+	}
+	if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+func equal(a, b interface{}) bool {
+	return compare(a, b) == 0
+}
+
+// 按mongo shell风格格式化值, 用于错误信息
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case primitive.ObjectID:
+		return "ObjectId('" + v.Hex() + "')"
+	case bson.D:
+		parts := make([]string, 0, len(v))
+		for _, e := range v {
+			parts = append(parts, e.Key+": "+format(e.Value))
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	case bson.A:
+		parts := make([]string, 0, len(v))
+		for _, e := range v {
+			parts = append(parts, format(e))
+		}
+		return "[ " + strings.Join(parts, ", ") + " ]"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
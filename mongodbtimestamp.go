@@ -0,0 +1,307 @@
+package mongodb
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCreatedAtField = "createdAt"
+	defaultUpdatedAtField = "updatedAt"
+)
+
+// 集合的自动时间戳策略. 字段已存在且非零值时保留调用方的值
+//   - InsertOne/InsertMany: 写入createdAt及updatedAt
+//   - UpdateXXX/FindXXXAndUpdate: $set中加入updatedAt, upsert时$setOnInsert中加入createdAt
+//   - ReplaceXXX/FindXXXAndReplace: 写入updatedAt, 并保留原文档的createdAt(在熔断, 重试等环节之内读取)
+type Timestamps struct {
+	CreatedAt string           // 创建时间字段, 默认createdAt
+	UpdatedAt string           // 更新时间字段, 默认updatedAt
+	Now       func() time.Time // 时钟, 默认time.Now, 测试时可注入
+}
+
+func (cc *Client) Timestamps(cl string, ts *Timestamps) *Client {
+	return cc.DBTimestamps(cc.DB, cl, ts)
+}
+
+// 为集合启用自动时间戳, ts为nil时取消
+func (cc *Client) DBTimestamps(db string, cl string, ts *Timestamps) *Client {
+	if ts == nil {
+		cc.timestamps.Delete(db + "." + cl)
+		return cc
+	}
+	t := *ts
+	if t.CreatedAt == "" {
+		t.CreatedAt = defaultCreatedAtField
+	}
+	if t.UpdatedAt == "" {
+		t.UpdatedAt = defaultUpdatedAtField
+	}
+	if t.Now == nil {
+		t.Now = time.Now
+	}
+	cc.timestamps.Store(db+"."+cl, &t)
+	return cc
+}
+
+// 在execDatabase中调用, 按策略改写操作的文档
+func (cc *Client) stamp(op *Operation) (err error) {
+	ts := cc.timestampsOf(op.Database, op.Collection)
+	if ts == nil {
+		return nil
+	}
+	now := ts.Now()
+	switch op.Name {
+	case Op_InsertOne:
+		op.Document, err = ts.stampInsert(op.Document, now)
+	case Op_InsertMany:
+		docs := make([]interface{}, len(op.Documents))
+		for i, doc := range op.Documents {
+			if docs[i], err = ts.stampInsert(doc, now); err != nil {
+				return
+			}
+		}
+		op.Documents = docs
+	case Op_UpdateId, Op_UpdateOne, Op_UpdateMany, Op_FindIdAndUpdate, Op_FindOneAndUpdate:
+		op.Update, err = ts.stampUpdate(op.Update, isUpsert(op), now)
+	case Op_ReplaceId, Op_ReplaceOne, Op_FindIdAndReplace, Op_FindOneAndReplace:
+		op.Update, op.keep, err = ts.stampReplace(op.Update, now)
+	}
+	return
+}
+
+func (cc *Client) timestampsOf(db string, cl string) *Timestamps {
+	if cc.timestamps == nil {
+		return nil
+	}
+	if v, ok := cc.timestamps.Load(db + "." + cl); ok {
+		return v.(*Timestamps)
+	}
+	return nil
+}
+
+func (ts *Timestamps) stampInsert(doc interface{}, now time.Time) (interface{}, error) {
+	d, err := toD(doc)
+	if err != nil {
+		return nil, err
+	}
+	d = setIfZero(d, ts.CreatedAt, now)
+	return setIfZero(d, ts.UpdatedAt, now), nil
+}
+
+func (ts *Timestamps) stampUpdate(update interface{}, upsert bool, now time.Time) (interface{}, error) {
+	if pipeline, ok := toPipeline(update); ok {
+		pipeline = append(pipeline, bson.D{{Key: "$set", Value: bson.D{{Key: ts.UpdatedAt, Value: now}}}})
+		if upsert {
+			pipeline = append(pipeline, bson.D{{Key: "$set", Value: bson.D{{Key: ts.CreatedAt, Value: bson.D{{Key: "$ifNull", Value: bson.A{"$" + ts.CreatedAt, now}}}}}}})
+		}
+		return pipeline, nil
+	}
+	d, err := toD(update)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range d {
+		if !strings.HasPrefix(e.Key, "$") {
+			return update, nil // 非操作符文档, 交由驱动报错
+		}
+	}
+	// 调用方已显式设置的字段不再覆盖
+	if !updatesField(d, ts.UpdatedAt) {
+		if d, err = addToOperator(d, "$set", ts.UpdatedAt, now); err != nil {
+			return nil, err
+		}
+	}
+	if upsert && !updatesField(d, ts.CreatedAt) {
+		if d, err = addToOperator(d, "$setOnInsert", ts.CreatedAt, now); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// 写入updatedAt, 替换文档不含createdAt时返回需从原文档保留的字段
+func (ts *Timestamps) stampReplace(replacement interface{}, now time.Time) (interface{}, string, error) {
+	d, err := toD(replacement)
+	if err != nil {
+		return nil, "", err
+	}
+	d = setIfZero(d, ts.UpdatedAt, now)
+	if !isZeroField(d, ts.CreatedAt) {
+		return d, "", nil
+	}
+	return d, ts.CreatedAt, nil
+}
+
+// 在熔断, 重试, 并发限制之内调用: 读取原文档的保留字段写入替换文档, 原文档不存在(upsert插入)时为当前时间.
+// 按替换操作的排序及collation读取, 并将条件限定为所读文档的_id, 保证保留字段与被替换的文档一致
+func (cc *Client) keepField(op *Operation) error {
+	if op.keep == "" {
+		return nil
+	}
+	d, err := toD(op.Update)
+	if err != nil {
+		return err
+	}
+	fo := options.FindOne().SetProjection(bson.D{{Key: op.keep, Value: 1}})
+	switch opts := op.Options.(type) {
+	case []*options.ReplaceOptions:
+		fo.Collation = options.MergeReplaceOptions(opts...).Collation
+	case []*options.FindOneAndReplaceOptions:
+		o := options.MergeFindOneAndReplaceOptions(opts...)
+		fo.Collation, fo.Sort = o.Collation, o.Sort
+	}
+	coll := cc.Database(op.Database).Collection(op.Collection, cc.collectionOptions)
+	raw, err := coll.FindOne(op.Context, op.Filter, fo).DecodeBytes()
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	var val interface{}
+	if err == nil {
+		if v, err := raw.LookupErr(op.keep); err == nil {
+			val = v
+		}
+		if op.Name == Op_ReplaceOne || op.Name == Op_FindOneAndReplace {
+			op.Filter = bson.D{{Key: "$and", Value: bson.A{allIfNil(op.Filter), bson.D{{Key: "_id", Value: raw.Lookup("_id")}}}}}
+		}
+	}
+	if val == nil {
+		now := time.Now
+		if ts := cc.timestampsOf(op.Database, op.Collection); ts != nil {
+			now = ts.Now
+		}
+		val = now()
+	}
+	op.Update, op.keep = setField(d, op.keep, val), "" // 重试时不再读取
+	return nil
+}
+
+func isUpsert(op *Operation) bool {
+	switch opts := op.Options.(type) {
+	case []*options.UpdateOptions:
+		o := options.MergeUpdateOptions(opts...)
+		return o.Upsert != nil && *o.Upsert
+	case []*options.FindOneAndUpdateOptions:
+		o := options.MergeFindOneAndUpdateOptions(opts...)
+		return o.Upsert != nil && *o.Upsert
+	case []*options.ReplaceOptions:
+		o := options.MergeReplaceOptions(opts...)
+		return o.Upsert != nil && *o.Upsert
+	case []*options.FindOneAndReplaceOptions:
+		o := options.MergeFindOneAndReplaceOptions(opts...)
+		return o.Upsert != nil && *o.Upsert
+	}
+	return false
+}
+
+// 编码为bson.D, 保留字段顺序
+func toD(doc interface{}) (bson.D, error) {
+	bs, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := bson.Raw(bs).Elements()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(bson.D, 0, len(elems)+2)
+	for _, elem := range elems {
+		ret = append(ret, bson.E{Key: elem.Key(), Value: elem.Value()})
+	}
+	return ret, nil
+}
+
+// 管道形式的更新, 编码后为数组
+func toPipeline(update interface{}) (bson.A, bool) {
+	bs, err := bson.Marshal(bson.D{{Key: "u", Value: update}})
+	if err != nil {
+		return nil, false
+	}
+	arr, ok := bson.Raw(bs).Lookup("u").ArrayOK()
+	if !ok {
+		return nil, false
+	}
+	vals, err := arr.Values()
+	if err != nil {
+		return nil, false
+	}
+	ret := make(bson.A, len(vals))
+	for i, val := range vals {
+		ret[i] = val
+	}
+	return ret, true
+}
+
+// 字段不存在, 为null或零值时间
+func isZeroField(d bson.D, key string) bool {
+	for _, e := range d {
+		if e.Key != key {
+			continue
+		}
+		val, ok := e.Value.(bson.RawValue)
+		if !ok {
+			return e.Value == nil
+		}
+		switch val.Type {
+		case bsontype.Null, bsontype.Undefined:
+			return true
+		case bsontype.DateTime:
+			return val.Time().Equal(time.Time{})
+		}
+		return false
+	}
+	return true
+}
+
+func setIfZero(d bson.D, key string, val interface{}) bson.D {
+	if !isZeroField(d, key) {
+		return d
+	}
+	for i, e := range d {
+		if e.Key == key {
+			d[i].Value = val
+			return d
+		}
+	}
+	return append(d, bson.E{Key: key, Value: val})
+}
+
+// 更新文档的任一操作符是否已涉及该字段
+func updatesField(d bson.D, key string) bool {
+	for _, e := range d {
+		if val, ok := e.Value.(bson.RawValue); ok {
+			if doc, ok := val.DocumentOK(); ok {
+				if _, err := doc.LookupErr(key); err == nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// 在操作符文档中加入字段, 操作符不存在时新增
+func addToOperator(d bson.D, operator string, key string, val interface{}) (bson.D, error) {
+	for i, e := range d {
+		if e.Key != operator {
+			continue
+		}
+		value := e.Value
+		if rv, ok := value.(bson.RawValue); ok {
+			if doc, ok := rv.DocumentOK(); ok {
+				value = doc
+			}
+		}
+		sub, err := toD(value)
+		if err != nil {
+			return nil, err
+		}
+		d[i].Value = append(sub, bson.E{Key: key, Value: val})
+		return d, nil
+	}
+	return append(d, bson.E{Key: operator, Value: bson.D{{Key: key, Value: val}}}), nil
+}
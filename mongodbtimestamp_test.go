@@ -0,0 +1,93 @@
+package mongodb_test
+
+import (
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+	"time"
+)
+
+func TestClient_Timestamps(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cc := mdb.Timestamps("user", &mongodb.Timestamps{
+		UpdatedAt: "modifiedAt",
+		Now:       func() time.Time { return now },
+	})
+	defer cc.Close()
+	at := func(d time.Duration) primitive.DateTime {
+		return primitive.NewDateTimeFromTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(d))
+	}
+	get := func(id interface{}) bson.M {
+		var m bson.M
+		if _, err := cc.FindId("user", id, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	if _, err := cc.InsertOne("user", struct {
+		Id        int       `bson:"_id"`
+		CreatedAt time.Time `bson:"createdAt"`
+	}{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.InsertMany("user", []interface{}{bson.M{"_id": 2}, bson.M{"_id": 3, "createdAt": at(-time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+	if m := get(1); m["createdAt"] != at(0) || m["modifiedAt"] != at(0) {
+		t.Fatalf("insert: %v", m)
+	}
+	if m := get(3); m["createdAt"] != at(-time.Hour) || m["modifiedAt"] != at(0) {
+		t.Fatalf("insert keeps createdAt: %v", m)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := cc.UpdateId("user", 1, bson.M{"$set": bson.M{"n": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if m := get(1); m["createdAt"] != at(0) || m["modifiedAt"] != at(time.Minute) || m["n"] != int32(1) {
+		t.Fatalf("update: %v", m)
+	}
+	if _, err := cc.UpdateOne("user", bson.M{"_id": 4}, bson.M{"$inc": bson.M{"n": 1}}, options.Update().SetUpsert(true)); err != nil {
+		t.Fatal(err)
+	}
+	if m := get(4); m["createdAt"] != at(time.Minute) || m["modifiedAt"] != at(time.Minute) {
+		t.Fatalf("upsert: %v", m)
+	}
+	var ret bson.M
+	if _, err := cc.FindIdAndUpdate("user", 2, bson.M{"$set": bson.M{"n": 2}}, &ret, options.FindOneAndUpdate().SetReturnDocument(options.After)); err != nil || ret["modifiedAt"] != at(time.Minute) {
+		t.Fatalf("find and update: %v %v", ret, err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := cc.ReplaceId("user", 1, bson.M{"name": "tom"}); err != nil {
+		t.Fatal(err)
+	}
+	if m := get(1); m["createdAt"] != at(0) || m["modifiedAt"] != at(2*time.Minute) || m["name"] != "tom" {
+		t.Fatalf("replace: %v", m)
+	}
+	// 保留字段按替换的排序读取, 与被替换的文档一致
+	if _, err := cc.FindOneAndReplace("user", bson.M{"_id": bson.M{"$in": bson.A{1, 3}}}, bson.M{"name": "jack"}, nil, options.FindOneAndReplace().SetSort(bson.M{"_id": -1})); err != nil {
+		t.Fatal(err)
+	}
+	if m := get(3); m["createdAt"] != at(-time.Hour) || m["name"] != "jack" {
+		t.Fatalf("find and replace: %v", m)
+	}
+
+	// 未启用的集合不受影响
+	if _, err := cc.InsertOne("other", bson.M{"_id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var m bson.M
+	if _, err := cc.FindId("other", 1, &m); err != nil || len(m) != 1 {
+		t.Fatalf("other: %v %v", m, err)
+	}
+}
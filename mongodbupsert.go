@@ -0,0 +1,128 @@
+package mongodb
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+)
+
+const (
+	UpsertMode_replace = "replace" // 整体替换已存在的文档
+	UpsertMode_merge   = "merge"   // 以$set更新字段, 已存在文档的其他字段保留
+)
+
+// 批量upsert选项
+type UpsertOptions struct {
+	KeyFields  []string      // 匹配键字段, 支持a.b形式, 默认_id
+	Mode       string        // replace(默认) | merge
+	InsertOnly []string      // merge模式下仅在插入时写入的顶层字段($setOnInsert), 如createdAt
+	Chunk      *ChunkOptions // 分块选项, 见BulkWriteChunked
+}
+
+// 批量upsert结果
+type UpsertResult struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	UpsertedIDs   map[int]interface{} // 新插入文档的_id, 键为docs中的下标
+}
+
+// 按keyFields批量upsert, 整体替换已存在的文档
+func (cc *Client) UpsertMany(cl string, docs []interface{}, keyFields ...string) (*UpsertResult, error) {
+	return cc.DBUpsertManyOpt(cc.DB, cl, docs, &UpsertOptions{KeyFields: keyFields})
+}
+
+func (cc *Client) DBUpsertMany(db string, cl string, docs []interface{}, keyFields ...string) (*UpsertResult, error) {
+	return cc.DBUpsertManyOpt(db, cl, docs, &UpsertOptions{KeyFields: keyFields})
+}
+
+func (cc *Client) UpsertManyOpt(cl string, docs []interface{}, opt *UpsertOptions) (*UpsertResult, error) {
+	return cc.DBUpsertManyOpt(cc.DB, cl, docs, opt)
+}
+
+// 批量upsert, docs为struct或map. 键字段缺失时返回错误, 不写入任何文档
+func (cc *Client) DBUpsertManyOpt(db string, cl string, docs []interface{}, opt *UpsertOptions) (result *UpsertResult, err error) {
+	uo := UpsertOptions{}
+	if opt != nil {
+		uo = *opt
+	}
+	if len(uo.KeyFields) == 0 {
+		uo.KeyFields = []string{"_id"}
+	}
+	op := &Operation{Database: db, Collection: cl, Name: Op_BulkWrite}
+	if v := cc.validationOf(db, cl); v != nil {
+		if err = v.validateDocs(true, docs...); err != nil {
+			return nil, cc.wrapError(op, err)
+		}
+	}
+	models := make([]mongo.WriteModel, len(docs))
+	for i, doc := range docs {
+		if models[i], err = upsertModel(doc, &uo); err != nil {
+			return nil, cc.wrapError(op, fmt.Errorf("upsert document %v: %v", i, err))
+		}
+	}
+
+	ret, err := cc.DBBulkWriteChunked(db, cl, models, uo.Chunk)
+	result = &UpsertResult{UpsertedIDs: make(map[int]interface{})}
+	if ret != nil {
+		result.InsertedCount = ret.UpsertedCount
+		result.MatchedCount = ret.MatchedCount
+		result.ModifiedCount = ret.ModifiedCount
+		for idx, id := range ret.UpsertedIDs {
+			result.UpsertedIDs[int(idx)] = id
+		}
+	}
+	return
+}
+
+func upsertModel(doc interface{}, uo *UpsertOptions) (mongo.WriteModel, error) {
+	bs, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	raw := bson.Raw(bs)
+	filter := make(bson.D, 0, len(uo.KeyFields))
+	for _, key := range uo.KeyFields {
+		val, err := raw.LookupErr(strings.Split(key, ".")...)
+		if err != nil {
+			return nil, fmt.Errorf("missing key field %v", key)
+		}
+		filter = append(filter, bson.E{Key: key, Value: val})
+	}
+	if uo.Mode != UpsertMode_merge {
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(raw).SetUpsert(true), nil
+	}
+
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	var set, setOnInsert bson.D
+	for _, elem := range elems {
+		e := bson.E{Key: elem.Key(), Value: elem.Value()}
+		// _id不可修改, 仅在插入时写入
+		if e.Key == "_id" || containsString(uo.InsertOnly, e.Key) {
+			setOnInsert = append(setOnInsert, e)
+		} else {
+			set = append(set, e)
+		}
+	}
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(setOnInsert) > 0 {
+		update = append(update, bson.E{Key: "$setOnInsert", Value: setOnInsert})
+	}
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true), nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package mongodb_test
+
+import (
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+type upsertItem struct {
+	Sku     string `bson:"sku"`
+	Region  string `bson:"region"`
+	Price   int    `bson:"price"`
+	Created string `bson:"created,omitempty"`
+}
+
+func TestClient_UpsertMany(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	if _, err := cc.InsertOne("item", bson.M{"sku": "a", "region": "cn", "price": 1, "stock": 5}); err != nil {
+		t.Fatal(err)
+	}
+	docs := []interface{}{
+		&upsertItem{Sku: "b", Region: "cn", Price: 2},
+		&upsertItem{Sku: "a", Region: "cn", Price: 10},
+		bson.M{"sku": "a", "region": "us", "price": 3},
+	}
+	ret, err := cc.UpsertMany("item", docs, "sku", "region")
+	if err != nil || ret.InsertedCount != 2 || ret.MatchedCount != 1 || ret.ModifiedCount != 1 {
+		t.Fatalf("replace: %+v %v", ret, err)
+	}
+	if _, ok := ret.UpsertedIDs[0]; !ok || len(ret.UpsertedIDs) != 2 || ret.UpsertedIDs[2] == nil {
+		t.Fatalf("upserted ids: %v", ret.UpsertedIDs)
+	}
+	var m bson.M
+	if _, err := cc.FindOne("item", bson.M{"sku": "a", "region": "cn"}, &m); err != nil || m["price"] != int32(10) || m["stock"] != nil {
+		t.Fatalf("replaced: %v %v", m, err)
+	}
+
+	// merge: 保留其他字段, created仅插入时写入
+	docs = []interface{}{
+		&upsertItem{Sku: "b", Region: "cn", Price: 20, Created: "today"},
+		&upsertItem{Sku: "c", Region: "cn", Price: 30, Created: "today"},
+	}
+	if _, err := cc.UpdateOne("item", bson.M{"sku": "b"}, bson.M{"$set": bson.M{"stock": 7, "created": "yesterday"}}); err != nil {
+		t.Fatal(err)
+	}
+	ret, err = cc.UpsertManyOpt("item", docs, &mongodb.UpsertOptions{KeyFields: []string{"sku", "region"}, Mode: mongodb.UpsertMode_merge, InsertOnly: []string{"created"}})
+	if err != nil || ret.InsertedCount != 1 || ret.MatchedCount != 1 || ret.UpsertedIDs[1] == nil {
+		t.Fatalf("merge: %+v %v", ret, err)
+	}
+	m = nil
+	if _, err := cc.FindOne("item", bson.M{"sku": "b"}, &m); err != nil || m["price"] != int32(20) || m["stock"] != int32(7) || m["created"] != "yesterday" {
+		t.Fatalf("merged: %v %v", m, err)
+	}
+	m = nil
+	if _, err := cc.FindOne("item", bson.M{"sku": "c"}, &m); err != nil || m["created"] != "today" {
+		t.Fatalf("inserted: %v %v", m, err)
+	}
+
+	if _, err := cc.UpsertMany("item", []interface{}{bson.M{"sku": "d"}}, "sku", "region"); err == nil {
+		t.Fatal("expect missing key error")
+	}
+	if n, _ := cc.Count("item", nil); n != 4 {
+		t.Fatalf("count: %v", n)
+	}
+}
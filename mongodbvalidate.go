@@ -0,0 +1,467 @@
+package mongodb
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const defaultValidateTag = "validate"
+
+// 集合的写前校验策略, 按文档中struct的标签校验(嵌套struct及切片/map中的struct递归校验), bson.M等不含struct的文档不受影响. 标签规则以逗号分隔:
+//   - required: 非零值, 字符串/切片/map非空
+//   - min=n, max=n, len=n: 数值比较大小, 字符串(按字符)/切片/map比较长度
+//   - enum=a|b|c: 取值之一
+//   - regex=expr: 字符串匹配正则, 须为最后一条规则(正则可含逗号)
+//   - dive: 其后的规则作用于切片/map的每个元素
+type Validation struct {
+	Tag    string      // 标签名, 默认validate
+	Update bool        // 同时校验UpdateXXX/FindXXXAndUpdate的$set, 字段类型及规则取自Model
+	Model  interface{} // $set的目标struct类型, 默认为Model注册的类型
+}
+
+// 单个字段的校验失败
+type FieldError struct {
+	Index   int    // InsertMany/UpsertMany/InsertManyChunked中文档的下标, 单文档操作为-1
+	Path    string // bson字段路径, 如items.0.name
+	Rule    string // 失败的规则
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	if e.Index >= 0 {
+		return fmt.Sprintf("[%v] %v: %v", e.Index, e.Path, e.Message)
+	}
+	return e.Path + ": " + e.Message
+}
+
+// 校验失败, 包含全部失败字段
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return "mongodb validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (cc *Client) Validation(cl string, v *Validation) *Client {
+	return cc.DBValidation(cc.DB, cl, v)
+}
+
+// 为集合启用写前校验, 作用于InsertOne/InsertMany/InsertManyChunked/ReplaceXXX/FindXXXAndReplace/UpsertMany. v为nil时取消
+func (cc *Client) DBValidation(db string, cl string, v *Validation) *Client {
+	if v == nil {
+		cc.validations.Delete(db + "." + cl)
+		return cc
+	}
+	s := *v
+	if s.Tag == "" {
+		s.Tag = defaultValidateTag
+	}
+	cc.validations.Store(db+"."+cl, &s)
+	return cc
+}
+
+// 按默认标签校验单个文档, 用于手动校验
+func Validate(doc interface{}) error {
+	vd := &validator{tag: defaultValidateTag, index: -1}
+	vd.validateValue("", reflect.ValueOf(doc), nil)
+	return vd.result()
+}
+
+func (cc *Client) validationOf(db string, cl string) *Validation {
+	if cc.validations != nil {
+		if v, ok := cc.validations.Load(db + "." + cl); ok {
+			return v.(*Validation)
+		}
+	}
+	return nil
+}
+
+// 在execDatabase中调用, 按策略校验操作的文档
+func (cc *Client) validate(op *Operation) error {
+	v := cc.validationOf(op.Database, op.Collection)
+	if v == nil {
+		return nil
+	}
+	switch op.Name {
+	case Op_InsertOne:
+		return v.validateDocs(false, op.Document)
+	case Op_InsertMany:
+		return v.validateDocs(true, op.Documents...)
+	case Op_ReplaceId, Op_ReplaceOne, Op_FindIdAndReplace, Op_FindOneAndReplace:
+		return v.validateDocs(false, op.Update)
+	case Op_UpdateId, Op_UpdateOne, Op_UpdateMany, Op_FindIdAndUpdate, Op_FindOneAndUpdate:
+		if v.Update {
+			return cc.validateSet(v, op)
+		}
+	}
+	return nil
+}
+
+// 批量操作(InsertMany/UpsertMany/InsertManyChunked)时FieldError.Index为下标, 只有一个文档时亦然
+func (v *Validation) validateDocs(batch bool, docs ...interface{}) error {
+	vd := &validator{tag: v.Tag, index: -1}
+	for i, doc := range docs {
+		if batch {
+			vd.index = i
+		}
+		vd.validateValue("", reflect.ValueOf(doc), nil)
+	}
+	return vd.result()
+}
+
+// 校验$set中的各字段: 值须可解码为目标struct对应字段的类型, 并满足该字段的规则
+func (cc *Client) validateSet(v *Validation, op *Operation) error {
+	model := v.Model
+	if model == nil && cc.models != nil {
+		if t, ok := cc.models.Load(op.Database + "." + op.Collection); ok {
+			model = reflect.New(t.(reflect.Type)).Interface()
+		}
+	}
+	if model == nil {
+		return nil
+	}
+	if _, ok := toPipeline(op.Update); ok {
+		return nil
+	}
+	d, err := toD(op.Update)
+	if err != nil {
+		return err
+	}
+	var set bson.Raw
+	for _, e := range d {
+		if e.Key == "$set" {
+			if val, ok := e.Value.(bson.RawValue); ok {
+				set, _ = val.DocumentOK()
+			}
+		}
+	}
+	if set == nil {
+		return nil
+	}
+	elems, err := set.Elements()
+	if err != nil {
+		return err
+	}
+	t := reflect.TypeOf(model)
+	vd := &validator{tag: v.Tag, index: -1}
+	for _, elem := range elems {
+		path := elem.Key()
+		ft, rules, ok := vd.lookup(t, strings.Split(path, "."))
+		if !ok {
+			vd.add(path, "field", "unknown field")
+			continue
+		}
+		if ft == nil {
+			continue // interface{}, 不限类型
+		}
+		ptr := reflect.New(ft)
+		if err := elem.Value().Unmarshal(ptr.Interface()); err != nil {
+			vd.add(path, "type", fmt.Sprintf("cannot be decoded as %v: %v", ft, err))
+			continue
+		}
+		vd.validateValue(path, ptr.Elem(), rules)
+	}
+	return vd.result()
+}
+
+type rule struct {
+	name  string
+	param string
+}
+
+type validator struct {
+	tag    string
+	index  int
+	errors []*FieldError
+}
+
+func (vd *validator) add(path string, rule string, msg string) {
+	vd.errors = append(vd.errors, &FieldError{Index: vd.index, Path: path, Rule: rule, Message: msg})
+}
+
+func (vd *validator) result() error {
+	if len(vd.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: vd.errors}
+}
+
+func (vd *validator) validateValue(path string, v reflect.Value, rules []rule) {
+	own, elem := rules, []rule(nil)
+	for i, r := range rules {
+		if r.name == "dive" {
+			own, elem = rules[:i], rules[i+1:]
+			break
+		}
+	}
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		for _, r := range own {
+			if r.name == "required" {
+				vd.add(path, r.name, "is required")
+			}
+		}
+		return
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	for _, r := range own {
+		if msg, ok := checkRule(r, v); !ok {
+			vd.add(path, r.name, msg)
+			if r.name == "required" {
+				return
+			}
+		}
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		vd.validateStruct(path, v)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return // []byte, ObjectID等
+		}
+		for i := 0; i < v.Len(); i++ {
+			vd.validateValue(joinPath(path, strconv.Itoa(i)), v.Index(i), elem)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			vd.validateValue(joinPath(path, fmt.Sprint(iter.Key().Interface())), iter.Value(), elem)
+		}
+	}
+}
+
+func (vd *validator) validateStruct(path string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, inline, ok := bsonField(f)
+		if !ok {
+			continue
+		}
+		if inline {
+			vd.validateValue(path, v.Field(i), parseRules(f.Tag.Get(vd.tag)))
+			continue
+		}
+		vd.validateValue(joinPath(path, name), v.Field(i), parseRules(f.Tag.Get(vd.tag)))
+	}
+}
+
+// 按bson路径查找字段类型及规则, 类型为nil表示interface{}
+func (vd *validator) lookup(t reflect.Type, keys []string) (reflect.Type, []rule, bool) {
+	var rules []rule
+	for len(keys) > 0 {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Interface:
+			return nil, nil, true
+		case reflect.Struct:
+			f, ok := findBsonField(t, keys[0])
+			if !ok {
+				return nil, nil, false
+			}
+			t, rules = f.Type, parseRules(f.Tag.Get(vd.tag))
+		case reflect.Slice, reflect.Array:
+			if _, err := strconv.Atoi(keys[0]); err != nil && !strings.HasPrefix(keys[0], "$") {
+				return nil, nil, false
+			}
+			t, rules = t.Elem(), diveRules(rules)
+		case reflect.Map:
+			t, rules = t.Elem(), diveRules(rules)
+		default:
+			return nil, nil, false
+		}
+		keys = keys[1:]
+	}
+	if t.Kind() == reflect.Interface {
+		return nil, nil, true
+	}
+	return t, rules, true
+}
+
+func findBsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, inline, ok := bsonField(f)
+		if !ok {
+			continue
+		}
+		if inline {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if ret, ok := findBsonField(ft, key); ok {
+					return ret, true
+				}
+			}
+			continue
+		}
+		if name == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// 字段的bson名称, 规则同驱动: 默认为小写的字段名
+func bsonField(f reflect.StructField) (name string, inline bool, ok bool) {
+	if f.PkgPath != "" {
+		return "", false, false
+	}
+	tag := f.Tag.Get("bson")
+	if tag == "-" {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	for _, p := range parts[1:] {
+		if p == "inline" {
+			inline = true
+		}
+	}
+	name = parts[0]
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name, inline, true
+}
+
+func diveRules(rules []rule) []rule {
+	for i, r := range rules {
+		if r.name == "dive" {
+			return rules[i+1:]
+		}
+	}
+	return nil
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var ruleCache sync.Map // 标签 -> []rule
+
+func parseRules(tag string) []rule {
+	if tag == "" {
+		return nil
+	}
+	if v, ok := ruleCache.Load(tag); ok {
+		return v.([]rule)
+	}
+	var rules []rule
+	for rest := tag; rest != ""; {
+		var part string
+		if strings.HasPrefix(rest, "regex=") {
+			part, rest = rest, ""
+		} else if i := strings.IndexByte(rest, ','); i >= 0 {
+			part, rest = rest[:i], rest[i+1:]
+		} else {
+			part, rest = rest, ""
+		}
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		r := rule{name: part}
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			r.name, r.param = part[:i], part[i+1:]
+		}
+		rules = append(rules, r)
+	}
+	ruleCache.Store(tag, rules)
+	return rules
+}
+
+var regexCache sync.Map // 表达式 -> *regexp.Regexp
+
+func checkRule(r rule, v reflect.Value) (string, bool) {
+	switch r.name {
+	case "required":
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+			return "is required", v.Len() > 0
+		}
+		return "is required", !v.IsZero()
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(r.param, 64)
+		if err != nil {
+			return "invalid rule " + r.name + "=" + r.param, false
+		}
+		size, isLen, ok := measure(v)
+		if !ok {
+			return "rule " + r.name + " not applicable to " + v.Type().String(), false
+		}
+		switch {
+		case r.name == "len":
+			return "length must be " + r.param, size == n
+		case r.name == "min" && isLen:
+			return "length must be at least " + r.param, size >= n
+		case r.name == "min":
+			return "must be at least " + r.param, size >= n
+		case isLen:
+			return "length must be at most " + r.param, size <= n
+		default:
+			return "must be at most " + r.param, size <= n
+		}
+	case "enum":
+		s := fmt.Sprint(v.Interface())
+		for _, e := range strings.Split(r.param, "|") {
+			if e == s {
+				return "", true
+			}
+		}
+		return "must be one of " + r.param, false
+	case "regex":
+		if v.Kind() != reflect.String {
+			return "rule regex not applicable to " + v.Type().String(), false
+		}
+		var re *regexp.Regexp
+		if c, ok := regexCache.Load(r.param); ok {
+			re = c.(*regexp.Regexp)
+		} else {
+			var err error
+			if re, err = regexp.Compile(r.param); err != nil {
+				return "invalid rule regex=" + r.param, false
+			}
+			regexCache.Store(r.param, re)
+		}
+		return "must match " + r.param, re.MatchString(v.String())
+	}
+	return "unknown rule " + r.name, false
+}
+
+// 数值返回其值, 字符串(按字符)/切片/map返回长度
+func measure(v reflect.Value) (size float64, isLen bool, ok bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,118 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+)
+
+type validItem struct {
+	Sku string `bson:"sku" validate:"required,regex=^[A-Z]{2}-\\d+$"`
+	Qty int    `bson:"qty" validate:"min=1,max=99"`
+}
+
+type validOrder struct {
+	Id     int               `bson:"_id"`
+	Status string            `bson:"status" validate:"required,enum=new|paid"`
+	Code   string            `bson:"code" validate:"len=4"`
+	Items  []validItem       `bson:"items" validate:"min=1"`
+	Tags   []string          `bson:"tags" validate:"max=2,dive,min=2"`
+	Meta   map[string]string `bson:"meta"`
+	Note   *string           `bson:"note"`
+}
+
+func fieldErrors(err error) map[string]string {
+	var ve *mongodb.ValidationError
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	ret := make(map[string]string)
+	for _, fe := range ve.Errors {
+		ret[fe.Path] = fe.Rule
+	}
+	return ret
+}
+
+func TestValidate(t *testing.T) {
+	ok := &validOrder{Status: "new", Code: "abcd", Items: []validItem{{Sku: "AB-1", Qty: 1}}, Tags: []string{"xx"}}
+	if err := mongodb.Validate(ok); err != nil {
+		t.Fatal(err)
+	}
+	bad := &validOrder{Status: "done", Code: "abc", Items: []validItem{{Sku: "AB-1", Qty: 1}, {Sku: "ab", Qty: 100}}, Tags: []string{"a", "bb", "cc"}}
+	expect := map[string]string{
+		"status":      "enum",
+		"code":        "len",
+		"items.1.sku": "regex",
+		"items.1.qty": "max",
+		"tags":        "max",
+		"tags.0":      "min",
+	}
+	if got := fieldErrors(mongodb.Validate(bad)); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %v", got)
+	}
+	if got := fieldErrors(mongodb.Validate(&validOrder{Code: "abcd"})); !reflect.DeepEqual(got, map[string]string{"status": "required", "items": "min"}) {
+		t.Fatalf("got %v", got)
+	}
+	// 非struct文档不受影响
+	if err := mongodb.Validate(bson.M{"status": 1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_Validation(t *testing.T) {
+	srv, mdb, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cc := mdb.
+		Model("order", (*validOrder)(nil)).
+		Validation("order", &mongodb.Validation{Update: true})
+	defer cc.Close()
+
+	good := func(id int) *validOrder {
+		return &validOrder{Id: id, Status: "new", Code: "abcd", Items: []validItem{{Sku: "AB-1", Qty: 1}}}
+	}
+	if _, err := cc.InsertOne("order", &validOrder{Id: 1}); fieldErrors(err)["status"] != "required" {
+		t.Fatalf("insert one: %v", err)
+	}
+	var ve *mongodb.ValidationError
+	if _, err := cc.InsertMany("order", []interface{}{good(1), &validOrder{Id: 2, Status: "new", Code: "abcd"}}); !errors.As(err, &ve) || ve.Errors[0].Index != 1 {
+		t.Fatalf("insert many: %v", err)
+	}
+	// 批量操作只有一个文档时同样附下标
+	if _, err := cc.InsertMany("order", []interface{}{&validOrder{Id: 3}}); !errors.As(err, &ve) || ve.Errors[0].Index != 0 {
+		t.Fatalf("insert many one: %v", err)
+	}
+	if n, _ := cc.Count("order", nil); n != 0 {
+		t.Fatalf("written: %v", n)
+	}
+	if _, err := cc.InsertMany("order", []interface{}{good(1), good(2)}); err != nil {
+		t.Fatal(err)
+	}
+	bad := good(1)
+	bad.Items[0].Qty = 0
+	if _, err := cc.ReplaceId("order", 1, bad); fieldErrors(err)["items.0.qty"] != "min" {
+		t.Fatalf("replace: %v", err)
+	}
+	if _, err := cc.UpsertMany("order", []interface{}{good(3), bad}); fieldErrors(err)["items.0.qty"] != "min" {
+		t.Fatalf("upsert many: %v", err)
+	}
+
+	// $set按目标字段类型及规则校验
+	if _, err := cc.UpdateId("order", 1, bson.M{"$set": bson.M{"status": "paid", "items.0.qty": 5, "meta.k": "v"}}); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"code": "type", "items.0.qty": "max", "status": "enum", "unknown": "field"}
+	_, err = cc.UpdateId("order", 1, bson.M{"$set": bson.M{"code": bson.M{"a": 1}, "items.0.qty": 100, "status": "x", "unknown": 1}})
+	if got := fieldErrors(err); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("set: %v %v", got, err)
+	}
+	var o validOrder
+	if _, err := cc.FindId("order", 1, &o); err != nil || o.Status != "paid" || o.Items[0].Qty != 5 {
+		t.Fatalf("stored: %+v %v", o, err)
+	}
+}
@@ -0,0 +1,212 @@
+package mongodb
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+)
+
+const (
+	defaultVersionField  = "version"
+	defaultModifyRetries = 10
+)
+
+// 文档版本已变化, 即读取后被其他写操作修改
+var ErrVersionConflict = errors.New("mongodb version conflict")
+
+// 集合的乐观锁策略, 未设置的集合按默认值处理
+type Versioning struct {
+	Field      string // 版本字段, 默认version, 缺失时视为0
+	MaxRetries int    // Modify冲突时的最大重试次数, 默认10
+}
+
+func (cc *Client) Versioning(cl string, v *Versioning) *Client {
+	return cc.DBVersioning(cc.DB, cl, v)
+}
+
+// 设置集合的乐观锁策略, v为nil时恢复默认
+func (cc *Client) DBVersioning(db string, cl string, v *Versioning) *Client {
+	if v == nil {
+		cc.versionings.Delete(db + "." + cl)
+		return cc
+	}
+	s := *v
+	if s.Field == "" {
+		s.Field = defaultVersionField
+	}
+	if s.MaxRetries <= 0 {
+		s.MaxRetries = defaultModifyRetries
+	}
+	cc.versionings.Store(db+"."+cl, &s)
+	return cc
+}
+
+func (cc *Client) versioningOf(db string, cl string) *Versioning {
+	if cc.versionings != nil {
+		if v, ok := cc.versionings.Load(db + "." + cl); ok {
+			return v.(*Versioning)
+		}
+	}
+	return &Versioning{Field: defaultVersionField, MaxRetries: defaultModifyRetries}
+}
+
+// 匹配_id及版本, 版本0同时匹配缺失版本字段的文档
+func (v *Versioning) filter(id interface{}, version int64) bson.D {
+	if version == 0 {
+		return bson.D{{Key: "_id", Value: id}, {Key: v.Field, Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}}}
+	}
+	return bson.D{{Key: "_id", Value: id}, {Key: v.Field, Value: version}}
+}
+
+func (cc *Client) UpdateIdVersion(cl string, id interface{}, version int64, update interface{}) error {
+	return cc.DBUpdateIdVersion(cc.DB, cl, id, version, update)
+}
+
+// 仅当文档版本为version时更新, 同时版本加1. 版本不符返回ErrVersionConflict, 文档不存在返回mongo.ErrNoDocuments, 均经*Error包装, 以errors.Is判断
+func (cc *Client) DBUpdateIdVersion(db string, cl string, id interface{}, version int64, update interface{}) error {
+	v := cc.versioningOf(db, cl)
+	if pipeline, ok := toPipeline(update); ok {
+		update = append(pipeline, bson.D{{Key: "$set", Value: bson.D{{Key: v.Field, Value: version + 1}}}})
+	} else {
+		d, err := toD(update)
+		if err != nil {
+			return err
+		}
+		if update, err = addToOperator(d, "$set", v.Field, version+1); err != nil {
+			return err
+		}
+	}
+	op := &Operation{Database: db, Collection: cl, Name: Op_UpdateOne, Filter: v.filter(id, version), Update: update}
+	ret, err := cc.DBUpdateOne(db, cl, op.Filter, update)
+	if err != nil {
+		return err
+	}
+	return cc.versionResult(op, id, ret.MatchedCount)
+}
+
+func (cc *Client) ReplaceIdVersion(cl string, id interface{}, version int64, doc interface{}) error {
+	return cc.DBReplaceIdVersion(cc.DB, cl, id, version, doc)
+}
+
+// 仅当文档版本为version时替换, 写入的版本为version+1. 错误同DBUpdateIdVersion
+func (cc *Client) DBReplaceIdVersion(db string, cl string, id interface{}, version int64, doc interface{}) error {
+	_, err := cc.replaceIdVersion(db, cl, id, version, doc)
+	return err
+}
+
+func (cc *Client) replaceIdVersion(db string, cl string, id interface{}, version int64, doc interface{}) (bson.D, error) {
+	v := cc.versioningOf(db, cl)
+	d, err := toD(doc)
+	if err != nil {
+		return nil, err
+	}
+	d = setField(d, v.Field, version+1)
+	op := &Operation{Database: db, Collection: cl, Name: Op_ReplaceOne, Filter: v.filter(id, version), Update: d}
+	ret, err := cc.DBReplaceOne(db, cl, op.Filter, d)
+	if err != nil {
+		return nil, err
+	}
+	return d, cc.versionResult(op, id, ret.MatchedCount)
+}
+
+// 未匹配时按_id读取一次区分版本冲突与文档不存在
+func (cc *Client) versionResult(op *Operation, id interface{}, matched int64) error {
+	if matched > 0 {
+		return nil
+	}
+	not, err := cc.DBFindId(op.Database, op.Collection, id, nil, options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	if not {
+		return cc.wrapError(op, mongo.ErrNoDocuments)
+	}
+	return cc.wrapError(op, ErrVersionConflict)
+}
+
+func (cc *Client) Modify(cl string, id interface{}, doc interface{}, fn func(doc interface{}) error) error {
+	return cc.DBModify(cc.DB, cl, id, doc, fn)
+}
+
+// 乐观锁的读-改-写: 读取文档至doc(非nil指针, 每次读取前清空), 调用fn修改后以ReplaceIdVersion写回, 版本冲突时重新读取重试, 最多MaxRetries次.
+// 成功后doc中的版本字段为新版本; fn返回错误时中止且不写入; 文档不存在返回mongo.ErrNoDocuments; 重试耗尽返回ErrVersionConflict, 均经*Error包装
+func (cc *Client) DBModify(db string, cl string, id interface{}, doc interface{}, fn func(doc interface{}) error) error {
+	rv := reflect.ValueOf(doc)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("mongodb modify doc must be a non-nil pointer")
+	}
+	v := cc.versioningOf(db, cl)
+	for i := 0; ; i++ {
+		// 每次读取至新值再复制, 解码不清空已有字段, 避免上一次fn的修改残留
+		fresh := reflect.New(rv.Elem().Type())
+		not, err := cc.DBFindId(db, cl, id, fresh.Interface())
+		if err != nil {
+			return err
+		}
+		if not {
+			return cc.wrapError(&Operation{Database: db, Collection: cl, Name: Op_FindId, Filter: bson.M{"_id": id}}, mongo.ErrNoDocuments)
+		}
+		rv.Elem().Set(fresh.Elem())
+		d, err := toD(doc)
+		if err != nil {
+			return err
+		}
+		version, err := versionOf(d, v.Field)
+		if err != nil {
+			return err
+		}
+		if err = fn(doc); err != nil {
+			return err
+		}
+		d, err = cc.replaceIdVersion(db, cl, id, version, doc)
+		if err == nil {
+			// 回写新版本
+			bs, err := bson.Marshal(d)
+			if err != nil {
+				return err
+			}
+			return bson.Unmarshal(bs, doc)
+		}
+		if !errors.Is(err, ErrVersionConflict) || i >= v.MaxRetries {
+			return err
+		}
+	}
+}
+
+func versionOf(d bson.D, field string) (int64, error) {
+	for _, e := range d {
+		if e.Key != field {
+			continue
+		}
+		val, ok := e.Value.(bson.RawValue)
+		if !ok {
+			break
+		}
+		switch val.Type {
+		case bsontype.Int32:
+			return int64(val.Int32()), nil
+		case bsontype.Int64:
+			return val.Int64(), nil
+		case bsontype.Double:
+			return int64(val.Double()), nil
+		case bsontype.Null, bsontype.Undefined:
+			return 0, nil
+		}
+		return 0, errors.New("mongodb version field " + field + " is not a number")
+	}
+	return 0, nil
+}
+
+// 设置字段, 不存在时追加
+func setField(d bson.D, key string, val interface{}) bson.D {
+	for i, e := range d {
+		if e.Key == key {
+			d[i].Value = val
+			return d
+		}
+	}
+	return append(d, bson.E{Key: key, Value: val})
+}
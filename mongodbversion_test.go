@@ -0,0 +1,106 @@
+package mongodb_test
+
+import (
+	"errors"
+	"github.com/obase/mongodb"
+	"github.com/obase/mongodb/mongodbtest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+type versionedUser struct {
+	Id      int    `bson:"_id"`
+	Name    string `bson:"name"`
+	N       int    `bson:"n"`
+	Version int64  `bson:"version"`
+}
+
+func TestClient_Version(t *testing.T) {
+	srv, cc, err := mongodbtest.Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer cc.Close()
+
+	if _, err := cc.InsertOne("user", bson.M{"_id": 1, "name": "tom"}); err != nil {
+		t.Fatal(err)
+	}
+	// 缺失版本字段视为0
+	if err := cc.UpdateIdVersion("user", 1, 0, bson.M{"$set": bson.M{"n": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	err = cc.UpdateIdVersion("user", 1, 0, bson.M{"$set": bson.M{"n": 2}})
+	var me *mongodb.Error
+	if !errors.Is(err, mongodb.ErrVersionConflict) || !errors.As(err, &me) || me.Collection != "user" {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if err := cc.ReplaceIdVersion("user", 1, 1, versionedUser{Id: 1, Name: "jerry"}); err != nil {
+		t.Fatal(err)
+	}
+	var u versionedUser
+	if _, err := cc.FindId("user", 1, &u); err != nil || u.Version != 2 || u.Name != "jerry" || u.N != 0 {
+		t.Fatalf("replaced: %+v %v", u, err)
+	}
+	if err := cc.ReplaceIdVersion("user", 2, 0, bson.M{}); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("expect no documents, got %v", err)
+	}
+
+	// 第一次修改期间被并发写入, Modify重新读取后成功
+	calls := 0
+	err = cc.Modify("user", 1, &u, func(doc interface{}) error {
+		calls++
+		if calls == 1 {
+			if err := cc.UpdateIdVersion("user", 1, 2, bson.M{"$inc": bson.M{"n": 10}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		doc.(*versionedUser).N++
+		return nil
+	})
+	if err != nil || calls != 2 || u.Version != 4 || u.N != 11 {
+		t.Fatalf("modify: %+v %v %v", u, calls, err)
+	}
+	var m bson.M
+	if _, err := cc.FindId("user", 1, &m); err != nil || m["n"] != int32(11) || m["version"] != int64(4) {
+		t.Fatalf("modified: %v %v", m, err)
+	}
+
+	// 持续冲突时重试耗尽
+	cc.Versioning("user", &mongodb.Versioning{MaxRetries: 2})
+	calls = 0
+	err = cc.Modify("user", 1, &u, func(doc interface{}) error {
+		calls++
+		_, err := cc.UpdateId("user", 1, bson.M{"$inc": bson.M{"version": 1}})
+		return err
+	})
+	if !errors.Is(err, mongodb.ErrVersionConflict) || calls != 3 {
+		t.Fatalf("exhausted: %v %v", calls, err)
+	}
+	abort := errors.New("abort")
+	if err := cc.Modify("user", 1, &u, func(doc interface{}) error { return abort }); err != abort {
+		t.Fatalf("abort: %v", err)
+	}
+
+	// 重试时重新读取的文档不含上一次fn添加的字段
+	cc.Versioning("user", nil)
+	calls = 0
+	m = nil
+	err = cc.Modify("user", 1, &m, func(doc interface{}) error {
+		calls++
+		if calls == 1 {
+			(*doc.(*bson.M))["draft"] = true
+			_, err := cc.UpdateId("user", 1, bson.M{"$inc": bson.M{"version": 1}})
+			return err
+		}
+		(*doc.(*bson.M))["name"] = "spike"
+		return nil
+	})
+	if err != nil || calls != 2 || m["draft"] != nil || m["name"] != "spike" {
+		t.Fatalf("modify fresh: %v %v %v", m, calls, err)
+	}
+	if _, err := cc.FindId("user", 1, &m); err != nil || m["draft"] != nil {
+		t.Fatalf("modified fresh: %v %v", m, err)
+	}
+}
@@ -0,0 +1,421 @@
+package mongodb
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrWriterClosed = errors.New("mongodb bulk writer closed")
+
+const (
+	defaultWriterBatchSize     = 1000
+	defaultWriterFlushInterval = time.Second
+)
+
+// 异步批量写入选项
+type BulkWriterOptions struct {
+	BatchSize     int           // 满批即写, 默认1000
+	FlushInterval time.Duration // 未满批时的定时写入间隔, 默认1秒
+	BufferSize    int           // 缓冲的最大条数, 满时Write阻塞, 默认BatchSize的4倍
+	Ordered       bool          // 批内有序, 出错后未执行的model会在下一轮重新写入
+	Retry         *RetryPolicy  // 失败model的重试策略, 为nil时不重试. 结果未知的错误(如网络)仅重试幂等model, 重试间隔内继续写入其他model
+
+	OnError func(model mongo.WriteModel, err error) // 最终失败的model回调, 在独立goroutine中按序调用, 可调用Write, 不可调用Flush/Close
+}
+
+// 异步批量写入统计
+type BulkWriterStats struct {
+	Written int64 // 成功写入的model数
+	Failed  int64 // 最终失败的model数
+	Retried int64 // 重试的model次数
+	Batches int64 // 执行BulkWrite的次数
+	Pending int64 // 缓冲中待写入的model数
+}
+
+type writerModel struct {
+	model    mongo.WriteModel
+	attempts int
+	due      time.Time // 重试的到期时间
+}
+
+// 异步批量写入器, 可被多个goroutine并发调用. 由单个后台goroutine按批写入, 用完须Close
+type BulkWriter struct {
+	cc  *Client
+	db  string
+	cl  string
+	opt BulkWriterOptions
+
+	mux     sync.RWMutex
+	closed  bool
+	ch      chan *writerModel
+	flushes chan chan struct{}
+	exited  chan struct{}
+	stats   BulkWriterStats
+
+	// OnError回调队列, 由独立goroutine执行, 避免回调中Write阻塞写入goroutine
+	cbMux    sync.Mutex
+	cbCond   *sync.Cond
+	cbQueue  []func()
+	cbClosed bool
+	cbExited chan struct{}
+
+	bulkWrite func(models []mongo.WriteModel) (*mongo.BulkWriteResult, error)
+}
+
+func (cc *Client) BulkWriter(cl string, opt *BulkWriterOptions) *BulkWriter {
+	return cc.DBBulkWriter(cc.DB, cl, opt)
+}
+
+func (cc *Client) DBBulkWriter(db string, cl string, opt *BulkWriterOptions) *BulkWriter {
+	w := &BulkWriter{cc: cc, db: db, cl: cl}
+	if opt != nil {
+		w.opt = *opt
+	}
+	if w.opt.BatchSize <= 0 {
+		w.opt.BatchSize = defaultWriterBatchSize
+	}
+	if w.opt.FlushInterval <= 0 {
+		w.opt.FlushInterval = defaultWriterFlushInterval
+	}
+	if w.opt.BufferSize <= 0 {
+		w.opt.BufferSize = w.opt.BatchSize * 4
+	}
+	w.bulkWrite = func(models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		return cc.DBBulkWrite(db, cl, models, options.BulkWrite().SetOrdered(w.opt.Ordered))
+	}
+	w.ch = make(chan *writerModel, w.opt.BufferSize)
+	w.flushes = make(chan chan struct{})
+	w.exited = make(chan struct{})
+	w.cbCond = sync.NewCond(&w.cbMux)
+	w.cbExited = make(chan struct{})
+	go w.callbacks()
+	go w.loop()
+	return w
+}
+
+func (w *BulkWriter) Insert(doc interface{}) error {
+	return w.Write(mongo.NewInsertOneModel().SetDocument(doc))
+}
+
+func (w *BulkWriter) Update(filter interface{}, update interface{}) error {
+	return w.Write(mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update))
+}
+
+func (w *BulkWriter) Upsert(filter interface{}, update interface{}) error {
+	return w.Write(mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+}
+
+func (w *BulkWriter) Delete(filter interface{}) error {
+	return w.Write(mongo.NewDeleteOneModel().SetFilter(filter))
+}
+
+// 加入缓冲, 缓冲满时阻塞直至有空位. Close之后返回ErrWriterClosed
+func (w *BulkWriter) Write(models ...mongo.WriteModel) error {
+	w.mux.RLock()
+	defer w.mux.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	for _, m := range models {
+		w.ch <- &writerModel{model: m}
+	}
+	return nil
+}
+
+// 写入当前缓冲中的全部model, 并等待其中的重试及OnError回调完成后返回
+func (w *BulkWriter) Flush() error {
+	done := make(chan struct{})
+	select {
+	case w.flushes <- done:
+	case <-w.exited:
+		return ErrWriterClosed
+	}
+	<-done
+	return nil
+}
+
+// 停止接收并写入剩余model, 待重试的model不再等待重试间隔, 可重复调用
+func (w *BulkWriter) Close() error {
+	w.mux.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+	w.mux.Unlock()
+	<-w.exited
+	<-w.cbExited
+	return nil
+}
+
+func (w *BulkWriter) Stats() BulkWriterStats {
+	return BulkWriterStats{
+		Written: atomic.LoadInt64(&w.stats.Written),
+		Failed:  atomic.LoadInt64(&w.stats.Failed),
+		Retried: atomic.LoadInt64(&w.stats.Retried),
+		Batches: atomic.LoadInt64(&w.stats.Batches),
+		Pending: int64(len(w.ch)),
+	}
+}
+
+func (w *BulkWriter) loop() {
+	defer close(w.exited)
+	defer w.callback(nil)
+	ticker := time.NewTicker(w.opt.FlushInterval)
+	defer ticker.Stop()
+	// 重试间隔不阻塞写入goroutine, 到期后再写入
+	retryTimer := time.NewTimer(time.Hour)
+	retryTimer.Stop()
+	defer retryTimer.Stop()
+
+	var delayed []*writerModel  // 等待重试的model
+	var waiting []chan struct{} // 等待重试完成的Flush
+	write := func(batch []*writerModel) {
+		retry, backoff := w.write(batch)
+		if len(retry) > 0 {
+			due := time.Now().Add(backoff)
+			for _, m := range retry {
+				m.due = due
+			}
+			delayed = append(delayed, retry...)
+		}
+	}
+	// 写入到期(force时全部)的重试model, 并按最早的到期时间重置定时器
+	retryDue := func(force bool) {
+		now := time.Now()
+		var due, later []*writerModel
+		for _, m := range delayed {
+			if force || !m.due.After(now) {
+				due = append(due, m)
+			} else {
+				later = append(later, m)
+			}
+		}
+		delayed = later
+		if len(due) > 0 {
+			write(due)
+		}
+		if len(delayed) == 0 {
+			for _, done := range waiting {
+				done := done
+				w.callback(func() {
+					close(done)
+				})
+			}
+			waiting = nil
+			return
+		}
+		next := delayed[0].due
+		for _, m := range delayed[1:] {
+			if m.due.Before(next) {
+				next = m.due
+			}
+		}
+		retryTimer.Stop()
+		select {
+		case <-retryTimer.C:
+		default:
+		}
+		retryTimer.Reset(time.Until(next))
+	}
+
+	batch := make([]*writerModel, 0, w.opt.BatchSize)
+	add := func(m *writerModel) {
+		if batch = append(batch, m); len(batch) >= w.opt.BatchSize {
+			write(batch)
+			batch = make([]*writerModel, 0, w.opt.BatchSize)
+		}
+	}
+	for {
+		select {
+		case m, ok := <-w.ch:
+			if !ok {
+				write(batch)
+				// 关闭时不再等待重试间隔
+				for len(delayed) > 0 {
+					retryDue(true)
+				}
+				return
+			}
+			add(m)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				write(batch)
+				batch = make([]*writerModel, 0, w.opt.BatchSize)
+			}
+		case <-retryTimer.C:
+			retryDue(false)
+		case done := <-w.flushes:
+		drain:
+			for {
+				select {
+				case m, ok := <-w.ch:
+					if !ok {
+						break drain
+					}
+					add(m)
+				default:
+					break drain
+				}
+			}
+			write(batch)
+			batch = make([]*writerModel, 0, w.opt.BatchSize)
+			// 待重试的model写完后通知, 期间继续接收写入
+			waiting = append(waiting, done)
+			retryDue(false)
+		}
+	}
+}
+
+// 加入回调队列, fn为nil表示不再加入
+func (w *BulkWriter) callback(fn func()) {
+	w.cbMux.Lock()
+	if fn == nil {
+		w.cbClosed = true
+	} else {
+		w.cbQueue = append(w.cbQueue, fn)
+	}
+	w.cbMux.Unlock()
+	w.cbCond.Signal()
+}
+
+func (w *BulkWriter) callbacks() {
+	defer close(w.cbExited)
+	for {
+		w.cbMux.Lock()
+		for len(w.cbQueue) == 0 && !w.cbClosed {
+			w.cbCond.Wait()
+		}
+		queue := w.cbQueue
+		w.cbQueue = nil
+		w.cbMux.Unlock()
+		if len(queue) == 0 {
+			return
+		}
+		for _, fn := range queue {
+			fn()
+		}
+	}
+}
+
+// 写入一批, 返回按策略需重试的model及重试间隔
+func (w *BulkWriter) write(batch []*writerModel) ([]*writerModel, time.Duration) {
+	op := &Operation{Database: w.db, Collection: w.cl, Name: Op_BulkWrite}
+	if len(batch) == 0 {
+		return nil, 0
+	}
+	models := make([]mongo.WriteModel, len(batch))
+	for i, m := range batch {
+		models[i] = m.model
+		m.attempts++
+	}
+	_, err := w.bulkWrite(models)
+	atomic.AddInt64(&w.stats.Batches, 1)
+	if err == nil {
+		atomic.AddInt64(&w.stats.Written, int64(len(batch)))
+		return nil, 0
+	}
+
+	var retry []*writerModel
+	backoff := 0
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) {
+		executed := len(batch)
+		if w.opt.Ordered && len(bwe.WriteErrors) > 0 {
+			executed = bwe.WriteErrors[0].Index + 1
+		}
+		failed := make(map[int]bool, len(bwe.WriteErrors))
+		for _, we := range bwe.WriteErrors {
+			if we.Index < 0 || we.Index >= len(batch) {
+				continue
+			}
+			failed[we.Index] = true
+			m := batch[we.Index]
+			single := we
+			single.Index = 0
+			merr := w.cc.wrapError(op, mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{single}, Labels: bwe.Labels})
+			// 写错误表示服务端确认未写入, 重试是安全的
+			if w.retryable(m, merr, true) {
+				retry = append(retry, m)
+				if m.attempts > backoff {
+					backoff = m.attempts
+				}
+			} else {
+				w.fail(m, merr)
+			}
+		}
+		for i := 0; i < executed; i++ {
+			if failed[i] {
+				continue
+			}
+			if bwe.WriteConcernError != nil {
+				// 已写入但未满足写关注, 不重试
+				w.fail(batch[i], w.cc.wrapError(op, mongo.BulkWriteException{WriteConcernError: bwe.WriteConcernError}))
+			} else {
+				atomic.AddInt64(&w.stats.Written, 1)
+			}
+		}
+		// 有序写入出错后未执行的model, 不计入尝试次数
+		for _, m := range batch[executed:] {
+			m.attempts--
+			retry = append(retry, m)
+		}
+	} else {
+		for _, m := range batch {
+			if w.retryable(m, err, false) {
+				retry = append(retry, m)
+				if m.attempts > backoff {
+					backoff = m.attempts
+				}
+			} else {
+				w.fail(m, err)
+			}
+		}
+	}
+	if backoff == 0 {
+		return retry, 0 // 仅有未执行的model
+	}
+	atomic.AddInt64(&w.stats.Retried, int64(len(retry)))
+	return retry, w.opt.Retry.backoff(backoff)
+}
+
+// definite表示服务端明确返回了该model的写错误
+func (w *BulkWriter) retryable(m *writerModel, err error, definite bool) bool {
+	p := w.opt.Retry
+	if p == nil || m.attempts >= p.MaxAttempts || !p.retryable(err) {
+		return false
+	}
+	return definite || p.idempotent(modelOperation(m.model))
+}
+
+func (w *BulkWriter) fail(m *writerModel, err error) {
+	atomic.AddInt64(&w.stats.Failed, 1)
+	if w.opt.OnError != nil {
+		model := m.model
+		w.callback(func() {
+			w.opt.OnError(model, err)
+		})
+	}
+}
+
+// 转换为等价的单条操作, 用于判断幂等
+func modelOperation(model mongo.WriteModel) *Operation {
+	switch m := model.(type) {
+	case *mongo.InsertOneModel:
+		return &Operation{Name: Op_InsertOne, Document: m.Document}
+	case *mongo.UpdateOneModel:
+		return &Operation{Name: Op_UpdateOne, Filter: m.Filter, Update: m.Update}
+	case *mongo.UpdateManyModel:
+		return &Operation{Name: Op_UpdateMany, Filter: m.Filter, Update: m.Update}
+	case *mongo.ReplaceOneModel:
+		return &Operation{Name: Op_ReplaceOne, Filter: m.Filter, Update: m.Replacement}
+	case *mongo.DeleteOneModel:
+		return &Operation{Name: Op_DeleteOne, Filter: m.Filter}
+	case *mongo.DeleteManyModel:
+		return &Operation{Name: Op_DeleteMany, Filter: m.Filter}
+	}
+	return &Operation{Name: Op_BulkWrite}
+}
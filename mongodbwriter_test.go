@@ -0,0 +1,180 @@
+package mongodb
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkWriter(t *testing.T) {
+	var mux sync.Mutex
+	var batches [][]mongo.WriteModel
+	w := new(Client).BulkWriter("user", &BulkWriterOptions{BatchSize: 10, FlushInterval: time.Hour, BufferSize: 5})
+	w.bulkWrite = func(models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		mux.Lock()
+		batches = append(batches, models)
+		mux.Unlock()
+		return &mongo.BulkWriteResult{}, nil
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 5; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if err := w.Insert(bson.M{"_id": g*100 + i}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	w.Delete(bson.M{"_id": 1})
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 11 || len(batches[10]) != 1 {
+		t.Fatalf("unexpected batches: %v", len(batches))
+	}
+	w.Upsert(bson.M{"_id": 2}, bson.M{"$set": bson.M{"a": 1}})
+	w.Close()
+	if err := w.Insert(bson.M{}); err != ErrWriterClosed {
+		t.Fatalf("expect closed, got %v", err)
+	}
+	if st := w.Stats(); st.Written != 102 || st.Batches != 12 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestBulkWriter_Retry(t *testing.T) {
+	netErr := mongo.CommandError{Labels: []string{ErrorLabel_NetworkError}}
+	var calls int
+	var failed []mongo.WriteModel
+	w := new(Client).BulkWriter("user", &BulkWriterOptions{
+		BatchSize: 100,
+		Ordered:   true,
+		Retry:     &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond},
+		OnError: func(model mongo.WriteModel, err error) {
+			failed = append(failed, model)
+			if !IsDuplicateKey(err) && !IsNetwork(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		},
+	})
+	w.bulkWrite = func(models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		calls++
+		switch calls {
+		case 1: // 网络错误, 结果未知, 仅重试幂等model
+			return nil, netErr
+		case 2: // 有序写入第2条重复键, 之后未执行
+			return nil, mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: ErrorCode_DuplicateKey}}}}
+		}
+		return &mongo.BulkWriteResult{}, nil
+	}
+	w.Write(
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": 1}),
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": 2}),
+		mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": 1}).SetUpdate(bson.M{"$inc": bson.M{"n": 1}}),
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": 3}),
+	)
+	w.Close()
+
+	// 第1轮$inc被放弃; 第2轮_id:2重复键(非可重试), _id:3未执行; 第3轮写入_id:3
+	if calls != 3 || len(failed) != 2 {
+		t.Fatalf("unexpected: %v calls, %v failed", calls, len(failed))
+	}
+	if st := w.Stats(); st.Written != 2 || st.Failed != 2 || st.Retried != 3 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestBulkWriter_OnErrorWrite(t *testing.T) {
+	var w *BulkWriter
+	var requeued int
+	w = new(Client).BulkWriter("user", &BulkWriterOptions{
+		BatchSize:  1,
+		BufferSize: 1,
+		OnError: func(model mongo.WriteModel, err error) {
+			// 缓冲已满时在回调中Write不会阻塞写入goroutine
+			if requeued++; requeued <= 3 {
+				w.Write(model, model)
+			}
+		},
+	})
+	var mux sync.Mutex
+	var calls int
+	w.bulkWrite = func(models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		if calls++; calls <= 3 {
+			return nil, errors.New("boom")
+		}
+		return &mongo.BulkWriteResult{}, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		w.Insert(bson.M{"_id": 1})
+		w.Flush()
+		w.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock writing from OnError")
+	}
+	if st := w.Stats(); st.Failed < 1 || st.Written+st.Failed != st.Batches {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestBulkWriter_RetryDelay(t *testing.T) {
+	netErr := mongo.CommandError{Labels: []string{ErrorLabel_NetworkError}}
+	written := make(chan interface{}, 4)
+	w := new(Client).BulkWriter("user", &BulkWriterOptions{
+		BatchSize: 1,
+		Retry:     &RetryPolicy{MaxAttempts: 3, MinBackoff: time.Hour, MaxBackoff: time.Hour},
+	})
+	var mux sync.Mutex
+	var calls int
+	w.bulkWrite = func(models []mongo.WriteModel) (*mongo.BulkWriteResult, error) {
+		mux.Lock()
+		calls++
+		first := calls == 1
+		mux.Unlock()
+		if first {
+			return nil, netErr
+		}
+		written <- models[0].(*mongo.InsertOneModel).Document
+		return &mongo.BulkWriteResult{}, nil
+	}
+	w.Insert(bson.M{"_id": 1})
+	w.Insert(bson.M{"_id": 2})
+	// 重试间隔内继续写入其他model
+	select {
+	case doc := <-written:
+		if doc.(bson.M)["_id"] != 2 {
+			t.Fatalf("unexpected write: %v", doc)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes blocked by retry backoff")
+	}
+	// 关闭时不再等待重试间隔
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked by retry backoff")
+	}
+	if st := w.Stats(); st.Written != 2 || st.Retried != 1 || st.Failed != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}